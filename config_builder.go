@@ -0,0 +1,161 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-viper/mapstructure/v2/internal/errors"
+)
+
+// ConfigBuilder builds a DecoderConfig step by step, validating the
+// combination of options when Build is called. It is an alternative to
+// creating a DecoderConfig directly.
+//
+//	config, err := mapstructure.NewConfigBuilder().
+//	    Result(&result).
+//	    WeaklyTyped().
+//	    Hook(mapstructure.StringToTimeDurationHookFunc()).
+//	    Build()
+type ConfigBuilder struct {
+	config        DecoderConfig
+	hooks         []DecodeHookFunc
+	tagNameSet    bool
+	matchNameSet  bool
+	caseSensitive bool
+}
+
+// NewConfigBuilder returns a ConfigBuilder for an empty DecoderConfig.
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// Result sets the pointer the decoded value is stored in.
+func (b *ConfigBuilder) Result(result interface{}) *ConfigBuilder {
+	b.config.Result = result
+	return b
+}
+
+// Metadata sets the Metadata to track while decoding.
+func (b *ConfigBuilder) Metadata(metadata *Metadata) *ConfigBuilder {
+	b.config.Metadata = metadata
+	return b
+}
+
+// Hook adds a DecodeHook. Hooks added with multiple calls are composed
+// with ComposeDecodeHookFunc, in the order they were added.
+func (b *ConfigBuilder) Hook(hook DecodeHookFunc) *ConfigBuilder {
+	b.hooks = append(b.hooks, hook)
+	return b
+}
+
+// TagName sets the tag name that is read for field names.
+func (b *ConfigBuilder) TagName(tagName string) *ConfigBuilder {
+	b.config.TagName = tagName
+	b.tagNameSet = true
+	return b
+}
+
+// MatchName sets the function used to match map keys to field names. It
+// cannot be combined with CaseSensitive.
+func (b *ConfigBuilder) MatchName(matchName func(mapKey, fieldName string) bool) *ConfigBuilder {
+	b.config.MatchName = matchName
+	b.matchNameSet = true
+	return b
+}
+
+// CaseSensitive makes map keys match field names only if they are equal.
+// It cannot be combined with MatchName.
+func (b *ConfigBuilder) CaseSensitive() *ConfigBuilder {
+	b.caseSensitive = true
+	return b
+}
+
+// WeaklyTyped enables WeaklyTypedInput. It cannot be combined with Strict.
+func (b *ConfigBuilder) WeaklyTyped() *ConfigBuilder {
+	b.config.WeaklyTypedInput = true
+	return b
+}
+
+// Strict enables Strict. It cannot be combined with WeaklyTyped.
+func (b *ConfigBuilder) Strict() *ConfigBuilder {
+	b.config.Strict = true
+	return b
+}
+
+// ErrorUnused enables ErrorUnused.
+func (b *ConfigBuilder) ErrorUnused() *ConfigBuilder {
+	b.config.ErrorUnused = true
+	return b
+}
+
+// ErrorUnset enables ErrorUnset.
+func (b *ConfigBuilder) ErrorUnset() *ConfigBuilder {
+	b.config.ErrorUnset = true
+	return b
+}
+
+// ZeroFields enables ZeroFields.
+func (b *ConfigBuilder) ZeroFields() *ConfigBuilder {
+	b.config.ZeroFields = true
+	return b
+}
+
+// Squash enables Squash.
+func (b *ConfigBuilder) Squash() *ConfigBuilder {
+	b.config.Squash = true
+	return b
+}
+
+// Build validates the options and returns the resulting DecoderConfig.
+// All invalid options are reported in the returned error.
+func (b *ConfigBuilder) Build() (*DecoderConfig, error) {
+	var errs []error
+
+	if b.config.Result == nil {
+		errs = append(errs, errors.New("result must be set"))
+	} else if reflect.ValueOf(b.config.Result).Kind() != reflect.Ptr {
+		errs = append(errs, errors.New("result must be a pointer"))
+	}
+
+	if b.tagNameSet && b.config.TagName == "" {
+		errs = append(errs, errors.New("tag name must not be empty"))
+	}
+
+	if b.matchNameSet && b.config.MatchName == nil {
+		errs = append(errs, errors.New("match name function must not be nil"))
+	}
+	if b.matchNameSet && b.caseSensitive {
+		errs = append(errs, errors.New("MatchName and CaseSensitive cannot be combined"))
+	}
+
+	if b.config.Strict && b.config.WeaklyTypedInput {
+		errs = append(errs, errors.New("Strict and WeaklyTyped cannot be combined"))
+	}
+
+	for i, hook := range b.hooks {
+		if hook == nil {
+			errs = append(errs, fmt.Errorf("hook %d must not be nil", i))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	config := b.config
+	if b.caseSensitive {
+		config.MatchName = func(mapKey, fieldName string) bool {
+			return mapKey == fieldName
+		}
+	}
+
+	switch len(b.hooks) {
+	case 0:
+	case 1:
+		config.DecodeHook = b.hooks[0]
+	default:
+		config.DecodeHook = ComposeDecodeHookFunc(b.hooks...)
+	}
+
+	return &config, nil
+}
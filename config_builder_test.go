@@ -0,0 +1,125 @@
+package mapstructure
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigBuilder(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Name    string        `config:"name"`
+		Port    int           `config:"port"`
+		Timeout time.Duration `config:"timeout"`
+		Tags    []string      `config:"tags"`
+	}
+
+	var md Metadata
+	var result Target
+	config, err := NewConfigBuilder().
+		Result(&result).
+		Metadata(&md).
+		TagName("config").
+		WeaklyTyped().
+		Hook(StringToTimeDurationHookFunc()).
+		Hook(StringToSliceHookFunc(",")).
+		CaseSensitive().
+		Build()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"name":    "foo",
+		"port":    "8080",
+		"timeout": "5s",
+		"tags":    "a,b",
+		"Name":    "ignored",
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Name:    "foo",
+		Port:    8080,
+		Timeout: 5 * time.Second,
+		Tags:    []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+	if !reflect.DeepEqual([]string{"Name"}, md.Unused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+}
+
+func TestConfigBuilder_Invalid(t *testing.T) {
+	t.Parallel()
+
+	var result struct{}
+	cases := []struct {
+		name    string
+		builder *ConfigBuilder
+		err     string
+	}{
+		{
+			"no result",
+			NewConfigBuilder(),
+			"result must be set",
+		},
+		{
+			"result not a pointer",
+			NewConfigBuilder().Result(result),
+			"result must be a pointer",
+		},
+		{
+			"empty tag name",
+			NewConfigBuilder().Result(&result).TagName(""),
+			"tag name must not be empty",
+		},
+		{
+			"nil match name",
+			NewConfigBuilder().Result(&result).MatchName(nil),
+			"match name function must not be nil",
+		},
+		{
+			"match name and case sensitive",
+			NewConfigBuilder().Result(&result).MatchName(strings.EqualFold).CaseSensitive(),
+			"MatchName and CaseSensitive cannot be combined",
+		},
+		{
+			"strict and weakly typed",
+			NewConfigBuilder().Result(&result).Strict().WeaklyTyped(),
+			"Strict and WeaklyTyped cannot be combined",
+		},
+		{
+			"nil hook",
+			NewConfigBuilder().Result(&result).Hook(nil),
+			"hook 0 must not be nil",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := tc.builder.Build()
+			if err == nil {
+				t.Fatalf("expected error, got config %#v", config)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
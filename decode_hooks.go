@@ -2,14 +2,24 @@ package mapstructure
 
 import (
 	"encoding"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image/color"
+	"math"
+	"math/big"
 	"net"
 	"net/netip"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // typedDecodeHook takes a raw DecodeHookFunc (an interface{}) and turns
@@ -19,10 +29,12 @@ func typedDecodeHook(h DecodeHookFunc) DecodeHookFunc {
 	var f1 DecodeHookFuncType
 	var f2 DecodeHookFuncKind
 	var f3 DecodeHookFuncValue
+	var f4 DecodeHookFuncReflect
+	var f5 DecodeHookFuncField
 
 	// Fill in the variables into this interface and the rest is done
 	// automatically using the reflect package.
-	potential := []interface{}{f1, f2, f3}
+	potential := []interface{}{f1, f2, f3, f4, f5}
 
 	v := reflect.ValueOf(h)
 	vt := v.Type()
@@ -43,6 +55,32 @@ func DecodeHookExec(
 	raw DecodeHookFunc,
 	from reflect.Value, to reflect.Value,
 ) (interface{}, error) {
+	return decodeHookExecField(raw, from, to, reflect.StructField{})
+}
+
+// UnderlyingKind returns the kind of t, or of the type t points to if t is
+// a pointer, following any number of pointers. Like reflect.Type.Kind, it
+// returns the underlying kind of named types, so both Level and *Level
+// have the kind reflect.Int given `type Level int`.
+func UnderlyingKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+// decodeHookExecField is like DecodeHookExec, but passes field to
+// DecodeHookFuncField hooks.
+func decodeHookExecField(
+	raw DecodeHookFunc,
+	from reflect.Value, to reflect.Value,
+	field reflect.StructField,
+) (interface{}, error) {
+	if f, ok := raw.(namedDecodeHookFunc); ok {
+		data, _, err := f(from, to, field)
+		return data, err
+	}
+
 	switch f := typedDecodeHook(raw).(type) {
 	case DecodeHookFuncType:
 		return f(from.Type(), to.Type(), from.Interface())
@@ -50,59 +88,1192 @@ func DecodeHookExec(
 		return f(from.Kind(), to.Kind(), from.Interface())
 	case DecodeHookFuncValue:
 		return f(from, to)
+	case DecodeHookFuncReflect:
+		result, err := f(from.Type(), to.Type(), from)
+		if err != nil || !result.IsValid() {
+			return nil, err
+		}
+		return result.Interface(), nil
+	case DecodeHookFuncField:
+		return f(from, to, field)
 	default:
 		return nil, errors.New("invalid decode hook signature")
 	}
 }
 
-// ComposeDecodeHookFunc creates a single DecodeHookFunc that
-// automatically composes multiple DecodeHookFuncs.
-//
-// The composed funcs are called in order, with the result of the
-// previous transformation.
-func ComposeDecodeHookFunc(fs ...DecodeHookFunc) DecodeHookFunc {
-	return func(f reflect.Value, t reflect.Value) (interface{}, error) {
-		var err error
-		data := f.Interface()
+// decodeHookExecValue is like DecodeHookExec, but returns the result as a
+// reflect.Value so that DecodeHookFuncReflect hooks can be chained without
+// boxing their results in an interface{}.
+func decodeHookExecValue(
+	raw DecodeHookFunc,
+	from reflect.Value, to reflect.Value,
+	field reflect.StructField,
+) (reflect.Value, error) {
+	if f, ok := typedDecodeHook(raw).(DecodeHookFuncReflect); ok {
+		return f(from.Type(), to.Type(), from)
+	}
+
+	data, err := decodeHookExecField(raw, from, to, field)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(data), nil
+}
+
+// ComposeDecodeHookFunc creates a single DecodeHookFunc that
+// automatically composes multiple DecodeHookFuncs.
+//
+// The composed funcs are called in order, with the result of the
+// previous transformation.
+func ComposeDecodeHookFunc(fs ...DecodeHookFunc) DecodeHookFunc {
+	return func(f reflect.Value, t reflect.Value, field reflect.StructField) (interface{}, error) {
+		var err error
+
+		newFrom := f
+		for _, f1 := range fs {
+			newFrom, err = decodeHookExecValue(f1, newFrom, t, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !newFrom.IsValid() {
+			return nil, nil
+		}
+		return newFrom.Interface(), nil
+	}
+}
+
+// TypedHook returns a DecodeHookFunc that runs h only when decoding into
+// the type target, and passes the data through unchanged otherwise. To
+// register many such hooks, DecoderConfig.TypedHooks avoids running each
+// of them for every value.
+func TypedHook(target reflect.Type, h DecodeHookFunc) DecodeHookFunc {
+	return func(from reflect.Value, to reflect.Value, field reflect.StructField) (interface{}, error) {
+		if to.Type() != target {
+			return from.Interface(), nil
+		}
+		return decodeHookExecField(h, from, to, field)
+	}
+}
+
+// PtrizeHookFunc returns a DecodeHookFunc that runs h for the type a
+// pointer target points to, and returns a pointer to its result if it has
+// that type. Other results, and the results for targets that aren't
+// pointers, are returned as is.
+func PtrizeHookFunc(h DecodeHookFunc) DecodeHookFunc {
+	return func(from reflect.Value, to reflect.Value, field reflect.StructField) (interface{}, error) {
+		if to.Kind() != reflect.Ptr {
+			return decodeHookExecField(h, from, to, field)
+		}
+
+		elem := reflect.New(to.Type().Elem())
+		data, err := decodeHookExecField(h, from, elem.Elem(), field)
+		if err != nil {
+			return nil, err
+		}
+
+		result := reflect.ValueOf(data)
+		if !result.IsValid() || result.Type() != elem.Type().Elem() {
+			return data, nil
+		}
+		elem.Elem().Set(result)
+		return elem.Interface(), nil
+	}
+}
+
+// OrComposeDecodeHookFunc executes all input hook functions until one of them returns no error. In that case its value is returned.
+// If all hooks return an error, OrComposeDecodeHookFunc returns an error concatenating all error messages.
+func OrComposeDecodeHookFunc(ff ...DecodeHookFunc) DecodeHookFunc {
+	return func(a, b reflect.Value, field reflect.StructField) (interface{}, error) {
+		var allErrs string
+		var out interface{}
+		var err error
+
+		for _, f := range ff {
+			out, err = decodeHookExecField(f, a, b, field)
+			if err != nil {
+				allErrs += err.Error() + "\n"
+				continue
+			}
+
+			return out, nil
+		}
+
+		return nil, errors.New(allErrs)
+	}
+}
+
+// NamedHook is a DecodeHookFunc along with a name identifying it, for use
+// with OrComposeNamedDecodeHookFunc.
+type NamedHook struct {
+	Name string
+	Hook DecodeHookFunc
+}
+
+// namedDecodeHookFunc is a decode hook that also returns the name of the
+// hook that produced its result. OrComposeNamedDecodeHookFunc returns hooks
+// of this type.
+type namedDecodeHookFunc func(from, to reflect.Value, field reflect.StructField) (interface{}, string, error)
+
+// OrComposeNamedDecodeHookFunc is like OrComposeDecodeHookFunc, but the
+// hooks have names, which prefix their messages in the returned error.
+// If the returned hook is the DecodeHook of a DecoderConfig with Metadata,
+// the name of the hook that produced the value decoded into each field is
+// recorded in the Hooks field of Metadata. Names aren't recorded when the
+// returned hook is composed with other hooks.
+func OrComposeNamedDecodeHookFunc(hooks ...NamedHook) DecodeHookFunc {
+	return namedDecodeHookFunc(func(a, b reflect.Value, field reflect.StructField) (interface{}, string, error) {
+		var allErrs string
+		for _, h := range hooks {
+			out, err := decodeHookExecField(h.Hook, a, b, field)
+			if err != nil {
+				allErrs += h.Name + ": " + err.Error() + "\n"
+				continue
+			}
+
+			return out, h.Name, nil
+		}
+
+		return nil, "", errors.New(allErrs)
+	})
+}
+
+// StringNormalizeHookFunc returns a DecodeHookFunc that applies fn to
+// string data, such as strings.ToLower or strings.TrimSpace, regardless of
+// the target type. Data of a named string type keeps its type. It is
+// meant to be composed before hooks that match string values.
+func StringNormalizeHookFunc(fn func(string) string) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+
+		normalized := fn(reflect.ValueOf(data).String())
+		return reflect.ValueOf(normalized).Convert(f).Interface(), nil
+	}
+}
+
+// ExpandEnvHookFunc returns a DecodeHookFunc that expands references to
+// environment variables in string data, such as "${HOME}/data" or
+// "$HOME/data", like os.Expand, regardless of the target type. "$$" stands
+// for a literal "$". Variables are looked up with lookup, os.LookupEnv if
+// nil. Undefined variables expand to the empty string, or are an error if
+// errorOnUndefined is true. Data of a named string type keeps its type.
+func ExpandEnvHookFunc(lookup func(string) (string, bool), errorOnUndefined bool) DecodeHookFunc {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		var undefined []string
+		parts := strings.Split(str, "$$")
+		for i, part := range parts {
+			parts[i] = os.Expand(part, func(name string) string {
+				value, ok := lookup(name)
+				if !ok {
+					undefined = append(undefined, name)
+				}
+				return value
+			})
+		}
+
+		if errorOnUndefined && len(undefined) > 0 {
+			return nil, fmt.Errorf("failed expanding %q: undefined variable %q", str, undefined[0])
+		}
+
+		expanded := strings.Join(parts, "$")
+		return reflect.ValueOf(expanded).Convert(f).Interface(), nil
+	}
+}
+
+// UnwrapValueKeyHookFunc returns a DecodeHookFunc that unwraps maps with
+// key as their only key, such as {"value": 42}, into the value of that key
+// when the target is a scalar: a bool, string or numeric type. Maps with
+// other keys are left untouched. key defaults to "value" if it is empty.
+func UnwrapValueKeyHookFunc(key string) DecodeHookFunc {
+	if key == "" {
+		key = "value"
+	}
+
+	return func(from reflect.Value, to reflect.Value) (interface{}, error) {
+		switch getKind(to) {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Uint, reflect.Float32, reflect.Complex64:
+		default:
+			return from.Interface(), nil
+		}
+
+		if from.Kind() != reflect.Map || from.Len() != 1 {
+			return from.Interface(), nil
+		}
+
+		mapKey := from.MapKeys()[0]
+		k := mapKey
+		if k.Kind() == reflect.Interface {
+			k = k.Elem()
+		}
+		if k.Kind() != reflect.String || k.String() != key {
+			return from.Interface(), nil
+		}
+
+		return from.MapIndex(mapKey).Interface(), nil
+	}
+}
+
+// FlatKeyHookFunc returns a DecodeHookFunc that expands the keys of a map
+// with string keys into nested maps by splitting them on delimiter when
+// decoding into a struct. This allows environment-style maps such as
+// {"DB_HOST": "x", "DB_PORT": "5432"} to be decoded into nested structs.
+// Keys that have an empty part, such as "_DB", are kept as is.
+//
+// A flat key can be combined with a nested map of type
+// map[string]interface{} in the input, but it is an error for a flat key
+// to lead to a path that is already set, or that is set to a value that
+// isn't such a map.
+func FlatKeyHookFunc(delimiter string) DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if delimiter == "" || t.Kind() != reflect.Struct ||
+			f.Kind() != reflect.Map || f.Key().Kind() != reflect.String {
+			return data, nil
+		}
+
+		dataVal := reflect.ValueOf(data)
+		result := make(map[string]interface{}, dataVal.Len())
+		var flatKeys []string
+		iter := dataVal.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if isFlatKey(key, delimiter) {
+				flatKeys = append(flatKeys, key)
+			} else {
+				result[key] = iter.Value().Interface()
+			}
+		}
+		if len(flatKeys) == 0 {
+			return data, nil
+		}
+
+		// Sort the keys so that errors about conflicts are reproducible.
+		sort.Strings(flatKeys)
+		for _, key := range flatKeys {
+			parts := strings.Split(key, delimiter)
+			m := result
+			for i, part := range parts[:len(parts)-1] {
+				existing, ok := m[part]
+				if !ok {
+					nested := make(map[string]interface{})
+					m[part] = nested
+					m = nested
+					continue
+				}
+
+				nested, ok := existing.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf(
+						"key '%s' conflicts with key '%s'", key, strings.Join(parts[:i+1], delimiter))
+				}
+
+				// Copy the map so that the input is left untouched.
+				copied := make(map[string]interface{}, len(nested))
+				for k, v := range nested {
+					copied[k] = v
+				}
+				m[part] = copied
+				m = copied
+			}
+
+			last := parts[len(parts)-1]
+			if _, ok := m[last]; ok {
+				return nil, fmt.Errorf("key '%s' is set both as a flat key and as a nested key", key)
+			}
+			m[last] = dataVal.MapIndex(reflect.ValueOf(key).Convert(f.Key())).Interface()
+		}
+
+		return result, nil
+	}
+}
+
+// isFlatKey reports whether key is made of several non-empty parts
+// separated by delimiter.
+func isFlatKey(key, delimiter string) bool {
+	parts := strings.Split(key, delimiter)
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// StringToSliceHookFunc returns a DecodeHookFunc that converts
+// string to []string by splitting on the given sep.
+func StringToSliceHookFunc(sep string) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.SliceOf(f) {
+			return data, nil
+		}
+
+		raw := data.(string)
+		if raw == "" {
+			return []string{}, nil
+		}
+
+		return strings.Split(raw, sep), nil
+	}
+}
+
+// StringToNestedSliceHookFunc returns a DecodeHookFunc that converts
+// strings such as "a,b;c,d" to [][]string by splitting on outerSep, then
+// splitting each part on innerSep. It applies to any target that is a
+// slice of slices, whose elements are then decoded as usual. Empty parts
+// are kept as empty strings and rows, except for a trailing separator,
+// which is ignored. It is an error for the target to be nested more
+// deeply than the two levels the separators describe.
+func StringToNestedSliceHookFunc(outerSep, innerSep rune) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Slice {
+			return data, nil
+		}
+		if t.Elem().Elem().Kind() == reflect.Slice {
+			return nil, fmt.Errorf(
+				"cannot split %q into %s: the separators describe 2 levels of nesting", data, t)
+		}
+
+		raw := data.(string)
+		rows := [][]string{}
+		for _, row := range splitTrimmingSep(raw, outerSep) {
+			rows = append(rows, splitTrimmingSep(row, innerSep))
+		}
+
+		return rows, nil
+	}
+}
+
+// splitTrimmingSep splits s on sep, ignoring a trailing sep. The empty
+// string is split into no parts.
+func splitTrimmingSep(s string, sep rune) []string {
+	s = strings.TrimSuffix(s, string(sep))
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, string(sep))
+}
+
+// StringToJSONHookFunc returns a DecodeHookFunc that parses strings as
+// JSON when they are decoded into a struct, map, slice or array, so that a
+// single string can hold a nested document that is then decoded as usual.
+// Strings that aren't valid JSON are tried as base64 encoded JSON. A JSON
+// null is decoded like a nil input. Scalar and []byte targets, as well as
+// types implementing encoding.TextUnmarshaler, are left alone.
+func StringToJSONHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		switch t.Kind() {
+		case reflect.Struct, reflect.Map:
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				return data, nil
+			}
+		default:
+			return data, nil
+		}
+		if reflect.PtrTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		raw := []byte(str)
+		if !json.Valid(raw) {
+			if decoded, err := base64.StdEncoding.DecodeString(str); err == nil && json.Valid(decoded) {
+				raw = decoded
+			}
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for %s: %w", t, err)
+		}
+		if v == nil {
+			return nullValue{}, nil
+		}
+		return v, nil
+	}
+}
+
+// TimeOfDay is a clock time without a date, as decoded by
+// StringToTimeOfDayHookFunc.
+type TimeOfDay struct {
+	Hour, Minute, Second int
+}
+
+// Seconds returns the number of seconds since midnight at t.
+func (t TimeOfDay) Seconds() int {
+	return t.Hour*3600 + t.Minute*60 + t.Second
+}
+
+// StringToTimeOfDayHookFunc returns a DecodeHookFunc that converts clock
+// times in the "HH:MM" or "HH:MM:SS" form, such as "09:30", to TimeOfDay,
+// and to each of the integer types secondsTypes as the number of seconds
+// since midnight. The hour may have a single digit.
+func StringToTimeOfDayHookFunc(secondsTypes ...reflect.Type) DecodeHookFunc {
+	timeOfDayType := reflect.TypeOf(TimeOfDay{})
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+
+		isSeconds := false
+		for _, typ := range secondsTypes {
+			if kind := getKind(reflect.Zero(typ)); t == typ && (kind == reflect.Int || kind == reflect.Uint) {
+				isSeconds = true
+				break
+			}
+		}
+		if t != timeOfDayType && !isSeconds {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		parts := strings.Split(str, ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("failed parsing time of day %q: expected HH:MM or HH:MM:SS", str)
+		}
+
+		var clock [3]int
+		for i, part := range parts {
+			if len(part) != 2 && (i > 0 || len(part) != 1) || strings.Trim(part, "0123456789") != "" {
+				return nil, fmt.Errorf("failed parsing time of day %q: expected HH:MM or HH:MM:SS", str)
+			}
+			clock[i], _ = strconv.Atoi(part)
+		}
+
+		result := TimeOfDay{Hour: clock[0], Minute: clock[1], Second: clock[2]}
+		switch {
+		case result.Hour > 23:
+			return nil, fmt.Errorf("failed parsing time of day %q: hour %d out of range 0-23", str, result.Hour)
+		case result.Minute > 59:
+			return nil, fmt.Errorf("failed parsing time of day %q: minute %d out of range 0-59", str, result.Minute)
+		case result.Second > 59:
+			return nil, fmt.Errorf("failed parsing time of day %q: second %d out of range 0-59", str, result.Second)
+		}
+
+		if isSeconds {
+			return reflect.ValueOf(result.Seconds()).Convert(t).Interface(), nil
+		}
+		return result, nil
+	}
+}
+
+// StringToUUIDHookFunc returns a DecodeHookFunc that converts UUIDs in the
+// canonical 8-4-4-4-12 hex form, such as
+// "f47ac10b-58cc-4372-a567-0e02b2c3d479", to [16]byte or to types defined
+// over it, such as uuid.UUID. The UUID may be enclosed in braces or
+// prefixed with "urn:uuid:".
+func StringToUUIDHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t.Kind() != reflect.Array || t.Len() != 16 || t.Elem().Kind() != reflect.Uint8 {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		uuid := str
+		if strings.HasPrefix(uuid, "{") && strings.HasSuffix(uuid, "}") {
+			uuid = uuid[1 : len(uuid)-1]
+		} else if len(uuid) > 9 && strings.EqualFold(uuid[:9], "urn:uuid:") {
+			uuid = uuid[9:]
+		}
+
+		if len(uuid) != 36 {
+			return nil, fmt.Errorf("failed parsing UUID %q: expected 36 characters, got %d", str, len(uuid))
+		}
+		if uuid[8] != '-' || uuid[13] != '-' || uuid[18] != '-' || uuid[23] != '-' {
+			return nil, fmt.Errorf("failed parsing UUID %q: expected the 8-4-4-4-12 form", str)
+		}
+
+		result := reflect.New(t).Elem()
+		digits := strings.Replace(uuid, "-", "", 4)
+		for i := 0; i < 16; i++ {
+			b, err := strconv.ParseUint(digits[2*i:2*i+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing UUID %q: invalid hex digits %q", str, digits[2*i:2*i+2])
+			}
+			result.Index(i).SetUint(b)
+		}
+		return result.Interface(), nil
+	}
+}
+
+// StringToErrorHookFunc returns a DecodeHookFunc that converts strings to
+// errors with errors.New when the target type is error. An empty string is
+// converted to a nil error, which like any nil input leaves the target
+// alone unless ZeroFields or DecodeNil is set.
+func StringToErrorHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf((*error)(nil)).Elem() {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		if str == "" {
+			return nil, nil
+		}
+		return errors.New(str), nil
+	}
+}
+
+// StringToColorHookFunc returns a DecodeHookFunc that converts hex colors
+// such as "#aabbcc" to color.RGBA. The shorthand "#abc", in which each
+// digit is doubled, and an alpha channel, as in "#aabbccdd", are also
+// accepted. Colors without an alpha channel are opaque.
+func StringToColorHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(color.RGBA{}) {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		hex := strings.TrimPrefix(str, "#")
+		if hex == str {
+			return nil, fmt.Errorf("failed parsing color %q: missing leading '#'", str)
+		}
+
+		switch len(hex) {
+		case 3:
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		case 6, 8:
+		default:
+			return nil, fmt.Errorf("failed parsing color %q: expected 3, 6 or 8 hex digits, got %d", str, len(hex))
+		}
+
+		channels := []uint8{0, 0, 0, 255}
+		for i := 0; i < len(hex); i += 2 {
+			v, err := strconv.ParseUint(hex[i:i+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing color %q: invalid hex digits %q", str, hex[i:i+2])
+			}
+			channels[i/2] = uint8(v)
+		}
+
+		return color.RGBA{R: channels[0], G: channels[1], B: channels[2], A: channels[3]}, nil
+	}
+}
+
+// StringToFlagsHookFunc returns a DecodeHookFunc that converts strings of
+// flag names separated by sep, such as "read|write", to the bitmask type T
+// by ORing the values of the names. Whitespace around names is ignored and
+// the empty string is converted to zero. It is an error for a name not to
+// be in names.
+func StringToFlagsHookFunc[T ~int](names map[string]T, sep rune) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(T(0)) {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		var flags T
+		for _, name := range strings.Split(str, string(sep)) {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			flag, ok := names[name]
+			if !ok {
+				valid := make([]string, 0, len(names))
+				for n := range names {
+					valid = append(valid, n)
+				}
+				sort.Strings(valid)
+
+				return nil, fmt.Errorf(
+					"failed parsing flags %q: unknown flag %q, expected one of: %s",
+					str, name, strings.Join(valid, ", "))
+			}
+			flags |= flag
+		}
+
+		return flags, nil
+	}
+}
+
+// IntEnumValidateHookFunc returns a DecodeHookFunc that checks integers
+// decoded into the enum type T are one of valid, to catch out of range
+// values in the input. Valid integers are converted to T. If T implements
+// fmt.Stringer, the error lists the names of the valid values.
+func IntEnumValidateHookFunc[T ~int](valid ...T) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if t != reflect.TypeOf(T(0)) {
+			return data, nil
+		}
+
+		var is func(v T) bool
+		dataVal := reflect.ValueOf(data)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			is = func(v T) bool { return int64(v) == dataVal.Int() }
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			is = func(v T) bool { return v >= 0 && uint64(v) == dataVal.Uint() }
+		default:
+			return data, nil
+		}
+
+		for _, v := range valid {
+			if is(v) {
+				return v, nil
+			}
+		}
+
+		values := make([]string, 0, len(valid))
+		for _, v := range valid {
+			if stringer, ok := interface{}(v).(fmt.Stringer); ok {
+				values = append(values, fmt.Sprintf("%d (%s)", int64(v), stringer))
+			} else {
+				values = append(values, strconv.FormatInt(int64(v), 10))
+			}
+		}
+
+		return nil, fmt.Errorf(
+			"invalid value %v for %s, expected one of: %s",
+			data, t, strings.Join(values, ", "))
+	}
+}
+
+// nullValue is returned by StringNullHookFunc in place of strings that
+// stand for a nil, and by StringToJSONHookFunc for a JSON null. The decoder
+// handles it like a nil input.
+type nullValue struct{}
+
+// StringNullHookFunc returns a DecodeHookFunc that turns strings matching
+// one of tokens regardless of case, "null" by default, into a nil when
+// decoding into a pointer. The decoder handles it like a nil input, so the
+// pointer is only set to nil if DecodeNil or ZeroFields is set. Strings
+// decoded into other types are left alone. It should come before other
+// hooks for strings in a chain, which then don't see the tokens.
+func StringNullHookFunc(tokens ...string) DecodeHookFunc {
+	if len(tokens) == 0 {
+		tokens = []string{"null"}
+	}
+
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String || t.Kind() != reflect.Ptr {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		for _, token := range tokens {
+			if strings.EqualFold(str, token) {
+				return nullValue{}, nil
+			}
+		}
+		return data, nil
+	}
+}
+
+// StringToLabelsHookFunc returns a DecodeHookFunc that converts strings
+// of comma-separated labels such as "app=web,env" to map[string]string.
+// A key without a value maps to the empty string and, if a key is repeated,
+// the last value wins.
+func StringToLabelsHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(map[string]string{}) {
+			return data, nil
+		}
+
+		labels := map[string]string{}
+		for _, label := range strings.Split(reflect.ValueOf(data).String(), ",") {
+			label = strings.TrimSpace(label)
+			if label == "" {
+				continue
+			}
+
+			key, value := label, ""
+			if i := strings.Index(label, "="); i != -1 {
+				key, value = strings.TrimSpace(label[:i]), strings.TrimSpace(label[i+1:])
+			}
+			if key == "" {
+				return nil, fmt.Errorf("failed parsing label %q: empty key", label)
+			}
+
+			labels[key] = value
+		}
+
+		return labels, nil
+	}
+}
+
+// StringToPolygonHookFunc returns a DecodeHookFunc that converts strings
+// of semicolon-separated points such as "0,0;1,0;1,1" to a slice of
+// structs with float X and Y fields, such as []struct{ X, Y float64 }.
+func StringToPolygonHookFunc() DecodeHookFunc {
+	return stringToPolygonHookFunc(false)
+}
+
+// StringToClosedPolygonHookFunc is like StringToPolygonHookFunc, but
+// returns an error if the last point of the polygon isn't the same as the
+// first one.
+func StringToClosedPolygonHookFunc() DecodeHookFunc {
+	return stringToPolygonHookFunc(true)
+}
+
+func stringToPolygonHookFunc(closed bool) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t.Kind() != reflect.Slice || !isPointType(t.Elem()) {
+			return data, nil
+		}
+
+		raw := strings.TrimSpace(reflect.ValueOf(data).String())
+		if raw == "" {
+			return reflect.MakeSlice(t, 0, 0).Interface(), nil
+		}
+
+		points := strings.Split(raw, ";")
+		polygon := reflect.MakeSlice(t, len(points), len(points))
+		for i, point := range points {
+			coords := strings.Split(point, ",")
+			if len(coords) != 2 {
+				return nil, fmt.Errorf("failed parsing point %d %q: expected 2 coordinates, got %d", i, point, len(coords))
+			}
+
+			x, err := strconv.ParseFloat(strings.TrimSpace(coords[0]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing point %d %q: %w", i, point, err)
+			}
+			y, err := strconv.ParseFloat(strings.TrimSpace(coords[1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing point %d %q: %w", i, point, err)
+			}
+
+			polygon.Index(i).FieldByName("X").SetFloat(x)
+			polygon.Index(i).FieldByName("Y").SetFloat(y)
+		}
+
+		if closed && !reflect.DeepEqual(polygon.Index(0).Interface(), polygon.Index(len(points)-1).Interface()) {
+			return nil, fmt.Errorf("polygon %q is not closed", raw)
+		}
+
+		return polygon.Interface(), nil
+	}
+}
+
+// isPointType reports whether t is a struct type with float X and Y fields.
+func isPointType(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	for _, name := range []string{"X", "Y"} {
+		f, ok := t.FieldByName(name)
+		if !ok || f.PkgPath != "" || (f.Type.Kind() != reflect.Float32 && f.Type.Kind() != reflect.Float64) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StringToTimeDurationHookFunc returns a DecodeHookFunc that converts
+// strings to time.Duration.
+func StringToTimeDurationHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(time.Duration(5)) {
+			return data, nil
+		}
+
+		// Convert it by parsing
+		return time.ParseDuration(data.(string))
+	}
+}
+
+// StringToDurationWithUnitHookFunc returns a DecodeHookFunc that converts
+// strings to time.Duration. Strings consisting of a bare number, such as
+// "5", are interpreted in defaultUnit; all others are parsed with
+// time.ParseDuration.
+func StringToDurationWithUnitHookFunc(defaultUnit time.Duration) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(time.Duration(5)) {
+			return data, nil
+		}
+
+		raw := strings.TrimSpace(reflect.ValueOf(data).String())
+		if n, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+			total := n * float64(defaultUnit)
+			if total >= math.MaxInt64 || total <= math.MinInt64 {
+				return nil, fmt.Errorf("duration %q overflows time.Duration", raw)
+			}
+			return time.Duration(total), nil
+		}
+
+		// Convert it by parsing
+		return time.ParseDuration(raw)
+	}
+}
+
+// StringToDurationHookFunc returns a DecodeHookFunc that converts strings
+// to time.Duration by trying time.ParseDuration and then each of parsers in
+// order, such as ParseISO8601Duration, until one of them succeeds. If all
+// of them fail, the error of the last one is returned.
+func StringToDurationHookFunc(parsers ...func(string) (time.Duration, error)) DecodeHookFunc {
+	parsers = append([]func(string) (time.Duration, error){time.ParseDuration}, parsers...)
+
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(time.Duration(5)) {
+			return data, nil
+		}
+
+		var err error
+		for _, parse := range parsers {
+			var d time.Duration
+			if d, err = parse(reflect.ValueOf(data).String()); err == nil {
+				return d, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// iso8601DurationPattern matches ISO 8601 durations made of weeks, days,
+// hours, minutes and seconds, with an optional fraction in each of them.
+var iso8601DurationPattern = regexp.MustCompile(
+	`^([+-])?P(?:([0-9.,]+)W)?(?:([0-9.,]+)D)?(?:T(?:([0-9.,]+)H)?(?:([0-9.,]+)M)?(?:([0-9.,]+)S)?)?$`)
+
+// iso8601DurationUnits are the units of the components matched by
+// iso8601DurationPattern, in order.
+var iso8601DurationUnits = []time.Duration{
+	7 * 24 * time.Hour,
+	24 * time.Hour,
+	time.Hour,
+	time.Minute,
+	time.Second,
+}
+
+// ParseISO8601Duration parses an ISO 8601 duration such as "PT1H30M",
+// "P1DT12H" or "-PT0.5S". Days are 24 hours long. Years and months aren't
+// supported, since their length varies. It can be passed to
+// StringToDurationHookFunc.
+func ParseISO8601Duration(s string) (time.Duration, error) {
+	m := iso8601DurationPattern.FindStringSubmatch(s)
+	if m == nil || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	var total float64
+	for i, unit := range iso8601DurationUnits {
+		value := m[i+2]
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+		}
+		total += n * float64(unit)
+	}
+
+	if total >= math.MaxInt64 {
+		return 0, fmt.Errorf("ISO 8601 duration %q overflows time.Duration", s)
+	}
+	d := time.Duration(math.Round(total))
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// byteSizeUnits maps the suffixes understood by StringToByteSizeHookFunc to
+// their number of bytes.
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"pb":  1000 * 1000 * 1000 * 1000 * 1000,
+	"eb":  1000 * 1000 * 1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+	"eib": 1 << 60,
+}
+
+// StringToByteSizeHookFunc returns a DecodeHookFunc that converts
+// human-readable sizes such as "10MB" or "1.5GiB" to an integer number of
+// bytes, when the target is an integer type other than time.Duration.
+//
+// Suffixes are case-insensitive. KB, MB, GB, TB, PB and EB are decimal
+// units (powers of 1000) while KiB, MiB, GiB, TiB, PiB and EiB are binary
+// units (powers of 1024). A number without a suffix is a number of bytes.
+// Fractional numbers are accepted as long as they amount to a whole number
+// of bytes. Negative sizes are rejected.
+func StringToByteSizeHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t == reflect.TypeOf(time.Duration(5)) {
+			return data, nil
+		}
+
+		out := reflect.New(t).Elem()
+		if kind := getKind(out); kind != reflect.Int && kind != reflect.Uint {
+			return data, nil
+		}
+
+		raw := strings.TrimSpace(reflect.ValueOf(data).String())
+		if strings.HasPrefix(raw, "-") {
+			return nil, fmt.Errorf("failed parsing size %q: negative sizes are not supported", raw)
+		}
+
+		i := strings.IndexFunc(raw, func(r rune) bool {
+			return !unicode.IsDigit(r) && r != '.'
+		})
+		if i == -1 {
+			i = len(raw)
+		}
+
+		number, unit := raw[:i], strings.ToLower(strings.TrimSpace(raw[i:]))
+		multiplier, ok := byteSizeUnits[unit]
+		if !ok {
+			return nil, fmt.Errorf("failed parsing size %q: unknown unit %q", raw, raw[i:])
+		}
+
+		size, ok := new(big.Rat).SetString(number)
+		if !ok || number == "" {
+			return nil, fmt.Errorf("failed parsing size %q", raw)
+		}
+		size.Mul(size, new(big.Rat).SetInt64(multiplier))
+		if !size.IsInt() {
+			return nil, fmt.Errorf("failed parsing size %q: not a whole number of bytes", raw)
+		}
+
+		bytes := size.Num()
+		if getKind(out) == reflect.Int {
+			if !bytes.IsInt64() || out.OverflowInt(bytes.Int64()) {
+				return nil, fmt.Errorf("size %q overflows %s", raw, t.Kind())
+			}
+			out.SetInt(bytes.Int64())
+		} else {
+			if !bytes.IsUint64() || out.OverflowUint(bytes.Uint64()) {
+				return nil, fmt.Errorf("size %q overflows %s", raw, t.Kind())
+			}
+			out.SetUint(bytes.Uint64())
+		}
+
+		return out.Interface(), nil
+	}
+}
+
+// StringToIPHookFunc returns a DecodeHookFunc that converts
+// strings to net.IP
+func StringToIPHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(net.IP{}) {
+			return data, nil
+		}
 
-		newFrom := f
-		for _, f1 := range fs {
-			data, err = DecodeHookExec(f1, newFrom, t)
-			if err != nil {
-				return nil, err
-			}
-			newFrom = reflect.ValueOf(data)
+		// Convert it by parsing
+		ip := net.ParseIP(data.(string))
+		if ip == nil {
+			return net.IP{}, fmt.Errorf("failed parsing ip %v", data)
 		}
 
-		return data, nil
+		return ip, nil
 	}
 }
 
-// OrComposeDecodeHookFunc executes all input hook functions until one of them returns no error. In that case its value is returned.
-// If all hooks return an error, OrComposeDecodeHookFunc returns an error concatenating all error messages.
-func OrComposeDecodeHookFunc(ff ...DecodeHookFunc) DecodeHookFunc {
-	return func(a, b reflect.Value) (interface{}, error) {
-		var allErrs string
-		var out interface{}
-		var err error
+// StringToIPNetHookFunc returns a DecodeHookFunc that converts
+// strings to net.IPNet
+func StringToIPNetHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(net.IPNet{}) {
+			return data, nil
+		}
 
-		for _, f := range ff {
-			out, err = DecodeHookExec(f, a, b)
-			if err != nil {
-				allErrs += err.Error() + "\n"
-				continue
-			}
+		// Convert it by parsing
+		_, net, err := net.ParseCIDR(data.(string))
+		return net, err
+	}
+}
 
-			return out, nil
+// StringToMACHookFunc returns a DecodeHookFunc that converts strings to
+// net.HardwareAddr using net.ParseMAC, which accepts the colon
+// ("01:23:45:67:89:ab"), dash ("01-23-45-67-89-ab") and dotted
+// ("0123.4567.89ab") formats.
+func StringToMACHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(net.HardwareAddr{}) {
+			return data, nil
 		}
 
-		return nil, errors.New(allErrs)
+		str := reflect.ValueOf(data).String()
+		mac, err := net.ParseMAC(str)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing mac address %q: %w", str, err)
+		}
+		return mac, nil
 	}
 }
 
-// StringToSliceHookFunc returns a DecodeHookFunc that converts
-// string to []string by splitting on the given sep.
-func StringToSliceHookFunc(sep string) DecodeHookFunc {
+// StringToTCPAddrHookFunc returns a DecodeHookFunc that converts
+// "host:port" strings to *net.TCPAddr using net.ResolveTCPAddr, which
+// resolves host names. IPv6 hosts must be enclosed in brackets, as in
+// "[::1]:80".
+func StringToTCPAddrHookFunc() DecodeHookFunc {
+	return stringToAddrHookFunc("tcp", reflect.TypeOf(net.TCPAddr{}), func(s string) (interface{}, error) {
+		return net.ResolveTCPAddr("tcp", s)
+	})
+}
+
+// StringToUDPAddrHookFunc returns a DecodeHookFunc that converts
+// "host:port" strings to *net.UDPAddr using net.ResolveUDPAddr, which
+// resolves host names. IPv6 hosts must be enclosed in brackets, as in
+// "[::1]:53".
+func StringToUDPAddrHookFunc() DecodeHookFunc {
+	return stringToAddrHookFunc("udp", reflect.TypeOf(net.UDPAddr{}), func(s string) (interface{}, error) {
+		return net.ResolveUDPAddr("udp", s)
+	})
+}
+
+func stringToAddrHookFunc(network string, addrType reflect.Type, resolve func(string) (interface{}, error)) DecodeHookFunc {
 	return func(
 		f reflect.Type,
 		t reflect.Type,
@@ -111,22 +1282,30 @@ func StringToSliceHookFunc(sep string) DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.SliceOf(f) {
+		if t != addrType && t != reflect.PtrTo(addrType) {
 			return data, nil
 		}
 
-		raw := data.(string)
-		if raw == "" {
-			return []string{}, nil
+		str := reflect.ValueOf(data).String()
+		if _, _, err := net.SplitHostPort(str); err != nil {
+			return nil, fmt.Errorf("failed parsing %s address %q: %w", network, str, err)
 		}
 
-		return strings.Split(raw, sep), nil
+		addr, err := resolve(str)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving %s address %q: %w", network, str, err)
+		}
+		return addr, nil
 	}
 }
 
-// StringToTimeDurationHookFunc returns a DecodeHookFunc that converts
-// strings to time.Duration.
-func StringToTimeDurationHookFunc() DecodeHookFunc {
+// StringToSemverHookFunc returns a DecodeHookFunc that converts semantic
+// versions such as "1.2.3-rc.1+build.5" to structs with integer Major,
+// Minor and Patch fields. The Prerelease and Build string fields of such a
+// struct are set too, if it has them. The versions are converted to their
+// canonical form, such as "1.2.0" for "v1.2", for the named string types
+// in stringTypes. Invalid versions are an error.
+func StringToSemverHookFunc(stringTypes ...reflect.Type) DecodeHookFunc {
 	return func(
 		f reflect.Type,
 		t reflect.Type,
@@ -135,18 +1314,82 @@ func StringToTimeDurationHookFunc() DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.TypeOf(time.Duration(5)) {
+
+		isString := false
+		for _, st := range stringTypes {
+			if t == st {
+				isString = true
+			}
+		}
+		if !isString && !isSemverStruct(t) {
 			return data, nil
 		}
 
-		// Convert it by parsing
-		return time.ParseDuration(data.(string))
+		v, err := parseSemver(reflect.ValueOf(data).String())
+		if err != nil {
+			return nil, err
+		}
+		if isString {
+			return reflect.ValueOf(v.String()).Convert(t).Interface(), nil
+		}
+
+		out := reflect.New(t).Elem()
+		numbers := []struct {
+			name string
+			n    uint64
+		}{{"Major", v.Major}, {"Minor", v.Minor}, {"Patch", v.Patch}}
+		for _, number := range numbers {
+			name, n := number.name, number.n
+			field := out.FieldByName(name)
+			if field.Kind() >= reflect.Uint && field.Kind() <= reflect.Uint64 {
+				if field.OverflowUint(n) {
+					return nil, fmt.Errorf("semantic version %q overflows field %s of %s", data, name, t)
+				}
+				field.SetUint(n)
+			} else {
+				if n > math.MaxInt64 || field.OverflowInt(int64(n)) {
+					return nil, fmt.Errorf("semantic version %q overflows field %s of %s", data, name, t)
+				}
+				field.SetInt(int64(n))
+			}
+		}
+		if field := out.FieldByName("Prerelease"); field.Kind() == reflect.String && field.CanSet() {
+			field.SetString(v.Prerelease)
+		}
+		if field := out.FieldByName("Build"); field.Kind() == reflect.String && field.CanSet() {
+			field.SetString(v.Build)
+		}
+		return out.Interface(), nil
 	}
 }
 
-// StringToIPHookFunc returns a DecodeHookFunc that converts
-// strings to net.IP
-func StringToIPHookFunc() DecodeHookFunc {
+// isSemverStruct reports whether t is a struct type with exported integer
+// Major, Minor and Patch fields.
+func isSemverStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	for _, name := range []string{"Major", "Minor", "Patch"} {
+		field, ok := t.FieldByName(name)
+		if !ok || field.PkgPath != "" {
+			return false
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// StringToURLValuesHookFunc returns a DecodeHookFunc that converts query
+// strings such as "a=1&b=2&a=3" to url.Values using url.ParseQuery, keeping
+// every value of repeated keys. An empty string is converted to empty
+// url.Values.
+func StringToURLValuesHookFunc() DecodeHookFunc {
 	return func(
 		f reflect.Type,
 		t reflect.Type,
@@ -155,23 +1398,99 @@ func StringToIPHookFunc() DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.TypeOf(net.IP{}) {
+		if t != reflect.TypeOf(url.Values{}) {
+			return data, nil
+		}
+
+		str := reflect.ValueOf(data).String()
+		values, err := url.ParseQuery(str)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing query string %q: %w", str, err)
+		}
+		return values, nil
+	}
+}
+
+// StringToTimeHookFunc returns a DecodeHookFunc that converts
+// strings to time.Time, or to types defined over it such as
+// `type Timestamp time.Time`.
+func StringToTimeHookFunc(layout string) DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if !isTimeType(t) {
 			return data, nil
 		}
 
 		// Convert it by parsing
-		ip := net.ParseIP(data.(string))
-		if ip == nil {
-			return net.IP{}, fmt.Errorf("failed parsing ip %v", data)
+		parsed, err := time.Parse(layout, reflect.ValueOf(data).String())
+		return reflect.ValueOf(parsed).Convert(t).Interface(), err
+	}
+}
+
+// isTimeType reports whether t is time.Time or a type defined over it.
+// Type aliases of time.Time are time.Time itself.
+func isTimeType(t reflect.Type) bool {
+	return t == timeType || t.Kind() == reflect.Struct && t.ConvertibleTo(timeType)
+}
+
+// StringToTimeZoneHookFunc returns a DecodeHookFunc that converts
+// IANA time zone names such as "America/New_York" to *time.Location using
+// time.LoadLocation. The names "Local" and "UTC" are matched regardless of
+// their case and return time.Local and time.UTC.
+func StringToTimeZoneHookFunc() DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		if t != reflect.TypeOf(&time.Location{}) {
+			return data, nil
 		}
 
-		return ip, nil
+		name := reflect.ValueOf(data).String()
+		switch {
+		case strings.EqualFold(name, "Local"):
+			return time.Local, nil
+		case strings.EqualFold(name, "UTC"):
+			return time.UTC, nil
+		}
+
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading time zone %q: %w", name, err)
+		}
+		return loc, nil
 	}
 }
 
-// StringToIPNetHookFunc returns a DecodeHookFunc that converts
-// strings to net.IPNet
-func StringToIPNetHookFunc() DecodeHookFunc {
+// StringToRegexpHookFunc returns a DecodeHookFunc that compiles strings
+// to *regexp.Regexp using regexp.Compile.
+//
+// An empty string compiles to a regular expression that matches
+// everything, just like regexp.Compile("") does.
+func StringToRegexpHookFunc() DecodeHookFunc {
+	return stringToRegexpHookFunc(regexp.Compile)
+}
+
+// StringToRegexpPOSIXHookFunc returns a DecodeHookFunc that compiles
+// strings to *regexp.Regexp using regexp.CompilePOSIX.
+//
+// An empty string compiles to a regular expression that matches
+// everything, just like regexp.CompilePOSIX("") does.
+func StringToRegexpPOSIXHookFunc() DecodeHookFunc {
+	return stringToRegexpHookFunc(regexp.CompilePOSIX)
+}
+
+func stringToRegexpHookFunc(compile func(string) (*regexp.Regexp, error)) DecodeHookFunc {
 	return func(
 		f reflect.Type,
 		t reflect.Type,
@@ -180,19 +1499,24 @@ func StringToIPNetHookFunc() DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.TypeOf(net.IPNet{}) {
+		if t != reflect.TypeOf(&regexp.Regexp{}) {
 			return data, nil
 		}
 
-		// Convert it by parsing
-		_, net, err := net.ParseCIDR(data.(string))
-		return net, err
+		// Convert it by compiling
+		re, err := compile(reflect.ValueOf(data).String())
+		if err != nil {
+			return nil, fmt.Errorf("failed compiling regexp %q: %w", data, err)
+		}
+
+		return re, nil
 	}
 }
 
-// StringToTimeHookFunc returns a DecodeHookFunc that converts
-// strings to time.Time.
-func StringToTimeHookFunc(layout string) DecodeHookFunc {
+// StringToBigRatHookFunc returns a DecodeHookFunc that converts strings to
+// *big.Rat using (*big.Rat).SetString, which accepts fractions such as
+// "3/4" as well as decimals such as "0.75" or "7.5e-1".
+func StringToBigRatHookFunc() DecodeHookFunc {
 	return func(
 		f reflect.Type,
 		t reflect.Type,
@@ -201,12 +1525,18 @@ func StringToTimeHookFunc(layout string) DecodeHookFunc {
 		if f.Kind() != reflect.String {
 			return data, nil
 		}
-		if t != reflect.TypeOf(time.Time{}) {
+		if t != reflect.TypeOf(&big.Rat{}) {
 			return data, nil
 		}
 
 		// Convert it by parsing
-		return time.Parse(layout, data.(string))
+		str := reflect.ValueOf(data).String()
+		r, ok := new(big.Rat).SetString(str)
+		if !ok {
+			return nil, fmt.Errorf("failed parsing rational %q", str)
+		}
+
+		return r, nil
 	}
 }
 
@@ -247,6 +1577,77 @@ func WeaklyTypedHook(
 	return data, nil
 }
 
+// DiscriminatorHookFunc returns a DecodeHookFunc that decodes maps into
+// interface targets, such as the elements of a []Plugin, by choosing their
+// concrete type from the value of key in the map. types maps the values of
+// key to a value of the concrete type, such as (*HTTPPlugin)(nil) or
+// HTTPPlugin{}. The decoder then decodes the map, without key, into a new
+// value of that type using the rest of the configuration, and replaces the
+// target with it. The target itself is never modified.
+//
+// Only interface targets implemented by at least one of the types are
+// handled, so that multiple such hooks can be composed. It is an error for
+// key to be missing or to have a value not in types.
+func DiscriminatorHookFunc(key string, types map[string]interface{}) DecodeHookFunc {
+	return func(from reflect.Value, to reflect.Value) (interface{}, error) {
+		if to.Kind() != reflect.Interface || to.NumMethod() == 0 {
+			return from.Interface(), nil
+		}
+		if reflect.Indirect(from).Kind() != reflect.Map || !implementedByAny(to.Type(), types) {
+			return from.Interface(), nil
+		}
+
+		data := reflect.Indirect(from)
+		var discriminator interface{}
+		found := false
+		rest := reflect.MakeMapWithSize(data.Type(), data.Len())
+		iter := data.MapRange()
+		for iter.Next() {
+			if k, ok := iter.Key().Interface().(string); ok && k == key {
+				discriminator, found = iter.Value().Interface(), true
+				continue
+			}
+			rest.SetMapIndex(iter.Key(), iter.Value())
+		}
+		if !found {
+			return nil, fmt.Errorf("missing discriminator key '%s' for '%s'", key, to.Type())
+		}
+
+		name, _ := discriminator.(string)
+		sample, ok := types[name]
+		typ := reflect.TypeOf(sample)
+		if !ok || typ == nil || !typ.Implements(to.Type()) {
+			return nil, fmt.Errorf("unknown %s '%v' for '%s'", key, discriminator, to.Type())
+		}
+
+		return discriminatedValue{typ: typ, data: rest.Interface()}, nil
+	}
+}
+
+// discriminatedValue is returned by DiscriminatorHookFunc. The decoder
+// decodes data into a new value of typ in place of the target.
+type discriminatedValue struct {
+	typ  reflect.Type
+	data interface{}
+}
+
+// implementedByAny reports whether the type of any of the values of types
+// implements the interface type iface.
+func implementedByAny(iface reflect.Type, types map[string]interface{}) bool {
+	for _, sample := range types {
+		if typ := reflect.TypeOf(sample); typ != nil && typ.Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecursiveStructToMapHookFunc returns a DecodeHookFunc that decodes
+// structs into maps when the target is an empty interface, so that nested
+// structs end up as nested maps.
+//
+// Pointers that lead back to a struct that's already being decoded cause an
+// error, unless BreakCycles is set in the DecoderConfig.
 func RecursiveStructToMapHookFunc() DecodeHookFunc {
 	return func(f reflect.Value, t reflect.Value) (interface{}, error) {
 		if f.Kind() != reflect.Struct {
@@ -333,6 +1734,109 @@ func StringToNetIPAddrPortHookFunc() DecodeHookFunc {
 	}
 }
 
+// SafeNumericHookFunc returns a DecodeHookFunc that converts between
+// int, uint and float types, returning an error instead of silently
+// wrapping or truncating when the value does not fit the target type.
+func SafeNumericHookFunc() DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		dataVal := reflect.ValueOf(data)
+		fromKind := getKind(dataVal)
+		if fromKind != reflect.Int && fromKind != reflect.Uint && fromKind != reflect.Float32 {
+			return data, nil
+		}
+
+		out := reflect.New(t).Elem()
+		switch getKind(out) {
+		case reflect.Int, reflect.Uint, reflect.Float32:
+		default:
+			return data, nil
+		}
+
+		if err := setNumber(dataVal, out); err != nil {
+			return nil, err
+		}
+
+		return out.Interface(), nil
+	}
+}
+
+// setNumber sets the numeric value out to the numeric value from, returning
+// an error if that would overflow out or truncate a fractional part.
+func setNumber(from reflect.Value, out reflect.Value) error {
+	fromKind := getKind(from)
+	switch getKind(out) {
+	case reflect.Int:
+		var i int64
+		switch fromKind {
+		case reflect.Int:
+			i = from.Int()
+		case reflect.Uint:
+			u := from.Uint()
+			if u > math.MaxInt64 {
+				return fmt.Errorf("value %v overflows %s", from, out.Kind())
+			}
+			i = int64(u)
+		case reflect.Float32:
+			fl := from.Float()
+			if fl != math.Trunc(fl) {
+				return fmt.Errorf("value %v would be truncated converting to %s", from, out.Kind())
+			}
+			if fl < math.MinInt64 || fl >= math.MaxInt64 {
+				return fmt.Errorf("value %v overflows %s", from, out.Kind())
+			}
+			i = int64(fl)
+		}
+		if out.OverflowInt(i) {
+			return fmt.Errorf("value %v overflows %s", from, out.Kind())
+		}
+		out.SetInt(i)
+	case reflect.Uint:
+		var u uint64
+		switch fromKind {
+		case reflect.Int:
+			i := from.Int()
+			if i < 0 {
+				return fmt.Errorf("negative value %v cannot be converted to %s", from, out.Kind())
+			}
+			u = uint64(i)
+		case reflect.Uint:
+			u = from.Uint()
+		case reflect.Float32:
+			fl := from.Float()
+			if fl < 0 {
+				return fmt.Errorf("negative value %v cannot be converted to %s", from, out.Kind())
+			}
+			if fl != math.Trunc(fl) {
+				return fmt.Errorf("value %v would be truncated converting to %s", from, out.Kind())
+			}
+			if fl >= math.MaxUint64 {
+				return fmt.Errorf("value %v overflows %s", from, out.Kind())
+			}
+			u = uint64(fl)
+		}
+		if out.OverflowUint(u) {
+			return fmt.Errorf("value %v overflows %s", from, out.Kind())
+		}
+		out.SetUint(u)
+	case reflect.Float32:
+		var fl float64
+		switch fromKind {
+		case reflect.Int:
+			fl = float64(from.Int())
+		case reflect.Uint:
+			fl = float64(from.Uint())
+		case reflect.Float32:
+			fl = from.Float()
+		}
+		if out.OverflowFloat(fl) {
+			return fmt.Errorf("value %v overflows %s", from, out.Kind())
+		}
+		out.SetFloat(fl)
+	}
+
+	return nil
+}
+
 // StringToBasicTypeHookFunc returns a DecodeHookFunc that converts
 // strings to basic types.
 // int8, uint8, int16, uint16, int32, uint32, int64, uint64, int, uint, float32, float64, bool, byte, rune, complex64, complex128
@@ -537,6 +2041,45 @@ func StringToBoolHookFunc() DecodeHookFunc {
 	}
 }
 
+// StringToHumanBoolHookFunc returns a DecodeHookFunc that converts
+// human-written phrases such as "turn it on" or "keep disabled" to bool.
+//
+// The phrase is split into words, which are looked up case-insensitively:
+// "on", "yes", "true", "enable" and "enabled" are positive cues while
+// "off", "no", "false", "disable" and "disabled" are negative ones. It is
+// an error for a phrase to contain no cue at all or cues of both kinds.
+func StringToHumanBoolHookFunc() DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String || t.Kind() != reflect.Bool {
+			return data, nil
+		}
+
+		var positive, negative bool
+		words := strings.FieldsFunc(strings.ToLower(reflect.ValueOf(data).String()), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			switch word {
+			case "on", "yes", "true", "enable", "enabled":
+				positive = true
+			case "off", "no", "false", "disable", "disabled":
+				negative = true
+			}
+		}
+
+		switch {
+		case positive && !negative:
+			return true, nil
+		case negative && !positive:
+			return false, nil
+		case positive && negative:
+			return false, fmt.Errorf("ambiguous boolean phrase %q", data)
+		default:
+			return false, fmt.Errorf("failed parsing boolean phrase %q", data)
+		}
+	}
+}
+
 // StringToByteHookFunc returns a DecodeHookFunc that converts
 // strings to byte.
 func StringToByteHookFunc() DecodeHookFunc {
@@ -3,15 +3,97 @@ package mapstructure
 import (
 	"encoding/json"
 	"errors"
+	"image/color"
+	"math"
 	"math/big"
 	"net"
 	"net/netip"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 )
 
+func TestUnderlyingKind(t *testing.T) {
+	type namedBool bool
+	type namedInt int
+	type namedInt8 int8
+	type namedInt16 int16
+	type namedInt32 int32
+	type namedInt64 int64
+	type namedUint uint
+	type namedUint8 uint8
+	type namedUint16 uint16
+	type namedUint32 uint32
+	type namedUint64 uint64
+	type namedUintptr uintptr
+	type namedFloat32 float32
+	type namedFloat64 float64
+	type namedComplex64 complex64
+	type namedComplex128 complex128
+	type namedString string
+
+	cases := []struct {
+		value interface{}
+		kind  reflect.Kind
+	}{
+		{namedBool(true), reflect.Bool},
+		{namedInt(1), reflect.Int},
+		{namedInt8(1), reflect.Int8},
+		{namedInt16(1), reflect.Int16},
+		{namedInt32(1), reflect.Int32},
+		{namedInt64(1), reflect.Int64},
+		{namedUint(1), reflect.Uint},
+		{namedUint8(1), reflect.Uint8},
+		{namedUint16(1), reflect.Uint16},
+		{namedUint32(1), reflect.Uint32},
+		{namedUint64(1), reflect.Uint64},
+		{namedUintptr(1), reflect.Uintptr},
+		{namedFloat32(1), reflect.Float32},
+		{namedFloat64(1), reflect.Float64},
+		{namedComplex64(1), reflect.Complex64},
+		{namedComplex128(1), reflect.Complex128},
+		{namedString("a"), reflect.String},
+	}
+
+	for _, tc := range cases {
+		v, expected := tc.value, tc.kind
+		typ := reflect.TypeOf(v)
+		if kind := UnderlyingKind(typ); kind != expected {
+			t.Fatalf("%s: expected %s, got %s", typ, expected, kind)
+		}
+		if kind := UnderlyingKind(reflect.PtrTo(reflect.PtrTo(typ))); kind != expected {
+			t.Fatalf("**%s: expected %s, got %s", typ, expected, kind)
+		}
+
+		// Type hooks receive the declared type and Kind hooks the
+		// underlying kind.
+		var gotType reflect.Type
+		typeHook := func(f reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+			gotType = f
+			return data, nil
+		}
+		var gotKind reflect.Kind
+		kindHook := func(f reflect.Kind, to reflect.Kind, data interface{}) (interface{}, error) {
+			gotKind = f
+			return data, nil
+		}
+		hook := ComposeDecodeHookFunc(typeHook, kindHook)
+		if _, err := DecodeHookExec(hook, reflect.ValueOf(v), reflect.ValueOf(v)); err != nil {
+			t.Fatalf("%s: %s", typ, err)
+		}
+		if gotType != typ {
+			t.Fatalf("%s: expected the declared type, got %s", typ, gotType)
+		}
+		if gotKind != expected {
+			t.Fatalf("%s: expected kind %s, got %s", typ, expected, gotKind)
+		}
+	}
+}
+
 func TestComposeDecodeHookFunc(t *testing.T) {
 	f1 := func(
 		f reflect.Kind,
@@ -91,6 +173,35 @@ func TestComposeDecodeHookFunc_kinds(t *testing.T) {
 	}
 }
 
+func TestComposeDecodeHookFunc_reflect(t *testing.T) {
+	f1 := func(
+		f reflect.Type,
+		t reflect.Type,
+		data reflect.Value,
+	) (reflect.Value, error) {
+		return reflect.ValueOf(data.String() + "foo"), nil
+	}
+
+	f2 := func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		return data.(string) + "bar", nil
+	}
+
+	f := ComposeDecodeHookFunc(f1, f2, f1)
+
+	result, err := DecodeHookExec(
+		f, reflect.ValueOf(""), reflect.ValueOf(""))
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if result.(string) != "foobarfoo" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestOrComposeDecodeHookFunc(t *testing.T) {
 	f1 := func(
 		f reflect.Kind,
@@ -186,6 +297,110 @@ func TestOrComposeDecodeHookFunc_err(t *testing.T) {
 	}
 }
 
+func TestOrComposeNamedDecodeHookFunc(t *testing.T) {
+	type Target struct {
+		Timeout time.Duration
+		Created time.Time
+		Port    int
+	}
+
+	hook := OrComposeNamedDecodeHookFunc(
+		NamedHook{Name: "duration", Hook: func(f, t reflect.Type, data interface{}) (interface{}, error) {
+			if t != reflect.TypeOf(time.Duration(0)) {
+				return nil, errors.New("not a duration")
+			}
+			return time.ParseDuration(data.(string))
+		}},
+		NamedHook{Name: "time", Hook: func(f, t reflect.Type, data interface{}) (interface{}, error) {
+			if t != reflect.TypeOf(time.Time{}) {
+				return nil, errors.New("not a time")
+			}
+			return time.Parse(time.RFC3339, data.(string))
+		}},
+		NamedHook{Name: "passthrough", Hook: func(f, t reflect.Type, data interface{}) (interface{}, error) {
+			return data, nil
+		}},
+	)
+
+	var md Metadata
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: hook,
+		Metadata:   &md,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"timeout": "5s",
+		"created": "2024-03-01T12:30:00Z",
+		"port":    8080,
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Timeout: 5 * time.Second,
+		Created: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		Port:    8080,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	expectedHooks := map[string]string{
+		"Timeout": "duration",
+		"Created": "time",
+		"Port":    "passthrough",
+	}
+	if !reflect.DeepEqual(expectedHooks, md.Hooks) {
+		t.Fatalf("bad hooks: %#v", md.Hooks)
+	}
+}
+
+func TestOrComposeNamedDecodeHookFunc_err(t *testing.T) {
+	f1 := func(
+		f reflect.Kind,
+		t reflect.Kind,
+		data interface{},
+	) (interface{}, error) {
+		return nil, errors.New("f1 error")
+	}
+
+	f2 := func(
+		f reflect.Kind,
+		t reflect.Kind,
+		data interface{},
+	) (interface{}, error) {
+		return data.(string) + "bar", nil
+	}
+
+	f := OrComposeNamedDecodeHookFunc(NamedHook{"f1", f1}, NamedHook{"f2", f2})
+
+	result, err := DecodeHookExec(
+		f, reflect.ValueOf(""), reflect.ValueOf([]byte("")))
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if result.(string) != "bar" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	f = OrComposeNamedDecodeHookFunc(NamedHook{"f1", f1})
+
+	_, err = DecodeHookExec(
+		f, reflect.ValueOf(""), reflect.ValueOf([]byte("")))
+	if err == nil {
+		t.Fatalf("bad: should return an error")
+	}
+	if err.Error() != "f1: f1 error\n" {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
 func TestComposeDecodeHookFunc_safe_nofuncs(t *testing.T) {
 	f := ComposeDecodeHookFunc()
 	type myStruct2 struct {
@@ -218,6 +433,355 @@ func TestComposeDecodeHookFunc_safe_nofuncs(t *testing.T) {
 	}
 }
 
+func TestStringNormalizeHookFunc(t *testing.T) {
+	type Mode string
+
+	f := StringNormalizeHookFunc(func(s string) string {
+		return strings.ToLower(strings.TrimSpace(s))
+	})
+
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+	}{
+		{reflect.ValueOf(" Fast "), reflect.ValueOf(""), "fast"},
+		{reflect.ValueOf("FAST"), reflect.ValueOf(Mode("")), "fast"},
+		{reflect.ValueOf(Mode(" Slow")), reflect.ValueOf(Mode("")), Mode("slow")},
+		{reflect.ValueOf("TRUE"), reflect.ValueOf(false), "true"},
+		{reflect.ValueOf(42), reflect.ValueOf(""), 42},
+		{reflect.ValueOf([]string{"A"}), reflect.ValueOf([]string{}), []string{"A"}},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	var result struct {
+		Mode  Mode
+		Modes []Mode
+	}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: ComposeDecodeHookFunc(f),
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"mode":  " Fast",
+		"modes": []interface{}{"SLOW ", Mode("Fast")},
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Mode != "fast" || !reflect.DeepEqual(result.Modes, []Mode{"slow", "fast"}) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestExpandEnvHookFunc(t *testing.T) {
+	type Path string
+
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{"HOME": "/home/user", "PORT": "8080", "EMPTY": ""}[name]
+		return value, ok
+	}
+
+	for _, errorOnUndefined := range []bool{false, true} {
+		f := ExpandEnvHookFunc(lookup, errorOnUndefined)
+
+		var missing interface{} = "/data"
+		if errorOnUndefined {
+			missing = nil
+		}
+
+		cases := []struct {
+			f, t   reflect.Value
+			result interface{}
+			err    bool
+		}{
+			{reflect.ValueOf("${HOME}/data"), reflect.ValueOf(""), "/home/user/data", false},
+			{reflect.ValueOf("$HOME/data"), reflect.ValueOf(""), "/home/user/data", false},
+			{reflect.ValueOf(Path("$HOME")), reflect.ValueOf(Path("")), Path("/home/user"), false},
+			{reflect.ValueOf("$PORT"), reflect.ValueOf(0), "8080", false},
+			{reflect.ValueOf("cost: $$5"), reflect.ValueOf(""), "cost: $5", false},
+			{reflect.ValueOf("$$HOME is $HOME"), reflect.ValueOf(""), "$HOME is /home/user", false},
+			{reflect.ValueOf("[$EMPTY]"), reflect.ValueOf(""), "[]", false},
+			{reflect.ValueOf("no variables"), reflect.ValueOf(""), "no variables", false},
+			{reflect.ValueOf(42), reflect.ValueOf(""), 42, false},
+			{reflect.ValueOf([]string{"$HOME"}), reflect.ValueOf([]string{}), []string{"$HOME"}, false},
+			{reflect.ValueOf("${MISSING}/data"), reflect.ValueOf(""), missing, errorOnUndefined},
+		}
+		for i, tc := range cases {
+			actual, err := DecodeHookExec(f, tc.f, tc.t)
+			if tc.err != (err != nil) {
+				t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+			}
+			if !reflect.DeepEqual(actual, tc.result) {
+				t.Fatalf(
+					"case %d: expected %#v, got %#v",
+					i, tc.result, actual)
+			}
+		}
+	}
+
+	_, err := DecodeHookExec(ExpandEnvHookFunc(lookup, true), reflect.ValueOf("$HOME/$MISSING"), reflect.ValueOf(""))
+	expected := `failed expanding "$HOME/$MISSING": undefined variable "MISSING"`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	t.Setenv("MAPSTRUCTURE_TEST_DIR", "/tmp/test")
+	t.Setenv("MAPSTRUCTURE_TEST_PORT", "9090")
+	var result struct {
+		Dir  string
+		Port int
+	}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: ComposeDecodeHookFunc(
+			ExpandEnvHookFunc(nil, false),
+			StringToIntHookFunc(),
+		),
+		Result: &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{
+		"dir":  "${MAPSTRUCTURE_TEST_DIR}/data",
+		"port": "$MAPSTRUCTURE_TEST_PORT",
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Dir != "/tmp/test/data" || result.Port != 9090 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestUnwrapValueKeyHookFunc(t *testing.T) {
+	f := UnwrapValueKeyHookFunc("")
+
+	intValue := reflect.ValueOf(0)
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+	}{
+		{reflect.ValueOf(map[string]interface{}{"value": 42}), intValue, 42},
+		{reflect.ValueOf(map[interface{}]interface{}{"value": "on"}), reflect.ValueOf(""), "on"},
+		{reflect.ValueOf(map[string]bool{"value": true}), reflect.ValueOf(false), true},
+		{reflect.ValueOf(map[string]float64{"value": 1.5}), reflect.ValueOf(float32(0)), 1.5},
+		{
+			reflect.ValueOf(map[string]interface{}{"value": 42, "unit": "s"}),
+			intValue,
+			map[string]interface{}{"value": 42, "unit": "s"},
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"val": 42}),
+			intValue,
+			map[string]interface{}{"val": 42},
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"value": 42}),
+			reflect.ValueOf(map[string]int{}),
+			map[string]interface{}{"value": 42},
+		},
+		{reflect.ValueOf(42), intValue, 42},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	// A custom key, with the struct fields of a primary field struct
+	// still decoded from the map.
+	type Amount struct {
+		Value int    `mapstructure:",primary"`
+		Unit  string `mapstructure:"unit"`
+	}
+	type Target struct {
+		Count  int
+		Ptr    *int
+		Amount Amount
+		Other  Amount
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: UnwrapValueKeyHookFunc("v"),
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"count":  map[string]interface{}{"v": 1},
+		"ptr":    map[string]interface{}{"v": 2},
+		"amount": map[string]interface{}{"value": 3, "unit": "kg"},
+		"other":  4,
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Count:  1,
+		Ptr:    func() *int { i := 2; return &i }(),
+		Amount: Amount{Value: 3, Unit: "kg"},
+		Other:  Amount{Value: 4},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestFlatKeyHookFunc(t *testing.T) {
+	f := FlatKeyHookFunc("_")
+
+	structValue := reflect.ValueOf(struct{}{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    string
+	}{
+		{
+			reflect.ValueOf(map[string]string{"DB_HOST": "x", "DB_PORT": "5432", "NAME": "app"}),
+			structValue,
+			map[string]interface{}{
+				"DB":   map[string]interface{}{"HOST": "x", "PORT": "5432"},
+				"NAME": "app",
+			},
+			"",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{
+				"DB_CONN_HOST": "x",
+				"DB":           map[string]interface{}{"PORT": 5432},
+			}),
+			structValue,
+			map[string]interface{}{
+				"DB": map[string]interface{}{
+					"CONN": map[string]interface{}{"HOST": "x"},
+					"PORT": 5432,
+				},
+			},
+			"",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"_PATH": "x", "A__B": "y"}),
+			structValue,
+			map[string]interface{}{"_PATH": "x", "A__B": "y"},
+			"",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"DB": "x", "DB_HOST": "y"}),
+			structValue,
+			nil,
+			"key 'DB_HOST' conflicts with key 'DB'",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"DB_HOST": "x", "DB_HOST_NAME": "y"}),
+			structValue,
+			nil,
+			"key 'DB_HOST_NAME' conflicts with key 'DB_HOST'",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{
+				"DB_HOST": "x",
+				"DB":      map[string]interface{}{"HOST": "y"},
+			}),
+			structValue,
+			nil,
+			"key 'DB_HOST' is set both as a flat key and as a nested key",
+		},
+		{
+			reflect.ValueOf(map[string]interface{}{"DB_HOST": "x"}),
+			reflect.ValueOf(map[string]interface{}{}),
+			map[string]interface{}{"DB_HOST": "x"},
+			"",
+		},
+		{reflect.ValueOf("DB_HOST"), structValue, "DB_HOST", ""},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("case %d: expected err %q, got %v", i, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	type DB struct {
+		Host string
+		Port int
+	}
+	type Target struct {
+		DB      DB
+		Replica *DB
+		Name    string
+	}
+
+	input := map[string]interface{}{
+		"DB_HOST":      "x",
+		"DB_PORT":      "5432",
+		"REPLICA_HOST": "y",
+		"NAME":         "app",
+		"DB":           map[string]interface{}{},
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook:       FlatKeyHookFunc("_"),
+		WeaklyTypedInput: true,
+		Result:           &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		DB:      DB{Host: "x", Port: 5432},
+		Replica: &DB{Host: "y"},
+		Name:    "app",
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+	if len(input["DB"].(map[string]interface{})) != 0 {
+		t.Fatalf("input was modified: %#v", input)
+	}
+}
+
 func TestStringToSliceHookFunc(t *testing.T) {
 	f := StringToSliceHookFunc(",")
 
@@ -238,17 +802,1054 @@ func TestStringToSliceHookFunc(t *testing.T) {
 			false,
 		},
 		{
-			reflect.ValueOf(""),
-			sliceValue,
-			[]string{},
-			false,
+			reflect.ValueOf(""),
+			sliceValue,
+			[]string{},
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToNestedSliceHookFunc(t *testing.T) {
+	f := StringToNestedSliceHookFunc(';', ',')
+
+	strValue := reflect.ValueOf("42")
+	nestedValue := reflect.ValueOf([][]string{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, strValue, "42", false},
+		{strValue, reflect.ValueOf([]string{}), "42", false},
+		{nestedValue, nestedValue, [][]string{}, false},
+		{
+			reflect.ValueOf("a,b;c,d"),
+			nestedValue,
+			[][]string{{"a", "b"}, {"c", "d"}},
+			false,
+		},
+		{
+			reflect.ValueOf("1,2;3"),
+			reflect.ValueOf([][]int{}),
+			[][]string{{"1", "2"}, {"3"}},
+			false,
+		},
+		{
+			reflect.ValueOf("a,,b;;c,"),
+			nestedValue,
+			[][]string{{"a", "", "b"}, {}, {"c"}},
+			false,
+		},
+		{
+			reflect.ValueOf("a,b;c,d;"),
+			nestedValue,
+			[][]string{{"a", "b"}, {"c", "d"}},
+			false,
+		},
+		{reflect.ValueOf(""), nestedValue, [][]string{}, false},
+		{reflect.ValueOf("a,b;c"), reflect.ValueOf([][][]string{}), nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToJSONHookFunc(t *testing.T) {
+	f := StringToJSONHookFunc()
+
+	type Server struct {
+		Port int
+	}
+
+	structValue := reflect.ValueOf(Server{})
+	mapValue := reflect.ValueOf(map[string]interface{}{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{
+			reflect.ValueOf(`{"port":8080}`),
+			structValue,
+			map[string]interface{}{"port": float64(8080)},
+			false,
+		},
+		{
+			reflect.ValueOf("eyJwb3J0Ijo4MDgwfQ=="),
+			mapValue,
+			map[string]interface{}{"port": float64(8080)},
+			false,
+		},
+		{
+			reflect.ValueOf(`["a", "b"]`),
+			reflect.ValueOf([]string{}),
+			[]interface{}{"a", "b"},
+			false,
+		},
+		{reflect.ValueOf(`{"port":8080}`), reflect.ValueOf(""), `{"port":8080}`, false},
+		{reflect.ValueOf("42"), reflect.ValueOf(0), "42", false},
+		{reflect.ValueOf("[1]"), reflect.ValueOf([]byte{}), "[1]", false},
+		{reflect.ValueOf("2006-01-02T15:04:05Z"), reflect.ValueOf(time.Time{}), "2006-01-02T15:04:05Z", false},
+		{mapValue, structValue, map[string]interface{}{}, false},
+		{reflect.ValueOf(`{"port":`), structValue, nil, true},
+		{reflect.ValueOf("null"), structValue, nullValue{}, false},
+		{reflect.ValueOf(" null "), mapValue, nullValue{}, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	// The parsed document is decoded as usual.
+	var result struct {
+		Server  Server
+		Servers []Server
+	}
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: f, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"server":  `{"port": 80}`,
+		"servers": `[{"port": 81}, {"port": 82}]`,
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Server.Port != 80 || !reflect.DeepEqual(result.Servers, []Server{{81}, {82}}) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"server": "{"})
+	if err == nil || !strings.Contains(err.Error(), "error decoding 'Server': failed parsing JSON") {
+		t.Fatalf("expected a JSON error for 'Server', got %v", err)
+	}
+
+	// A JSON null is decoded like a nil input.
+	nulls := struct {
+		Server Server
+		Labels map[string]string
+	}{Server{Port: 80}, map[string]string{"env": "prod"}}
+	decoder, err = NewDecoder(&DecoderConfig{DecodeHook: f, Result: &nulls})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"server": "null", "labels": "null"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if nulls.Server.Port != 80 || !reflect.DeepEqual(nulls.Labels, map[string]string{"env": "prod"}) {
+		t.Fatalf("bad: %#v", nulls)
+	}
+}
+
+func TestStringToTimeOfDayHookFunc(t *testing.T) {
+	type clock int
+	f := StringToTimeOfDayHookFunc(reflect.TypeOf(clock(0)))
+
+	todValue := reflect.ValueOf(TimeOfDay{})
+	clockValue := reflect.ValueOf(clock(0))
+	strValue := reflect.ValueOf("09:30")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, todValue, TimeOfDay{Hour: 9, Minute: 30}, false},
+		{reflect.ValueOf("9:30"), todValue, TimeOfDay{Hour: 9, Minute: 30}, false},
+		{reflect.ValueOf("23:59:59"), todValue, TimeOfDay{Hour: 23, Minute: 59, Second: 59}, false},
+		{reflect.ValueOf("00:00"), todValue, TimeOfDay{}, false},
+		{strValue, clockValue, clock(34200), false},
+		{reflect.ValueOf("01:02:03"), clockValue, clock(3723), false},
+		{strValue, reflect.ValueOf(0), "09:30", false},
+		{strValue, strValue, "09:30", false},
+		{reflect.ValueOf(930), todValue, 930, false},
+		{reflect.ValueOf("24:00"), todValue, nil, true},
+		{reflect.ValueOf("12:60"), todValue, nil, true},
+		{reflect.ValueOf("12:00:60"), clockValue, nil, true},
+		{reflect.ValueOf("12"), todValue, nil, true},
+		{reflect.ValueOf("12:00:00:00"), todValue, nil, true},
+		{reflect.ValueOf("12:5"), todValue, nil, true},
+		{reflect.ValueOf("+1:30"), todValue, nil, true},
+		{reflect.ValueOf("ab:cd"), todValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf("25:00"), todValue)
+	if err == nil || err.Error() != `failed parsing time of day "25:00": hour 25 out of range 0-23` {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
+type testUUID [16]byte
+
+func TestStringToUUIDHookFunc(t *testing.T) {
+	f := StringToUUIDHookFunc()
+
+	uuid := [16]byte{0xf4, 0x7a, 0xc1, 0x0b, 0x58, 0xcc, 0x43, 0x72, 0xa5, 0x67, 0x0e, 0x02, 0xb2, 0xc3, 0xd4, 0x79}
+	uuidValue := reflect.ValueOf([16]byte{})
+	strValue := reflect.ValueOf("f47ac10b-58cc-4372-a567-0e02b2c3d479")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, uuidValue, uuid, false},
+		{reflect.ValueOf("F47AC10B-58CC-4372-A567-0E02B2C3D479"), uuidValue, uuid, false},
+		{reflect.ValueOf("{f47ac10b-58cc-4372-a567-0e02b2c3d479}"), uuidValue, uuid, false},
+		{reflect.ValueOf("urn:uuid:f47ac10b-58cc-4372-a567-0e02b2c3d479"), uuidValue, uuid, false},
+		{strValue, reflect.ValueOf(testUUID{}), testUUID(uuid), false},
+		{strValue, strValue, "f47ac10b-58cc-4372-a567-0e02b2c3d479", false},
+		{strValue, reflect.ValueOf([8]byte{}), "f47ac10b-58cc-4372-a567-0e02b2c3d479", false},
+		{reflect.ValueOf(uuid[:]), uuidValue, uuid[:], false},
+		{reflect.ValueOf("f47ac10b58cc4372a5670e02b2c3d479"), uuidValue, nil, true},
+		{reflect.ValueOf("f47ac10b-58cc-4372-a567-0e02b2c3d47"), uuidValue, nil, true},
+		{reflect.ValueOf("f47ac10b-58cc-4372-a567-0e02b2c3d47g"), uuidValue, nil, true},
+		{reflect.ValueOf("f47ac10b+58cc-4372-a567-0e02b2c3d479"), uuidValue, nil, true},
+		{reflect.ValueOf("{f47ac10b-58cc-4372-a567-0e02b2c3d479"), uuidValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf("not-a-uuid"), uuidValue)
+	if err == nil || !strings.Contains(err.Error(), `"not-a-uuid"`) {
+		t.Fatalf("expected the error to name the input, got %v", err)
+	}
+}
+
+func TestStringToErrorHookFunc(t *testing.T) {
+	f := StringToErrorHookFunc()
+
+	errValue := reflect.ValueOf(new(error)).Elem()
+	strValue := reflect.ValueOf("boom")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, errValue, errors.New("boom"), false},
+		{reflect.ValueOf(""), errValue, nil, false},
+		{strValue, strValue, "boom", false},
+		{reflect.ValueOf(1), errValue, 1, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	type Status struct {
+		LastError error
+		Previous  error
+	}
+
+	var result Status
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: f,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"lasterror": "timeout", "previous": ""}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.LastError == nil || result.LastError.Error() != "timeout" || result.Previous != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestStringToColorHookFunc(t *testing.T) {
+	f := StringToColorHookFunc()
+
+	colorValue := reflect.ValueOf(color.RGBA{})
+	strValue := reflect.ValueOf("#aabbcc")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, colorValue, color.RGBA{R: 0xaa, G: 0xbb, B: 0xcc, A: 0xff}, false},
+		{reflect.ValueOf("#ABC"), colorValue, color.RGBA{R: 0xaa, G: 0xbb, B: 0xcc, A: 0xff}, false},
+		{reflect.ValueOf("#00ff0080"), colorValue, color.RGBA{G: 0xff, A: 0x80}, false},
+		{strValue, strValue, "#aabbcc", false},
+		{reflect.ValueOf(0xaabbcc), colorValue, 0xaabbcc, false},
+		{reflect.ValueOf("aabbcc"), colorValue, nil, true},
+		{reflect.ValueOf("#"), colorValue, nil, true},
+		{reflect.ValueOf("#aabb"), colorValue, nil, true},
+		{reflect.ValueOf("#aabbccd"), colorValue, nil, true},
+		{reflect.ValueOf("#gghhii"), colorValue, nil, true},
+		{reflect.ValueOf("#+abbcc"), colorValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+type permission int
+
+const (
+	permissionRead permission = 1 << iota
+	permissionWrite
+	permissionExecute
+)
+
+func TestStringToFlagsHookFunc(t *testing.T) {
+	f := StringToFlagsHookFunc(map[string]permission{
+		"read":    permissionRead,
+		"write":   permissionWrite,
+		"execute": permissionExecute,
+	}, '|')
+
+	permValue := reflect.ValueOf(permission(0))
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("read"), permValue, permissionRead, false},
+		{reflect.ValueOf("read|write"), permValue, permissionRead | permissionWrite, false},
+		{reflect.ValueOf(" read | execute "), permValue, permissionRead | permissionExecute, false},
+		{reflect.ValueOf("write|write"), permValue, permissionWrite, false},
+		{reflect.ValueOf(""), permValue, permission(0), false},
+		{reflect.ValueOf("read|"), permValue, permissionRead, false},
+		{reflect.ValueOf("read"), reflect.ValueOf(0), "read", false},
+		{reflect.ValueOf(3), permValue, 3, false},
+		{reflect.ValueOf("read|delete"), permValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf("delete"), permValue)
+	expected := `failed parsing flags "delete": unknown flag "delete", expected one of: execute, read, write`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	var result struct {
+		Mode permission
+	}
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: f, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"mode": "read|write"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Mode != permissionRead|permissionWrite {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelError:
+		return "error"
+	default:
+		return "Level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+func TestIntEnumValidateHookFunc(t *testing.T) {
+	f := IntEnumValidateHookFunc(LevelDebug, LevelInfo, LevelError)
+
+	levelValue := reflect.ValueOf(Level(0))
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf(0), levelValue, LevelDebug, false},
+		{reflect.ValueOf(int8(1)), levelValue, LevelInfo, false},
+		{reflect.ValueOf(uint(2)), levelValue, LevelError, false},
+		{reflect.ValueOf(3), levelValue, nil, true},
+		{reflect.ValueOf(-1), levelValue, nil, true},
+		{reflect.ValueOf(uint64(math.MaxUint64)), levelValue, nil, true},
+		{reflect.ValueOf(3), reflect.ValueOf(0), 3, false},
+		{reflect.ValueOf("info"), levelValue, "info", false},
+		{reflect.ValueOf(1.0), levelValue, 1.0, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf(7), levelValue)
+	expected := "invalid value 7 for mapstructure.Level, expected one of: 0 (debug), 1 (info), 2 (error)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	var result struct {
+		Level Level
+	}
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: f, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"level": 2}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Level != LevelError {
+		t.Fatalf("bad: %#v", result)
+	}
+	err = decoder.Decode(map[string]interface{}{"level": 5})
+	if err == nil || !strings.Contains(err.Error(), "invalid value 5 for mapstructure.Level") {
+		t.Fatalf("expected an invalid value error, got %v", err)
+	}
+}
+
+func TestPtrizeHookFunc(t *testing.T) {
+	atoi := func(f, t reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String || t.Kind() != reflect.Int {
+			return data, nil
+		}
+		return strconv.Atoi(data.(string))
+	}
+	f := PtrizeHookFunc(atoi)
+
+	intValue := reflect.ValueOf(0)
+	intPtrValue := reflect.ValueOf(new(int))
+	strValue := reflect.ValueOf("42")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{strValue, intPtrValue, intPtr(42), false},
+		{strValue, intValue, 42, false},
+		{strValue, reflect.ValueOf(new(string)), stringPtr("42"), false},
+		{reflect.ValueOf(42), intPtrValue, intPtr(42), false},
+		{reflect.ValueOf("x"), intPtrValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	// A hook that only accepts pointers composes with the wrapped hook.
+	clamp := func(f, t reflect.Type, data interface{}) (interface{}, error) {
+		if p, ok := data.(*int); ok && *p > 100 {
+			return intPtr(100), nil
+		}
+		return data, nil
+	}
+
+	type Limits struct {
+		Max *int
+	}
+
+	var result Limits
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: ComposeDecodeHookFunc(f, clamp),
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"max": "500"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Max == nil || *result.Max != 100 {
+		t.Fatalf("bad: %#v", result.Max)
+	}
+}
+
+func TestStringNullHookFunc(t *testing.T) {
+	f := StringNullHookFunc()
+
+	strPtrValue := reflect.ValueOf(new(string))
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+	}{
+		{reflect.ValueOf("null"), strPtrValue, nullValue{}},
+		{reflect.ValueOf("NULL"), strPtrValue, nullValue{}},
+		{reflect.ValueOf("null"), reflect.ValueOf(new(int)), nullValue{}},
+		{reflect.ValueOf("nil"), strPtrValue, "nil"},
+		{reflect.ValueOf("null"), reflect.ValueOf(""), "null"},
+		{reflect.ValueOf(0), strPtrValue, 0},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if err != nil {
+			t.Fatalf("case %d: err: %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	type Target struct {
+		Name    string
+		Count   *int
+		Timeout *time.Duration
+	}
+
+	input := map[string]interface{}{
+		"name":    "none",
+		"count":   "None",
+		"timeout": "nil",
+	}
+	hook := ComposeDecodeHookFunc(
+		StringNullHookFunc("nil", "none"),
+		StringToTimeDurationHookFunc(),
+	)
+
+	for _, decodeNil := range []bool{false, true} {
+		count, timeout := 1, time.Second
+		result := Target{Count: &count, Timeout: &timeout}
+		decoder, err := NewDecoder(&DecoderConfig{
+			DecodeHook: hook,
+			DecodeNil:  decodeNil,
+			Result:     &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := decoder.Decode(input); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		expected := Target{Name: "none", Count: &count, Timeout: &timeout}
+		if decodeNil {
+			expected = Target{Name: "none"}
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("DecodeNil %t: expected %#v, got %#v", decodeNil, expected, result)
+		}
+	}
+}
+
+func TestStringToLabelsHookFunc(t *testing.T) {
+	type Labels string
+
+	f := StringToLabelsHookFunc()
+
+	strValue := reflect.ValueOf("42")
+	mapValue := reflect.ValueOf(map[string]string{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("app=web,env"), mapValue, map[string]string{"app": "web", "env": ""}, false},
+		{reflect.ValueOf(" app = web , tier=db, app=api "), mapValue, map[string]string{"app": "api", "tier": "db"}, false},
+		{reflect.ValueOf("a=b=c"), mapValue, map[string]string{"a": "b=c"}, false},
+		{reflect.ValueOf(""), mapValue, map[string]string{}, false},
+		{reflect.ValueOf("=web"), mapValue, nil, true},
+		{reflect.ValueOf(Labels("app=web")), mapValue, map[string]string{"app": "web"}, false},
+		{strValue, strValue, "42", false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToPolygonHookFunc(t *testing.T) {
+	type point struct{ X, Y float64 }
+	type Polygon string
+
+	strValue := reflect.ValueOf("42")
+	polygonValue := reflect.ValueOf([]point{})
+	closedPolygon := []point{{0, 0}, {1, 0}, {1, 1}, {0, 0}}
+
+	cases := []struct {
+		f      DecodeHookFunc
+		from   reflect.Value
+		to     reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{StringToPolygonHookFunc(), reflect.ValueOf("0,0;1,0;1,1;0,1"), polygonValue, []point{{0, 0}, {1, 0}, {1, 1}, {0, 1}}, false},
+		{StringToPolygonHookFunc(), reflect.ValueOf(" 0.5, -1 ; 2,3 "), polygonValue, []point{{0.5, -1}, {2, 3}}, false},
+		{StringToPolygonHookFunc(), reflect.ValueOf(""), polygonValue, []point{}, false},
+		{StringToPolygonHookFunc(), reflect.ValueOf("0,0;1;1,1"), polygonValue, nil, true},
+		{StringToPolygonHookFunc(), reflect.ValueOf("0,0;a,1"), polygonValue, nil, true},
+		{StringToPolygonHookFunc(), reflect.ValueOf(Polygon("0,0;1,0")), polygonValue, []point{{0, 0}, {1, 0}}, false},
+		{StringToPolygonHookFunc(), reflect.ValueOf("0,0"), reflect.ValueOf([]float64{}), "0,0", false},
+		{StringToPolygonHookFunc(), strValue, strValue, "42", false},
+		{StringToClosedPolygonHookFunc(), reflect.ValueOf("0,0;1,0;1,1;0,0"), polygonValue, closedPolygon, false},
+		{StringToClosedPolygonHookFunc(), reflect.ValueOf("0,0;1,0;1,1"), polygonValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(tc.f, tc.from, tc.to)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(StringToPolygonHookFunc(), reflect.ValueOf("0,0;1,x"), polygonValue)
+	if err == nil || !strings.Contains(err.Error(), "point 1") {
+		t.Fatalf("expected error naming the point index, got %v", err)
+	}
+}
+
+func TestStringToTimeDurationHookFunc(t *testing.T) {
+	f := StringToTimeDurationHookFunc()
+
+	timeValue := reflect.ValueOf(time.Duration(5))
+	strValue := reflect.ValueOf("")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("5s"), timeValue, 5 * time.Second, false},
+		{reflect.ValueOf("5"), timeValue, time.Duration(0), true},
+		{reflect.ValueOf("5"), strValue, "5", false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToDurationWithUnitHookFunc(t *testing.T) {
+	type Timeout string
+
+	f := StringToDurationWithUnitHookFunc(time.Second)
+
+	timeValue := reflect.ValueOf(time.Duration(5))
+	strValue := reflect.ValueOf("")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("5"), timeValue, 5 * time.Second, false},
+		{reflect.ValueOf("1.5"), timeValue, 1500 * time.Millisecond, false},
+		{reflect.ValueOf("5m"), timeValue, 5 * time.Minute, false},
+		{reflect.ValueOf("-2"), timeValue, -2 * time.Second, false},
+		{reflect.ValueOf("5x"), timeValue, time.Duration(0), true},
+		{reflect.ValueOf("NaN"), timeValue, time.Duration(0), true},
+		{reflect.ValueOf(""), timeValue, time.Duration(0), true},
+		{reflect.ValueOf("9223372036"), timeValue, 9223372036 * time.Second, false},
+		{reflect.ValueOf("9223372037"), timeValue, time.Duration(0), true},
+		{reflect.ValueOf("-9223372037"), timeValue, time.Duration(0), true},
+		{reflect.ValueOf(Timeout("5")), timeValue, 5 * time.Second, false},
+		{reflect.ValueOf("5"), strValue, "5", false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToDurationHookFunc(t *testing.T) {
+	type Timeout string
+
+	f := StringToDurationHookFunc(ParseISO8601Duration)
+
+	timeValue := reflect.ValueOf(time.Duration(5))
+	strValue := reflect.ValueOf("")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    string
+	}{
+		{reflect.ValueOf("1.5h"), timeValue, 90 * time.Minute, ""},
+		{reflect.ValueOf("PT1H30M"), timeValue, 90 * time.Minute, ""},
+		{reflect.ValueOf("P1W2DT3S"), timeValue, 9*24*time.Hour + 3*time.Second, ""},
+		{reflect.ValueOf("-PT0,5S"), timeValue, -500 * time.Millisecond, ""},
+		{reflect.ValueOf("P1Y"), timeValue, nil, `invalid ISO 8601 duration "P1Y"`},
+		{reflect.ValueOf("5x"), timeValue, nil, `invalid ISO 8601 duration "5x"`},
+		{reflect.ValueOf(Timeout("PT1M")), timeValue, time.Minute, ""},
+		{reflect.ValueOf("PT1H"), strValue, "PT1H", ""},
+		{reflect.ValueOf(5), timeValue, 5, ""},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("case %d: expected err %q, got %v", i, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	// Without custom parsers only Go durations are accepted.
+	if _, err := DecodeHookExec(StringToDurationHookFunc(), reflect.ValueOf("PT1H"), timeValue); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseISO8601Duration(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected time.Duration
+		err      bool
+	}{
+		{"PT0S", 0, false},
+		{"PT1H30M", 90 * time.Minute, false},
+		{"PT1.5H", 90 * time.Minute, false},
+		{"P1D", 24 * time.Hour, false},
+		{"P2W", 14 * 24 * time.Hour, false},
+		{"+PT10S", 10 * time.Second, false},
+		{"-P1DT1H", -25 * time.Hour, false},
+		{"P", 0, true},
+		{"PT", 0, true},
+		{"P1DT", 0, true},
+		{"PT1S1M", 0, true},
+		{"P1M", 0, true},
+		{"PT1..5S", 0, true},
+		{"1h", 0, true},
+		{"P200000W", 0, true},
+		{"PT9223372036.854775808S", 0, true},
+	}
+
+	for _, tc := range cases {
+		actual, err := ParseISO8601Duration(tc.input)
+		if tc.err != (err != nil) {
+			t.Fatalf("%q: expected err %#v, got %v", tc.input, tc.err, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("%q: expected %s, got %s", tc.input, tc.expected, actual)
+		}
+	}
+}
+
+type (
+	aliasedTime = time.Time
+	definedTime time.Time
+)
+
+func TestStringToTimeHookFunc(t *testing.T) {
+	strValue := reflect.ValueOf("5")
+	timeValue := reflect.ValueOf(time.Time{})
+	cases := []struct {
+		f, t   reflect.Value
+		layout string
+		result interface{}
+		err    bool
+	}{
+		{
+			reflect.ValueOf("2006-01-02T15:04:05Z"), timeValue, time.RFC3339,
+			time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), false,
+		},
+		{strValue, timeValue, time.RFC3339, time.Time{}, true},
+		{strValue, strValue, time.RFC3339, "5", false},
+		{
+			reflect.ValueOf("2006-01-02T15:04:05Z"), reflect.ValueOf(aliasedTime{}), time.RFC3339,
+			time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), false,
+		},
+		{
+			reflect.ValueOf("2006-01-02T15:04:05Z"), reflect.ValueOf(definedTime{}), time.RFC3339,
+			definedTime(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)), false,
+		},
+		{strValue, reflect.ValueOf(definedTime{}), time.RFC3339, definedTime{}, true},
+		{reflect.ValueOf("2006-01-02T15:04:05Z"), reflect.ValueOf(struct{ Time time.Time }{}), time.RFC3339, "2006-01-02T15:04:05Z", false},
+	}
+
+	for i, tc := range cases {
+		f := StringToTimeHookFunc(tc.layout)
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	var result struct {
+		Aliased aliasedTime
+		Defined definedTime
+	}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: StringToTimeHookFunc(time.RFC3339),
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	input := map[string]interface{}{
+		"aliased": "2006-01-02T15:04:05Z",
+		"defined": "2007-01-02T15:04:05Z",
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !result.Aliased.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)) ||
+		!time.Time(result.Defined).Equal(time.Date(2007, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestStringToByteSizeHookFunc(t *testing.T) {
+	type Size string
+
+	f := StringToByteSizeHookFunc()
+
+	intValue := reflect.ValueOf(int(0))
+	uint64Value := reflect.ValueOf(uint64(0))
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("10MB"), intValue, 10000000, false},
+		{reflect.ValueOf("10 MiB"), intValue, 10485760, false},
+		{reflect.ValueOf("1.5GB"), uint64Value, uint64(1500000000), false},
+		{reflect.ValueOf("1.5kib"), intValue, 1536, false},
+		{reflect.ValueOf("42"), intValue, 42, false},
+		{reflect.ValueOf("42B"), reflect.ValueOf(int16(0)), int16(42), false},
+		{reflect.ValueOf("1EiB"), uint64Value, uint64(1 << 60), false},
+		{reflect.ValueOf(Size("2KB")), intValue, 2000, false},
+		{reflect.ValueOf("1.5B"), intValue, nil, true},
+		{reflect.ValueOf("10XB"), intValue, nil, true},
+		{reflect.ValueOf("MB"), intValue, nil, true},
+		{reflect.ValueOf("-1MB"), intValue, nil, true},
+		{reflect.ValueOf("1MB"), reflect.ValueOf(int8(0)), nil, true},
+		{reflect.ValueOf("10MB"), reflect.ValueOf(""), "10MB", false},
+		{reflect.ValueOf("10MB"), reflect.ValueOf(float64(0)), "10MB", false},
+		{reflect.ValueOf("5s"), reflect.ValueOf(time.Duration(0)), "5s", false},
+		{reflect.ValueOf(42), intValue, 42, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf("-5"), intValue)
+	if err == nil || err.Error() != `failed parsing size "-5": negative sizes are not supported` {
+		t.Fatalf("expected a negative size error, got %v", err)
+	}
+}
+
+func TestStringToMACHookFunc(t *testing.T) {
+	f := StringToMACHookFunc()
+
+	mac := net.HardwareAddr{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}
+	macValue := reflect.ValueOf(net.HardwareAddr{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("01:23:45:67:89:ab"), macValue, mac, false},
+		{reflect.ValueOf("01-23-45-67-89-AB"), macValue, mac, false},
+		{reflect.ValueOf("0123.4567.89ab"), macValue, mac, false},
+		{reflect.ValueOf("01:23:45"), macValue, nil, true},
+		{reflect.ValueOf("01:23:45:67:89:ab"), reflect.ValueOf(""), "01:23:45:67:89:ab", false},
+		{reflect.ValueOf([]byte{1, 2}), macValue, []byte{1, 2}, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if tc.err {
+			if !strings.Contains(err.Error(), `"01:23:45"`) {
+				t.Fatalf("case %d: expected the address in the error, got %s", i, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToTCPAddrHookFunc(t *testing.T) {
+	f := StringToTCPAddrHookFunc()
+
+	addrValue := reflect.ValueOf(&net.TCPAddr{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    string
+	}{
+		{
+			reflect.ValueOf("127.0.0.1:80"),
+			addrValue,
+			&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80},
+			"",
+		},
+		{
+			reflect.ValueOf("[::1]:8080"),
+			reflect.ValueOf(net.TCPAddr{}),
+			&net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080},
+			"",
 		},
+		{reflect.ValueOf("127.0.0.1"), addrValue, nil, "missing port in address"},
+		{reflect.ValueOf("::1:80"), addrValue, nil, "too many colons in address"},
+		{reflect.ValueOf("127.0.0.1:http-nope"), addrValue, nil, "failed resolving tcp address"},
+		{reflect.ValueOf("127.0.0.1:80"), reflect.ValueOf(""), "127.0.0.1:80", ""},
+		{reflect.ValueOf(80), addrValue, 80, ""},
 	}
 
 	for i, tc := range cases {
 		actual, err := DecodeHookExec(f, tc.f, tc.t)
-		if tc.err != (err != nil) {
-			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("case %d: expected error containing %q, got %v", i, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
 		}
 		if !reflect.DeepEqual(actual, tc.result) {
 			t.Fatalf(
@@ -258,25 +1859,101 @@ func TestStringToSliceHookFunc(t *testing.T) {
 	}
 }
 
-func TestStringToTimeDurationHookFunc(t *testing.T) {
-	f := StringToTimeDurationHookFunc()
+func TestStringToUDPAddrHookFunc(t *testing.T) {
+	f := StringToUDPAddrHookFunc()
 
-	timeValue := reflect.ValueOf(time.Duration(5))
-	strValue := reflect.ValueOf("")
+	var result struct {
+		Addr  *net.UDPAddr
+		Value net.UDPAddr
+	}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: f,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"addr":  "[::1]:53",
+		"value": "10.0.0.1:5353",
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Addr == nil || result.Addr.String() != "[::1]:53" {
+		t.Fatalf("bad addr: %#v", result.Addr)
+	}
+	if result.Value.String() != "10.0.0.1:5353" {
+		t.Fatalf("bad value: %#v", result.Value)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"addr": "10.0.0.1"})
+	if err == nil || !strings.Contains(err.Error(), `failed parsing udp address "10.0.0.1"`) {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestStringToSemverHookFunc(t *testing.T) {
+	type Version string
+	type Semver struct {
+		Major, Minor, Patch uint64
+		Prerelease, Build   string
+	}
+	type Release struct {
+		Major      uint8
+		Minor      int
+		Patch      int64
+		Prerelease string
+		Build      int
+	}
+
+	f := StringToSemverHookFunc(reflect.TypeOf(Version("")))
+
+	semverValue := reflect.ValueOf(Semver{})
 	cases := []struct {
 		f, t   reflect.Value
 		result interface{}
-		err    bool
+		err    string
 	}{
-		{reflect.ValueOf("5s"), timeValue, 5 * time.Second, false},
-		{reflect.ValueOf("5"), timeValue, time.Duration(0), true},
-		{reflect.ValueOf("5"), strValue, "5", false},
+		{
+			reflect.ValueOf("v1.2.3-rc.1+build.5"),
+			semverValue,
+			Semver{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1", Build: "build.5"},
+			"",
+		},
+		{reflect.ValueOf("v1.2"), reflect.ValueOf(Version("")), Version("1.2.0"), ""},
+		{reflect.ValueOf("1.2.3-beta+b"), reflect.ValueOf(Version("")), Version("1.2.3-beta+b"), ""},
+		{
+			reflect.ValueOf("2.3.4-alpha+b"),
+			reflect.ValueOf(Release{}),
+			Release{Major: 2, Minor: 3, Patch: 4, Prerelease: "alpha"},
+			"",
+		},
+		{reflect.ValueOf("1.2.x"), semverValue, nil, `invalid semantic version "1.2.x": invalid number "x"`},
+		{reflect.ValueOf("1.2.3-"), reflect.ValueOf(Version("")), nil, `invalid semantic version "1.2.3-": invalid prerelease`},
+		{
+			reflect.ValueOf("256.0.0"),
+			reflect.ValueOf(Release{}),
+			nil,
+			`semantic version "256.0.0" overflows field Major of mapstructure.Release`,
+		},
+		{reflect.ValueOf("1.2.3"), reflect.ValueOf(""), "1.2.3", ""},
+		{reflect.ValueOf("1.2.3"), reflect.ValueOf(struct{ Major int }{}), "1.2.3", ""},
+		{reflect.ValueOf(1), semverValue, 1, ""},
 	}
 
 	for i, tc := range cases {
 		actual, err := DecodeHookExec(f, tc.f, tc.t)
-		if tc.err != (err != nil) {
-			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("case %d: expected err %q, got %v", i, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: unexpected err %s", i, err)
 		}
 		if !reflect.DeepEqual(actual, tc.result) {
 			t.Fatalf(
@@ -284,30 +1961,58 @@ func TestStringToTimeDurationHookFunc(t *testing.T) {
 				i, tc.result, actual)
 		}
 	}
+
+	var result struct {
+		Version *Semver
+		Tag     Version
+	}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: f,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"version": "1.2.3", "tag": "v4"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Version == nil || *result.Version != (Semver{Major: 1, Minor: 2, Patch: 3}) || result.Tag != "4.0.0" {
+		t.Fatalf("bad: %#v", result)
+	}
 }
 
-func TestStringToTimeHookFunc(t *testing.T) {
-	strValue := reflect.ValueOf("5")
-	timeValue := reflect.ValueOf(time.Time{})
+func TestStringToURLValuesHookFunc(t *testing.T) {
+	f := StringToURLValuesHookFunc()
+
+	valuesValue := reflect.ValueOf(url.Values{})
 	cases := []struct {
 		f, t   reflect.Value
-		layout string
 		result interface{}
 		err    bool
 	}{
 		{
-			reflect.ValueOf("2006-01-02T15:04:05Z"), timeValue, time.RFC3339,
-			time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), false,
+			reflect.ValueOf("a=1&b=2&a=3"),
+			valuesValue,
+			url.Values{"a": {"1", "3"}, "b": {"2"}},
+			false,
 		},
-		{strValue, timeValue, time.RFC3339, time.Time{}, true},
-		{strValue, strValue, time.RFC3339, "5", false},
+		{reflect.ValueOf("q=hello+world&x=%2F"), valuesValue, url.Values{"q": {"hello world"}, "x": {"/"}}, false},
+		{reflect.ValueOf(""), valuesValue, url.Values{}, false},
+		{reflect.ValueOf("a=%zz"), valuesValue, nil, true},
+		{reflect.ValueOf("a=1"), reflect.ValueOf(map[string][]string{}), "a=1", false},
+		{reflect.ValueOf(map[string][]string{"a": {"1"}}), valuesValue, map[string][]string{"a": {"1"}}, false},
 	}
 
 	for i, tc := range cases {
-		f := StringToTimeHookFunc(tc.layout)
 		actual, err := DecodeHookExec(f, tc.f, tc.t)
 		if tc.err != (err != nil) {
-			t.Fatalf("case %d: expected err %#v", i, tc.err)
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if tc.err {
+			if !strings.Contains(err.Error(), `"a=%zz"`) {
+				t.Fatalf("case %d: expected the query string in the error, got %s", i, err)
+			}
+			continue
 		}
 		if !reflect.DeepEqual(actual, tc.result) {
 			t.Fatalf(
@@ -382,6 +2087,144 @@ func TestStringToIPNetHookFunc(t *testing.T) {
 	}
 }
 
+func TestStringToTimeZoneHookFunc(t *testing.T) {
+	f := StringToTimeZoneHookFunc()
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database not available: %s", err)
+	}
+
+	locValue := reflect.ValueOf(time.UTC)
+	strValue := reflect.ValueOf("")
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("America/New_York"), locValue, newYork, false},
+		{reflect.ValueOf("UTC"), locValue, time.UTC, false},
+		{reflect.ValueOf("utc"), locValue, time.UTC, false},
+		{reflect.ValueOf("Local"), locValue, time.Local, false},
+		{reflect.ValueOf("Nowhere/Special"), locValue, nil, true},
+		{reflect.ValueOf("UTC"), strValue, "UTC", false},
+		{reflect.ValueOf(5), locValue, 5, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if tc.err {
+			if !strings.Contains(err.Error(), "Nowhere/Special") {
+				t.Fatalf("case %d: expected the name in the error, got %s", i, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToRegexpHookFunc(t *testing.T) {
+	type Pattern string
+
+	strValue := reflect.ValueOf("42")
+	reValue := reflect.ValueOf(&regexp.Regexp{})
+
+	cases := []struct {
+		f      DecodeHookFunc
+		from   reflect.Value
+		to     reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{StringToRegexpHookFunc(), reflect.ValueOf("^a+$"), reValue, regexp.MustCompile("^a+$"), false},
+		{StringToRegexpHookFunc(), reflect.ValueOf(""), reValue, regexp.MustCompile(""), false},
+		{StringToRegexpHookFunc(), reflect.ValueOf("(a"), reValue, nil, true},
+		{StringToRegexpHookFunc(), reflect.ValueOf(Pattern("^b$")), reValue, regexp.MustCompile("^b$"), false},
+		{StringToRegexpHookFunc(), strValue, strValue, "42", false},
+		{StringToRegexpHookFunc(), reflect.ValueOf(42), reValue, 42, false},
+		{StringToRegexpPOSIXHookFunc(), reflect.ValueOf("a|ab"), reValue, regexp.MustCompilePOSIX("a|ab"), false},
+		{StringToRegexpPOSIXHookFunc(), reflect.ValueOf(`\d`), reValue, nil, true},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(tc.f, tc.from, tc.to)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
+func TestStringToBigRatHookFunc(t *testing.T) {
+	f := StringToBigRatHookFunc()
+
+	strValue := reflect.ValueOf("42")
+	ratValue := reflect.ValueOf(&big.Rat{})
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("3/4"), ratValue, big.NewRat(3, 4), false},
+		{reflect.ValueOf("0.75"), ratValue, big.NewRat(3, 4), false},
+		{reflect.ValueOf("-6/8"), ratValue, big.NewRat(-3, 4), false},
+		{reflect.ValueOf("1e3"), ratValue, big.NewRat(1000, 1), false},
+		{reflect.ValueOf("42"), ratValue, big.NewRat(42, 1), false},
+		{reflect.ValueOf("1/0"), ratValue, nil, true},
+		{reflect.ValueOf("three quarters"), ratValue, nil, true},
+		{reflect.ValueOf(""), ratValue, nil, true},
+		{strValue, strValue, "42", false},
+		{reflect.ValueOf(0.75), ratValue, 0.75, false},
+	}
+
+	for i, tc := range cases {
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v, got %v", i, tc.err, err)
+		}
+		if r, ok := actual.(*big.Rat); ok {
+			if r.Cmp(tc.result.(*big.Rat)) != 0 {
+				t.Fatalf("case %d: expected %s, got %s", i, tc.result, r)
+			}
+		} else if !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+
+	_, err := DecodeHookExec(f, reflect.ValueOf("3//4"), ratValue)
+	expected := `failed parsing rational "3//4"`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	var result struct {
+		Ratio *big.Rat
+	}
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: f, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"ratio": "1/3"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Ratio == nil || result.Ratio.Cmp(big.NewRat(1, 3)) != 0 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestWeaklyTypedHook(t *testing.T) {
 	var f DecodeHookFunc = WeaklyTypedHook
 
@@ -561,6 +2404,188 @@ func TestStructToMapHookFuncTabled(t *testing.T) {
 	}
 }
 
+type discriminatorPlugin interface {
+	Kind() string
+}
+
+type discriminatorHTTP struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (p *discriminatorHTTP) Kind() string { return "http" }
+
+type discriminatorExec struct {
+	Command []string
+}
+
+func (p discriminatorExec) Kind() string { return "exec" }
+
+func TestDiscriminatorHookFunc(t *testing.T) {
+	hook := ComposeDecodeHookFunc(
+		DiscriminatorHookFunc("type", map[string]interface{}{
+			"http": (*discriminatorHTTP)(nil),
+			"exec": discriminatorExec{},
+		}),
+		StringToTimeDurationHookFunc(),
+	)
+
+	type Target struct {
+		Main    discriminatorPlugin
+		Plugins []discriminatorPlugin
+		Other   interface{}
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook:  hook,
+		ErrorUnused: true,
+		Result:      &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"main": map[string]interface{}{"type": "exec", "command": []string{"true"}},
+		"plugins": []interface{}{
+			map[string]interface{}{"type": "http", "url": "http://a", "timeout": "5s"},
+			nil,
+			map[interface{}]interface{}{"type": "exec", "command": []string{"ls", "-l"}},
+		},
+		"other": map[string]interface{}{"type": "http"},
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Main: discriminatorExec{Command: []string{"true"}},
+		Plugins: []discriminatorPlugin{
+			&discriminatorHTTP{URL: "http://a", Timeout: 5 * time.Second},
+			nil,
+			discriminatorExec{Command: []string{"ls", "-l"}},
+		},
+		Other: map[string]interface{}{"type": "http"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	cases := []struct {
+		input interface{}
+		err   string
+	}{
+		{
+			[]interface{}{
+				map[string]interface{}{"type": "exec"},
+				map[string]interface{}{"type": "grpc"},
+			},
+			`error decoding 'Plugins[1]': unknown type 'grpc' for 'mapstructure.discriminatorPlugin'`,
+		},
+		{
+			[]interface{}{map[string]interface{}{"url": "http://a"}},
+			`error decoding 'Plugins[0]': missing discriminator key 'type' for 'mapstructure.discriminatorPlugin'`,
+		},
+		{
+			[]interface{}{map[string]interface{}{"type": 42}},
+			`error decoding 'Plugins[0]': unknown type '42' for 'mapstructure.discriminatorPlugin'`,
+		},
+	}
+
+	for i, tc := range cases {
+		err := decoder.Decode(map[string]interface{}{"plugins": tc.input})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("case %d: expected error containing %q, got %v", i, tc.err, err)
+		}
+	}
+
+	// The hook leaves the target alone, the decoder replaces it.
+	var plugin discriminatorPlugin = discriminatorExec{Command: []string{"keep"}}
+	to := reflect.ValueOf(&plugin).Elem()
+	if _, err := DecodeHookExec(hook, reflect.ValueOf(map[string]interface{}{"type": "http"}), to); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(plugin, discriminatorExec{Command: []string{"keep"}}) {
+		t.Fatalf("expected the target to be unchanged, got %#v", plugin)
+	}
+
+	shared := &discriminatorHTTP{URL: "http://keep"}
+	result = Target{Main: shared}
+	if err := decoder.Decode(map[string]interface{}{"main": map[string]interface{}{"type": "http", "url": "http://b"}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if main, ok := result.Main.(*discriminatorHTTP); !ok || main == shared || main.URL != "http://b" {
+		t.Fatalf("expected a new *discriminatorHTTP, got %#v", result.Main)
+	}
+	if shared.URL != "http://keep" {
+		t.Fatalf("expected the previous value to be unchanged, got %#v", shared)
+	}
+}
+
+func TestStructToMapHookFuncCycle(t *testing.T) {
+	type node struct {
+		Name string `mapstructure:"name"`
+		Next *node  `mapstructure:"next"`
+	}
+
+	n := &node{Name: "a"}
+	n.Next = &node{Name: "b", Next: n}
+
+	var res interface{}
+	cfg := &DecoderConfig{
+		DecodeHook: RecursiveStructToMapHookFunc(),
+		Result:     &res,
+	}
+
+	d, err := NewDecoder(cfg)
+	if err != nil {
+		t.Fatalf("unexpected err %#v", err)
+	}
+
+	err = d.Decode(*n)
+	if err == nil || !strings.Contains(err.Error(), "contains a cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	res = nil
+	cfg.BreakCycles = true
+	d, err = NewDecoder(cfg)
+	if err != nil {
+		t.Fatalf("unexpected err %#v", err)
+	}
+
+	if err := d.Decode(*n); err != nil {
+		t.Fatalf("unexpected err %#v", err)
+	}
+
+	expected := map[string]interface{}{
+		"name": "a",
+		"next": map[string]interface{}{
+			"name": "b",
+			"next": map[string]interface{}{
+				"name": "a",
+				"next": nil,
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, res) {
+		t.Fatalf("expected %#v, got %#v", expected, res)
+	}
+
+	// Pointers shared between siblings are not cycles.
+	type pair struct {
+		Left  *node `mapstructure:"left"`
+		Right *node `mapstructure:"right"`
+	}
+
+	leaf := &node{Name: "leaf"}
+	res = nil
+	if err := d.Decode(pair{Left: leaf, Right: leaf}); err != nil {
+		t.Fatalf("unexpected err %#v", err)
+	}
+}
+
 func TestTextUnmarshallerHookFunc(t *testing.T) {
 	type MyString string
 
@@ -649,6 +2674,45 @@ func TestStringToNetIPAddrPortHookFunc(t *testing.T) {
 	}
 }
 
+func TestSafeNumericHookFunc(t *testing.T) {
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf(42), reflect.ValueOf(uint8(0)), uint8(42), false},
+		{reflect.ValueOf(300), reflect.ValueOf(uint8(0)), nil, true},
+		{reflect.ValueOf(-1), reflect.ValueOf(uint(0)), nil, true},
+		{reflect.ValueOf(-128), reflect.ValueOf(int8(0)), int8(-128), false},
+		{reflect.ValueOf(-129), reflect.ValueOf(int8(0)), nil, true},
+		{reflect.ValueOf(uint64(math.MaxUint64)), reflect.ValueOf(int64(0)), nil, true},
+		{reflect.ValueOf(uint64(42)), reflect.ValueOf(int16(0)), int16(42), false},
+		{reflect.ValueOf(42.0), reflect.ValueOf(int(0)), int(42), false},
+		{reflect.ValueOf(42.5), reflect.ValueOf(int(0)), nil, true},
+		{reflect.ValueOf(-42.0), reflect.ValueOf(uint32(0)), nil, true},
+		{reflect.ValueOf(1e20), reflect.ValueOf(int64(0)), nil, true},
+		{reflect.ValueOf(1e20), reflect.ValueOf(uint64(0)), nil, true},
+		{reflect.ValueOf(math.MaxFloat64), reflect.ValueOf(float32(0)), nil, true},
+		{reflect.ValueOf(42), reflect.ValueOf(float32(0)), float32(42), false},
+		{reflect.ValueOf(int64(5)), reflect.ValueOf(time.Duration(0)), time.Duration(5), false},
+		{reflect.ValueOf("42"), reflect.ValueOf(int(0)), "42", false},
+		{reflect.ValueOf(42), reflect.ValueOf(""), 42, false},
+	}
+
+	for i, tc := range cases {
+		f := SafeNumericHookFunc()
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
 func TestStringToBasicTypeHookFunc(t *testing.T) {
 	strValue := reflect.ValueOf("42")
 
@@ -1128,6 +3192,44 @@ func TestStringToFloat64HookFunc(t *testing.T) {
 	}
 }
 
+func TestStringToHumanBoolHookFunc(t *testing.T) {
+	type Phrase string
+
+	strValue := reflect.ValueOf("42")
+	boolValue := reflect.ValueOf(true)
+
+	cases := []struct {
+		f, t   reflect.Value
+		result interface{}
+		err    bool
+	}{
+		{reflect.ValueOf("turn it on"), boolValue, true, false},
+		{reflect.ValueOf("Yes, please!"), boolValue, true, false},
+		{reflect.ValueOf("feature enabled"), boolValue, true, false},
+		{reflect.ValueOf("keep off"), boolValue, false, false},
+		{reflect.ValueOf("disabled for now"), boolValue, false, false},
+		{reflect.ValueOf("NO"), boolValue, false, false},
+		{reflect.ValueOf(Phrase("turn it on")), boolValue, true, false},
+		{reflect.ValueOf("yes and no"), boolValue, false, true},
+		{reflect.ValueOf("maybe"), boolValue, false, true},
+		{reflect.ValueOf(""), boolValue, false, true},
+		{strValue, strValue, "42", false},
+	}
+
+	for i, tc := range cases {
+		f := StringToHumanBoolHookFunc()
+		actual, err := DecodeHookExec(f, tc.f, tc.t)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: expected err %#v", i, tc.err)
+		}
+		if !tc.err && !reflect.DeepEqual(actual, tc.result) {
+			t.Fatalf(
+				"case %d: expected %#v, got %#v",
+				i, tc.result, actual)
+		}
+	}
+}
+
 func TestStringToComplex64HookFunc(t *testing.T) {
 	strValue := reflect.ValueOf("42.42+42.42i")
 	complex64Value := reflect.ValueOf(complex64(0))
@@ -0,0 +1,183 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2/internal/errors"
+)
+
+// Encode turns the struct input, or a pointer to it, into a map. It is the
+// reverse of decoding a map into a struct and honors the same tags,
+// including "-", ",squash", ",remain", ",omitempty" and ",omitzero". Nested
+// structs, including those in slices, arrays and maps and behind pointers,
+// are turned into maps as well, and values implementing Marshaler are
+// replaced by the result of their MarshalMapstructure method.
+//
+// The options are applied to the configuration of the underlying decoder,
+// for example to set the TagName or an EncodeFieldName function. Its Result
+// is ignored.
+func Encode(input interface{}, opts ...func(*DecoderConfig)) (map[string]interface{}, error) {
+	if input == nil {
+		return nil, errors.New("input must be a struct, got nil")
+	}
+	if kind := reflect.Indirect(reflect.ValueOf(input)).Kind(); kind != reflect.Struct {
+		return nil, fmt.Errorf("input must be a struct, got '%s'", kind)
+	}
+
+	var result map[string]interface{}
+	config := &DecoderConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	config.Result = &result
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		return nil, err
+	}
+	decoder.encode = true
+
+	if err := decoder.Decode(input); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encodeValue returns the value of the struct field or element v for Encode,
+// turning the structs in it into maps.
+func (d *decoder) encodeValue(name string, v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if m, ok := marshalerOf(v); ok {
+		out, err := m.MarshalMapstructure()
+		if err != nil {
+			return nil, fmt.Errorf("error encoding '%s': %w", name, err)
+		}
+		return out, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return d.encodeValue(name, v.Elem())
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		key := visitedPtr{v.Pointer(), v.Type()}
+		if _, ok := d.state.visiting[key]; ok {
+			if !d.config.BreakCycles {
+				return nil, fmt.Errorf("'%s' contains a cycle through a pointer of type '%s'", name, v.Type())
+			}
+			return nil, nil
+		}
+		if d.state.visiting == nil {
+			d.state.visiting = make(map[visitedPtr]struct{})
+		}
+		d.state.visiting[key] = struct{}{}
+		defer delete(d.state.visiting, key)
+
+		return d.encodeValue(name, v.Elem())
+
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).Round(0), nil
+		}
+
+		var m map[string]interface{}
+		if err := d.decode(name, v.Interface(), reflect.ValueOf(&m).Elem()); err != nil {
+			return nil, err
+		}
+		return m, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() || !needsEncoding(v.Type().Elem()) {
+			return v.Interface(), nil
+		}
+
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			elem, err := d.encodeValue(name+"["+strconv.Itoa(i)+"]", v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = elem
+		}
+		return out, nil
+
+	case reflect.Map:
+		if v.IsNil() || !needsEncoding(v.Type().Elem()) {
+			return v.Interface(), nil
+		}
+
+		elemType := reflect.TypeOf((*interface{})(nil)).Elem()
+		out := reflect.MakeMapWithSize(reflect.MapOf(v.Type().Key(), elemType), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := d.encodeValue(fmt.Sprintf("%s[%v]", name, iter.Key()), iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			out.SetMapIndex(iter.Key(), valueOrZero(elem, elemType))
+		}
+		return out.Interface(), nil
+
+	default:
+		return v.Interface(), nil
+	}
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// marshalerOf returns the Marshaler implementation of v, if it has one,
+// either on its value or on a pointer to it.
+func marshalerOf(v reflect.Value) (Marshaler, bool) {
+	switch {
+	case v.Type().Implements(marshalerType):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false
+		}
+		return v.Interface().(Marshaler), true
+	case reflect.PtrTo(v.Type()).Implements(marshalerType):
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		return ptr.Interface().(Marshaler), true
+	default:
+		return nil, false
+	}
+}
+
+// needsEncoding reports whether values of type t may contain structs or
+// Marshaler implementations, which encodeValue needs to replace.
+func needsEncoding(t reflect.Type) bool {
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return t != timeType
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	default:
+		return false
+	}
+}
+
+// valueOrZero returns the value of v, or the zero value of typ if v is nil.
+// Setting a map entry to the invalid value of a nil interface would delete
+// it instead.
+func valueOrZero(v interface{}, typ reflect.Type) reflect.Value {
+	if v == nil {
+		return reflect.Zero(typ)
+	}
+	return reflect.ValueOf(v)
+}
@@ -0,0 +1,170 @@
+package mapstructure
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type encodeSecret string
+
+func (s encodeSecret) MarshalMapstructure() (interface{}, error) {
+	return "***", nil
+}
+
+type encodeVersion struct {
+	Major, Minor int
+}
+
+func (v *encodeVersion) MarshalMapstructure() (interface{}, error) {
+	if v.Major < 0 {
+		return nil, errors.New("negative version")
+	}
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor), nil
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	type TLS struct {
+		Cert string `mapstructure:"cert"`
+	}
+
+	type Listener struct {
+		Port int  `mapstructure:"port"`
+		TLS  *TLS `mapstructure:"tls,omitempty"`
+	}
+
+	type Common struct {
+		Name string `mapstructure:"name"`
+	}
+
+	type Config struct {
+		Common    `mapstructure:",squash"`
+		Listeners []Listener          `mapstructure:"listeners"`
+		Primary   *Listener           `mapstructure:"primary"`
+		Backup    *Listener           `mapstructure:"backup"`
+		ByName    map[string]Listener `mapstructure:"by_name"`
+		Tags      []string            `mapstructure:"tags"`
+		Password  encodeSecret        `mapstructure:"password"`
+		Version   encodeVersion       `mapstructure:"version"`
+		Started   time.Time           `mapstructure:"started"`
+		Retries   int                 `mapstructure:"retries,omitzero"`
+		Expires   time.Time           `mapstructure:"expires,omitzero"`
+		Limit     *int                `mapstructure:"limit,omitzero"`
+		Internal  string              `mapstructure:"-"`
+		private   string
+	}
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	input := Config{
+		Common: Common{Name: "api"},
+		Listeners: []Listener{
+			{Port: 80},
+			{Port: 443, TLS: &TLS{Cert: "cert.pem"}},
+		},
+		Primary:  &Listener{Port: 8080},
+		ByName:   map[string]Listener{"admin": {Port: 9090}},
+		Tags:     []string{"a", "b"},
+		Password: "hunter2",
+		Version:  encodeVersion{Major: 1, Minor: 2},
+		Started:  started,
+		Limit:    new(int),
+		Internal: "ignored",
+		private:  "ignored",
+	}
+
+	result, err := Encode(&input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"name": "api",
+		"listeners": []interface{}{
+			map[string]interface{}{"port": 80},
+			map[string]interface{}{"port": 443, "tls": map[string]interface{}{"cert": "cert.pem"}},
+		},
+		"primary":  map[string]interface{}{"port": 8080},
+		"backup":   nil,
+		"by_name":  map[string]interface{}{"admin": map[string]interface{}{"port": 9090}},
+		"tags":     []string{"a", "b"},
+		"password": "***",
+		"version":  "v1.2",
+		"started":  started,
+		"limit":    0,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	// The result decodes back into the struct, except for the values
+	// replaced by a Marshaler.
+	var decoded Config
+	decoder, err := NewDecoder(&DecoderConfig{Squash: true, Result: &decoded})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	delete(result, "password")
+	delete(result, "version")
+	if err := decoder.Decode(result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if decoded.Name != "api" || !reflect.DeepEqual(decoded.Listeners, input.Listeners) || decoded.Primary.Port != 8080 {
+		t.Fatalf("bad: %#v", decoded)
+	}
+}
+
+func TestEncode_Options(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		Name string `json:"full_name"`
+		Port int
+	}
+
+	result, err := Encode(Config{Name: "api", Port: 80}, func(c *DecoderConfig) {
+		c.TagName = "json"
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{"full_name": "api", "Port": 80}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestEncode_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Encode(nil); err == nil || err.Error() != "input must be a struct, got nil" {
+		t.Fatalf("expected an error for nil, got %v", err)
+	}
+	if _, err := Encode(map[string]interface{}{}); err == nil || err.Error() != "input must be a struct, got 'map'" {
+		t.Fatalf("expected an error for a map, got %v", err)
+	}
+
+	type Config struct {
+		Version encodeVersion `mapstructure:"version"`
+	}
+	_, err := Encode(Config{Version: encodeVersion{Major: -1}})
+	if err == nil || !strings.Contains(err.Error(), "error encoding 'version': negative version") {
+		t.Fatalf("expected a Marshaler error, got %v", err)
+	}
+
+	type Node struct {
+		Name string
+		Next *Node
+	}
+	a := &Node{Name: "a"}
+	a.Next = &Node{Name: "b", Next: a}
+	_, err = Encode(a)
+	if err == nil || !strings.Contains(err.Error(), "contains a cycle through a pointer of type '*mapstructure.Node'") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
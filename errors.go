@@ -0,0 +1,72 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// UnconvertibleTypeError is returned when the input value has a type that
+// can't be decoded into the type of the target.
+type UnconvertibleTypeError struct {
+	// Path is the name of the value that failed to decode.
+	Path string
+
+	// From is the type of the input value and To the type of the target.
+	From reflect.Type
+	To   reflect.Type
+
+	// Value is the input value.
+	Value interface{}
+}
+
+func (e *UnconvertibleTypeError) Error() string {
+	return fmt.Sprintf(
+		"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
+		e.Path, e.To, e.From, e.Value)
+}
+
+// UnusedKeysError is returned when ErrorUnused is set and the input has keys
+// that weren't decoded.
+type UnusedKeysError struct {
+	// Path is the name of the map the keys belong to.
+	Path string
+
+	// Keys are the unused keys, sorted.
+	Keys []string
+}
+
+func (e *UnusedKeysError) Error() string {
+	return fmt.Sprintf("'%s' has invalid keys: %s", e.Path, strings.Join(e.Keys, ", "))
+}
+
+// UnsetFieldsError is returned when ErrorUnset is set and a struct has
+// fields that weren't set.
+type UnsetFieldsError struct {
+	// Path is the name of the struct the fields belong to.
+	Path string
+
+	// Fields are the names of the unset fields, sorted.
+	Fields []string
+}
+
+func (e *UnsetFieldsError) Error() string {
+	return fmt.Sprintf("'%s' has unset fields: %s", e.Path, strings.Join(e.Fields, ", "))
+}
+
+// PanicError is returned when RecoverFromPanics is set and a decode hook or
+// an Unmarshaler panics.
+type PanicError struct {
+	// Path is the name of the value that was being decoded.
+	Path string
+
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panic, as returned by debug.Stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
@@ -0,0 +1,145 @@
+package mapstructure
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnconvertibleTypeError(t *testing.T) {
+	t.Parallel()
+
+	var result struct {
+		Nested struct {
+			Count int
+		}
+	}
+
+	input := map[string]interface{}{
+		"nested": map[string]interface{}{"count": []int{1}},
+	}
+	err := Decode(input, &result)
+
+	var typeErr *UnconvertibleTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected an UnconvertibleTypeError, got %#v", err)
+	}
+
+	if typeErr.Path != "Nested.Count" ||
+		typeErr.From != reflect.TypeOf([]int{}) ||
+		typeErr.To != reflect.TypeOf(0) ||
+		!reflect.DeepEqual(typeErr.Value, []int{1}) {
+		t.Fatalf("bad error: %#v", typeErr)
+	}
+
+	expected := "'Nested.Count' expected type 'int', got unconvertible type '[]int', value: '[1]'"
+	if typeErr.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, typeErr.Error())
+	}
+}
+
+func TestUnusedKeysError(t *testing.T) {
+	t.Parallel()
+
+	var result struct {
+		Name string
+	}
+
+	decoder, err := NewDecoder(&DecoderConfig{
+		ErrorUnused: true,
+		Result:      &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"name": "foo", "b": 1, "a": 2})
+
+	var unusedErr *UnusedKeysError
+	if !errors.As(err, &unusedErr) {
+		t.Fatalf("expected an UnusedKeysError, got %#v", err)
+	}
+
+	if unusedErr.Path != "" || !reflect.DeepEqual([]string{"a", "b"}, unusedErr.Keys) {
+		t.Fatalf("bad error: %#v", unusedErr)
+	}
+	if unusedErr.Error() != "'' has invalid keys: a, b" {
+		t.Fatalf("bad message: %q", unusedErr.Error())
+	}
+}
+
+func TestUnsetFieldsError(t *testing.T) {
+	t.Parallel()
+
+	var result struct {
+		Nested struct {
+			Name string
+			Port int
+			Host string
+		}
+	}
+
+	decoder, err := NewDecoder(&DecoderConfig{
+		ErrorUnset: true,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{
+		"nested": map[string]interface{}{"name": "foo"},
+	})
+
+	var unsetErr *UnsetFieldsError
+	if !errors.As(err, &unsetErr) {
+		t.Fatalf("expected an UnsetFieldsError, got %#v", err)
+	}
+
+	if unsetErr.Path != "Nested" || !reflect.DeepEqual([]string{"Host", "Port"}, unsetErr.Fields) {
+		t.Fatalf("bad error: %#v", unsetErr)
+	}
+	if unsetErr.Error() != "'Nested' has unset fields: Host, Port" {
+		t.Fatalf("bad message: %q", unsetErr.Error())
+	}
+}
+
+func TestPanicError(t *testing.T) {
+	t.Parallel()
+
+	var result struct {
+		Nested struct {
+			Port int
+		}
+	}
+
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: func(from, to reflect.Type, data interface{}) (interface{}, error) {
+			if to.Kind() == reflect.Int {
+				panic("boom")
+			}
+			return data, nil
+		},
+		RecoverFromPanics: true,
+		Result:            &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{
+		"nested": map[string]interface{}{"port": 8080},
+	})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a PanicError, got %#v", err)
+	}
+
+	if panicErr.Path != "Nested.Port" || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("bad error: %#v", panicErr)
+	}
+	if panicErr.Error() != "panic: boom" {
+		t.Fatalf("bad message: %q", panicErr.Error())
+	}
+}
@@ -6,6 +6,8 @@
 
 package errors
 
+import "errors"
+
 // Join returns an error that wraps the given errors.
 // Any nil error values are discarded.
 // Join returns nil if every value in errs is nil.
@@ -59,3 +61,27 @@ func (e *joinError) Error() string {
 func (e *joinError) Unwrap() []error {
 	return e.errs
 }
+
+// Is reports whether any of the errors matches target. The errors.Is of Go
+// versions before 1.20 doesn't follow Unwrap() []error, so without it
+// errors.Is would never look into the joined errors.
+func (e *joinError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, like errors.As.
+// The errors.As of Go versions before 1.20 doesn't follow Unwrap() []error,
+// so without it errors.As would never look into the joined errors.
+func (e *joinError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
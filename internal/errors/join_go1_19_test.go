@@ -0,0 +1,47 @@
+//go:build !go1.20
+
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testError struct {
+	msg string
+}
+
+func (e *testError) Error() string {
+	return e.msg
+}
+
+func TestJoin_IsAs(t *testing.T) {
+	errFirst := errors.New("first")
+	errTyped := &testError{"typed"}
+	err := fmt.Errorf("wrapped: %w", Join(errFirst, fmt.Errorf("nested: %w", errTyped)))
+
+	if !errors.Is(err, errFirst) {
+		t.Fatalf("expected %v to match %v", err, errFirst)
+	}
+	if errors.Is(err, errors.New("first")) {
+		t.Fatalf("expected %v not to match another error", err)
+	}
+
+	var target *testError
+	if !As(err, &target) || target != errTyped {
+		t.Fatalf("expected %v to contain %v, got %v", err, errTyped, target)
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	if As(err, &timeoutErr) {
+		t.Fatalf("expected %v not to contain a timeout error", err)
+	}
+
+	// The methods are what errors.Is and errors.As rely on before Go 1.20.
+	joined := Join(errFirst, errTyped).(*joinError)
+	target = nil
+	if !joined.Is(errFirst) || !joined.As(&target) || target != errTyped {
+		t.Fatalf("expected the methods of %v to find the joined errors", joined)
+	}
+}
@@ -0,0 +1,113 @@
+package mapstructure
+
+import (
+	"strings"
+	"unicode"
+)
+
+// KeyCaseTransform is the case that the keys of the input and the names of
+// struct fields are converted to before they are matched, so that keys
+// written in one convention match fields named in another.
+type KeyCaseTransform int
+
+const (
+	// KeyCaseNone matches keys as they are.
+	KeyCaseNone KeyCaseTransform = iota
+
+	// SnakeCase converts names to snake case, such as "max_connections".
+	SnakeCase
+
+	// CamelCase converts names to camel case, such as "maxConnections".
+	CamelCase
+
+	// KebabCase converts names to kebab case, such as "max-connections".
+	KebabCase
+
+	// LowerCase converts names to lower case without separators, such as
+	// "maxconnections".
+	LowerCase
+)
+
+// apply returns name converted to the case of t.
+func (t KeyCaseTransform) apply(name string) string {
+	switch t {
+	case SnakeCase:
+		return joinKeyWords(splitKeyWords(name), "_", false)
+	case CamelCase:
+		return joinKeyWords(splitKeyWords(name), "", true)
+	case KebabCase:
+		return joinKeyWords(splitKeyWords(name), "-", false)
+	case LowerCase:
+		return joinKeyWords(splitKeyWords(name), "", false)
+	default:
+		return name
+	}
+}
+
+// splitKeyWords splits name into words at underscores, hyphens, dots and
+// spaces, and where the case changes: before an upper case letter that
+// follows a lower case letter or a digit, and before the last letter of a
+// run of upper case letters that is followed by a lower case letter, so
+// that "HTTPServer" is split into "HTTP" and "Server".
+func splitKeyWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == '.' || r == ' ':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(r):
+			prev := runes[i-1]
+			acronymEnd := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || acronymEnd {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+
+	return words
+}
+
+// joinKeyWords joins the lower cased words with sep, capitalizing all but
+// the first one if title is set.
+func joinKeyWords(words []string, sep string, title bool) string {
+	var b strings.Builder
+	for i, word := range words {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+
+		word = strings.ToLower(word)
+		if title && i > 0 {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		b.WriteString(word)
+	}
+
+	return b.String()
+}
+
+// matchKeyCase reports whether mapKey matches the given field name of the
+// struct at path once both are converted with KeyCaseTransform. The names
+// set with a tag are used as is, only the key is converted.
+func (d *decoder) matchKeyCase(path, mapKey, fieldName string, tagged bool) bool {
+	transform := d.config.KeyCaseTransform
+	if transform == KeyCaseNone {
+		return false
+	}
+
+	if !tagged {
+		fieldName = transform.apply(fieldName)
+	}
+	return d.matchName(path, transform.apply(mapKey), fieldName)
+}
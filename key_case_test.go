@@ -0,0 +1,37 @@
+package mapstructure
+
+import "testing"
+
+func TestKeyCaseTransform(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name                           string
+		snake, camel, kebab, lowercase string
+	}{
+		{"maxConnections", "max_connections", "maxConnections", "max-connections", "maxconnections"},
+		{"MaxConnections", "max_connections", "maxConnections", "max-connections", "maxconnections"},
+		{"max_connections", "max_connections", "maxConnections", "max-connections", "maxconnections"},
+		{"max-connections", "max_connections", "maxConnections", "max-connections", "maxconnections"},
+		{"HTTPServer", "http_server", "httpServer", "http-server", "httpserver"},
+		{"ServerID", "server_id", "serverId", "server-id", "serverid"},
+		{"ipv4Addr", "ipv4_addr", "ipv4Addr", "ipv4-addr", "ipv4addr"},
+		{"log.level", "log_level", "logLevel", "log-level", "loglevel"},
+		{"__a__b", "a_b", "aB", "a-b", "ab"},
+		{"", "", "", "", ""},
+	}
+
+	for _, tc := range cases {
+		for transform, expected := range map[KeyCaseTransform]string{
+			KeyCaseNone: tc.name,
+			SnakeCase:   tc.snake,
+			CamelCase:   tc.camel,
+			KebabCase:   tc.kebab,
+			LowerCase:   tc.lowercase,
+		} {
+			if actual := transform.apply(tc.name); actual != expected {
+				t.Errorf("%d: expected %q to become %q, got %q", transform, tc.name, expected, actual)
+			}
+		}
+	}
+}
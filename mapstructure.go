@@ -34,6 +34,17 @@
 //	    Username string `mapstructure:"user"`
 //	}
 //
+// Map keys that aren't strings, such as the keys of a map[int]interface{},
+// are matched against field names in their fmt.Sprint form, so that the
+// key 1 matches a field tagged "1". It is an error for two keys of the same
+// map to have the same string form.
+//
+// Conversely, when decoding into a map, the keys of the input are decoded
+// into the key type of the map like any other value, through the
+// DecodeHook. Since formats such as JSON only have string keys, string keys
+// are also parsed into integer and float key types, so that {"2": "warn"}
+// can be decoded into a map[Level]string where Level is an int.
+//
 // # Embedded Structs and Squashing
 //
 // Embedded structs are treated as if they're another field with that name.
@@ -86,6 +97,26 @@
 // DecoderConfig has a field that changes the behavior of mapstructure
 // to always squash embedded structs.
 //
+// Map fields can be squashed as well. When decoding into a struct, all keys
+// that don't match another field are collected into the squashed map. When
+// decoding from a struct to a map, the entries of the squashed map are
+// merged into the resulting map:
+//
+//	type Friend struct {
+//	    Name   string
+//	    Labels map[string]interface{} `mapstructure:",squash"`
+//	}
+//
+// Struct fields always take precedence: if a squashed map contains a key
+// that is also the name of a field, that entry is dropped when decoding
+// from the struct, and a matching input key always goes to the field.
+//
+// Interface fields that hold a struct or a pointer to a struct, such as a
+// default implementation, can be squashed too. The input is decoded into a
+// copy of the value they hold, which then replaces it. The same applies to
+// any non-nil interface field decoded by name, so a value a pointer refers
+// to is never modified in place.
+//
 // # Remainder Values
 //
 // If there are any unmapped keys in the source value, mapstructure by
@@ -94,9 +125,11 @@
 // of the unused keys.
 //
 // You can also use the ",remain" suffix on your tag to collect all unused
-// values in a map. The field with this tag MUST be a map type and should
-// probably be a "map[string]interface{}" or "map[interface{}]interface{}".
-// See example below:
+// values in a map. The field with this tag MUST be a map or struct type,
+// and should probably be a "map[string]interface{}" or
+// "map[interface{}]interface{}". The unused values are decoded into it
+// like any other field, so they don't count as unused anymore and are
+// tracked in Metadata under the name of the field. See example below:
 //
 //	type Friend struct {
 //	    Name  string
@@ -111,6 +144,220 @@
 //	    "address": "123 Maple St.",
 //	}
 //
+// # Conflicting Fields
+//
+// Two fields can be declared mutually exclusive with the ",conflictswith="
+// option followed by the name of the other field, matched against its key
+// name like a key of the input. Decoding fails if the input contains a
+// value for both of them, or if there is no such field:
+//
+//	type Source struct {
+//	    File   string `mapstructure:"file,conflictswith=inline"`
+//	    Inline string `mapstructure:"inline"`
+//	}
+//
+// # Including Fields
+//
+// When DecoderConfig.IgnoreUntaggedFields is set, fields without a tag are
+// skipped. A field can opt back in without renaming it by using the
+// ",include" option:
+//
+//	type Source struct {
+//	    Name string `mapstructure:",include"`
+//	    Age  int    // ignored
+//	}
+//
+// DecoderConfig.OnlyIncludedFields goes further and skips every field that
+// doesn't have the ",include" option, even if it is tagged.
+//
+// # Raw Values on Failure
+//
+// A field with the ",rawonfail=" option followed by the name of a sibling
+// field doesn't fail the decoding when its value cannot be converted.
+// Instead, the field is left at its zero value and the raw input is stored
+// in the sibling field, which should be of type interface{}:
+//
+//	type Source struct {
+//	    Port    int         `mapstructure:"port,rawonfail=port_raw"`
+//	    PortRaw interface{} `mapstructure:"port_raw"`
+//	}
+//
+// # Primary Fields
+//
+// A struct can be decoded from a scalar value, rather than a map, if one of
+// its fields has the ",primary" option. The scalar is decoded into that
+// field and the other fields are left untouched:
+//
+//	type Port struct {
+//	    Value int `mapstructure:",primary"`
+//	    Raw   string
+//	}
+//
+// At most one field of a struct can be primary.
+//
+// # Positional Fields
+//
+// A struct can be decoded from a slice or an array, such as a record of a
+// CSV-like format, if its fields have the ",pos=" option followed by the
+// position of the element they are decoded from. Fields without the option
+// are left untouched:
+//
+//	type Server struct {
+//	    Env  string `mapstructure:",pos=0"`
+//	    Port int    `mapstructure:",pos=1"`
+//	    TLS  bool   `mapstructure:",pos=2"`
+//	}
+//
+// It is an error for a position to be out of range of the input. If no
+// field has the option and SlicePositional is set, the elements are decoded
+// into the fields in the order they are declared, and the input must have
+// exactly one element per field.
+//
+// # Decode Hook Timeouts
+//
+// The ",timeout=" option followed by a duration bounds the time the
+// DecodeHook may take to process the value of a field. If the hook doesn't
+// return in time, decoding the field fails. This is meant for hooks that
+// do slow work such as reading files:
+//
+//	type Source struct {
+//	    Certificate []byte `mapstructure:"certificate,timeout=2s"`
+//	}
+//
+// The limit only applies to the hook call for the field itself, not to the
+// hook calls for the values nested in it.
+//
+// Hooks can't be interrupted, so the hook runs in its own goroutine and is
+// abandoned when the timeout expires: it keeps running until it returns,
+// and its result is discarded. The goroutine isn't cancelled and only ends
+// when the hook returns, so a hook that never returns leaks it. A panic in
+// the hook is reported as if the hook had run in the goroutine calling
+// Decode, unless the timeout expired first, in which case it is discarded
+// as well.
+//
+// The hook is given a shallow copy of the field, so an abandoned hook
+// doesn't overwrite the field itself. The maps, slices and pointers the
+// field holds are shared though, and a hook still modifying them after the
+// timeout races with the code using the result.
+//
+// # Named Hooks
+//
+// The ",hook=" option followed by a name runs the hook registered under
+// that name in NamedHooks for the value of the field, before the
+// DecodeHook, leaving the other fields unaffected:
+//
+//	type Event struct {
+//	    CreatedAt time.Time `mapstructure:"created_at,hook=unixms"`
+//	}
+//
+// Like the timeout, the hook only applies to the value of the field itself,
+// not to the values nested in it.
+//
+// # Plural Keys
+//
+// A slice field with the ",plural" option matches both its own, plural,
+// name and the singular name obtained by removing the trailing "s". A
+// single value under the singular name is decoded as a one-element slice:
+//
+//	type Source struct {
+//	    Hosts []string `mapstructure:"hosts,plural"`
+//	}
+//
+// Both {"host": "a"} and {"hosts": ["a", "b"]} can be decoded into Source.
+//
+// # Unique Slices
+//
+// A slice field with the ",unique" option has its duplicate elements
+// removed once decoded, keeping the first occurrence of each, which is
+// useful for lists merged from several sources:
+//
+//	type Source struct {
+//	    Tags []string `mapstructure:"tags,unique"`
+//	}
+//
+// The elements must be comparable, and it is an error to use the option on
+// a field that isn't a slice.
+//
+// # Versioned Fields
+//
+// A struct can hold the fields of several versions of a format. The field
+// with the ",version" option, which must be an integer, is decoded first,
+// and the fields with the ",minversion=" option followed by a version are
+// only decoded if the decoded version is at least that version:
+//
+//	type Config struct {
+//	    Version int      `mapstructure:"version,version"`
+//	    Host    string   `mapstructure:"host"`
+//	    Hosts   []string `mapstructure:"hosts,minversion=2"`
+//	}
+//
+// The fields of newer versions are left untouched. Their keys are ignored
+// rather than reported as unused, and the fields aren't reported as unset.
+//
+// # Map Entry Defaults
+//
+// A map field with the ",entrydefault=" option followed by a value uses
+// that value for every entry that is null or an empty string in the input.
+// The default is decoded into the element type of the map like any other
+// value, with weak typing enabled since it is always a string:
+//
+//	type Source struct {
+//	    Weights map[string]int `mapstructure:"weights,entrydefault=1"`
+//	}
+//
+// Since tag options are separated by commas, the default can't contain one.
+//
+// # Unmarshalers
+//
+// Types can take over their own decoding by implementing the Unmarshaler
+// interface, which receives the raw input. Types implementing the
+// MergeUnmarshaler interface additionally receive their value before
+// decoding, which allows accumulating values across multiple decodes into
+// the same result, for example when layering configurations:
+//
+//	type Tags []string
+//
+//	func (t *Tags) MergeMapstructure(current, input interface{}) error {
+//	    var tags []string
+//	    if err := mapstructure.Decode(input, &tags); err != nil {
+//	        return err
+//	    }
+//	    *t = append(current.(Tags), tags...)
+//	    return nil
+//	}
+//
+// MergeUnmarshaler takes precedence over Unmarshaler. Both are ignored if
+// DisableUnmarshaler is set.
+//
+// The DecodeHook runs before the Unmarshaler, so the Unmarshaler receives
+// the input as transformed by the hook. A field with the ",rawunmarshal"
+// option bypasses the hook if its type is an Unmarshaler, which then
+// receives the input as is:
+//
+//	type Source struct {
+//	    Tags Tags `mapstructure:"tags,rawunmarshal"`
+//	}
+//
+// # Ordered Maps
+//
+// Go maps don't keep the order of their keys. To preserve it, an input can
+// use a []KV instead of a map, which decodes like a map into maps and
+// structs. A type implementing OrderedMapSetter receives the entries of
+// the input in order:
+//
+//	type Ordered struct {
+//	    Keys   []string
+//	    Values map[string]interface{}
+//	}
+//
+//	func (o *Ordered) SetOrdered(key string, value interface{}) {
+//	    if o.Values == nil {
+//	        o.Values = make(map[string]interface{})
+//	    }
+//	    o.Keys = append(o.Keys, key)
+//	    o.Values[key] = value
+//	}
+//
 // # Omit Empty Values
 //
 // When decoding from a struct to any other value, you may use the
@@ -126,10 +373,22 @@
 //	    Age int `mapstructure:",omitempty"`
 //	}
 //
+// The ",omitzero" suffix omits the value if it is the zero value of its
+// type, or if its IsZero method returns true if it has one, such as for a
+// time.Time. Unlike with ",omitempty", a pointer to a zero value or an
+// empty but non-nil slice or map isn't omitted.
+//
+// # Encoding
+//
+// Encode turns a struct back into a map[string]interface{}, for example to
+// write a configuration out. It honors the same tags as decoding and turns
+// nested structs into maps, including those in slices and maps. Types can
+// control how they are encoded by implementing the Marshaler interface.
+//
 // # Unexported fields
 //
 // Since unexported (private) struct fields cannot be set outside the package
-// where they are defined, the decoder will simply skip them.
+// where they are defined, the decoder will by default simply skip them.
 //
 // For this output type definition:
 //
@@ -152,19 +411,42 @@
 //	    Public: "I made it through!"
 //	}
 //
+// Setting AllowUnexportedFields in the DecoderConfig decodes into unexported
+// fields too, using the unsafe package, as long as they are tagged with a
+// name and belong to an addressable struct, such as the Result:
+//
+//	type Exported struct {
+//	    private string `mapstructure:"private"` // this field will be decoded
+//	    Public string
+//	}
+//
+// An input key that matches an untagged unexported field is then an error
+// rather than being skipped. As this breaks the encapsulation of the types
+// decoded into, it should only be used for types you control.
+//
 // # Other Configuration
 //
 // mapstructure is highly configurable. See the DecoderConfig struct
-// for other features and options that are supported.
+// for other features and options that are supported. A DecoderConfig can
+// also be assembled with NewConfigBuilder, which validates the combination
+// of options.
 package mapstructure
 
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
 
 	"github.com/go-viper/mapstructure/v2/internal/errors"
 )
@@ -173,8 +455,8 @@ import (
 // data transformations. See "DecodeHook" in the DecoderConfig
 // struct.
 //
-// The type must be one of DecodeHookFuncType, DecodeHookFuncKind, or
-// DecodeHookFuncValue.
+// The type must be one of DecodeHookFuncType, DecodeHookFuncKind,
+// DecodeHookFuncValue, DecodeHookFuncReflect or DecodeHookFuncField.
 // Values are a superset of Types (Values can return types), and Types are a
 // superset of Kinds (Types can return Kinds) and are generally a richer thing
 // to use, but Kinds are simpler if you only need those.
@@ -183,6 +465,19 @@ import (
 // we started with Kinds and then realized Types were the better solution,
 // but have a promise to not break backwards compat so we now support
 // both.
+//
+// Types and values are the declared ones, so a hook decoding into a named
+// type such as `type Level int` receives Level rather than int, while
+// Kinds are always the underlying kind, reflect.Int in this case. Hooks
+// that handle every type over a given kind can use UnderlyingKind, which
+// also looks through pointers.
+//
+// Hooks run first for a pointer target, such as *int, and then again for
+// the value it points to, int, so a hook that only converts into T also
+// applies to *T fields. A hook may also return a T for a *T target, which
+// is then decoded into a new T. Hooks that must produce the pointer
+// themselves, for example to compose with hooks expecting one, can be
+// wrapped with PtrizeHookFunc.
 type DecodeHookFunc interface{}
 
 // DecodeHookFuncType is a DecodeHookFunc which has complete information about
@@ -197,6 +492,78 @@ type DecodeHookFuncKind func(reflect.Kind, reflect.Kind, interface{}) (interface
 // values.
 type DecodeHookFuncValue func(from reflect.Value, to reflect.Value) (interface{}, error)
 
+// DecodeHookFuncReflect is a DecodeHookFunc which has complete information
+// about the source and target types and both receives and returns the data
+// as a reflect.Value, avoiding the round-trip through interface{}.
+type DecodeHookFuncReflect func(from reflect.Type, to reflect.Type, data reflect.Value) (reflect.Value, error)
+
+// DecodeHookFuncField is a DecodeHookFunc which has complete access to both
+// the source and target values, and to the struct field the target value is
+// stored in, for example to read its tags. The field is the zero
+// reflect.StructField if the target value isn't a struct field, such as an
+// element of a slice or map.
+type DecodeHookFuncField func(from reflect.Value, to reflect.Value, field reflect.StructField) (interface{}, error)
+
+// Unmarshaler is the interface implemented by types that can decode
+// themselves from the raw input. The input is passed as is, after the
+// DecodeHook was applied.
+type Unmarshaler interface {
+	UnmarshalMapstructure(input interface{}) error
+}
+
+// Marshaler is the interface implemented by types that can encode
+// themselves with Encode. The value returned by MarshalMapstructure is used
+// as is in place of the value implementing it.
+type Marshaler interface {
+	MarshalMapstructure() (interface{}, error)
+}
+
+// MergeUnmarshaler is the interface implemented by types that can decode
+// themselves from the raw input while taking their value before decoding
+// into account, for example to append to a slice instead of replacing it.
+// current is a copy of the value before decoding. If a type implements
+// both MergeUnmarshaler and Unmarshaler, MergeMapstructure takes
+// precedence.
+type MergeUnmarshaler interface {
+	MergeMapstructure(current, input interface{}) error
+}
+
+// FieldSetter is the interface implemented by structs that set the values
+// of their fields themselves when UseSetters is set in the DecoderConfig.
+// SetField receives the Go name of the field and its decoded value, of the
+// type of the field. Set<Field> methods take precedence over it.
+type FieldSetter interface {
+	SetField(name string, value interface{}) error
+}
+
+// KV is an entry of an ordered map. A []KV input is decoded like a map,
+// except into an OrderedMapSetter, which receives the entries in order.
+type KV struct {
+	Key   string
+	Value interface{}
+}
+
+// OrderedMapSetter is the interface implemented by types that keep the
+// order of the keys they are decoded from. SetOrdered is called with the
+// raw value of each entry of the input: in order if the input is a []KV,
+// or sorted by key if it is a map, which has no order.
+type OrderedMapSetter interface {
+	SetOrdered(key string, value interface{})
+}
+
+// SliceMergeMode is the way a slice in the input is combined with a slice
+// that already has elements.
+type SliceMergeMode int
+
+const (
+	// SliceMergeReplace replaces the elements of the slice with the
+	// elements of the input.
+	SliceMergeReplace SliceMergeMode = iota
+
+	// SliceMergeAppend appends the elements of the input to the slice.
+	SliceMergeAppend
+)
+
 // DecoderConfig is the configuration that is used to create a new decoder
 // and allows customization of various aspects of decoding.
 type DecoderConfig struct {
@@ -211,34 +578,198 @@ type DecoderConfig struct {
 	// If an error is returned, the entire decode will fail with that error.
 	DecodeHook DecodeHookFunc
 
+	// TypedHooks are decode hooks that only run when decoding into the
+	// type they are registered for, before the DecodeHook. The decoder
+	// looks them up by the target type, which is cheaper than running a
+	// long chain of hooks that each check the target type themselves.
+	// Registering a hook here is equivalent to composing it with TypedHook
+	// at the start of the DecodeHook.
+	TypedHooks map[reflect.Type]DecodeHookFunc
+
+	// NamedHooks are decode hooks that fields request by name with the
+	// ",hook=" tag option. A named hook only runs for the value of the
+	// fields that request it, before the DecodeHook. NewDecoder fails if
+	// a field of the Result requests a hook that isn't registered here.
+	NamedHooks map[string]DecodeHookFunc
+
+	// DecodeHooksIntoInterfaces, if set to true, lets the DecodeHook pick
+	// the type of the values decoded into empty interfaces, such as
+	// interface{} fields, which otherwise hold the input as is since most
+	// hooks only convert values for specific target types. For each of
+	// InterfaceHookTypes in order, the DecodeHook is called with a target
+	// of that type, and the first result of that type is decoded into the
+	// interface. Results of other types and errors are ignored. If there
+	// is no such result, the DecodeHook is called as usual.
+	//
+	// This changes what is stored in interface fields, so it is off by
+	// default. For example, with StringToTimeHookFunc and time.Time among
+	// InterfaceHookTypes, every string holding a time in the layout of the
+	// hook is decoded as a time.Time.
+	DecodeHooksIntoInterfaces bool
+
+	// InterfaceHookTypes are the types tried as targets of the DecodeHook
+	// when decoding into an empty interface, if DecodeHooksIntoInterfaces
+	// is set.
+	InterfaceHookTypes []reflect.Type
+
+	// DisableUnmarshaler, if set to true, decodes types implementing
+	// Unmarshaler or MergeUnmarshaler like any other type instead of
+	// calling their methods.
+	DisableUnmarshaler bool
+
+	// AllowUnexportedFields, if set to true, decodes into unexported struct
+	// fields, which are otherwise skipped. This is UNSAFE: it relies on the
+	// unsafe package to get around the restrictions of the reflect package
+	// and breaks the encapsulation of the types decoded into, so it should
+	// only be used for types you control. Only fields of an addressable
+	// struct, such as one reached through the Result pointer, are decoded.
+	// To avoid filling fields by accident, unexported fields must have a
+	// name set with a tag: it is an error for a key of the input to match
+	// an unexported field without one.
+	AllowUnexportedFields bool
+
+	// RecoverFromPanics, if set to true, recovers from panics in the
+	// DecodeHook, the FlattenHook and in Unmarshaler and MergeUnmarshaler
+	// implementations, and returns them as a *PanicError holding the name
+	// of the value being decoded and the value passed to panic. This is
+	// useful when the hooks or types come from code that isn't trusted.
+	RecoverFromPanics bool
+
 	// If ErrorUnused is true, then it is an error for there to exist
 	// keys in the original map that were unused in the decoding process
 	// (extra keys).
 	ErrorUnused bool
 
+	// OnUnused, if set, is called with the path and the value of each key
+	// of the input that wasn't decoded into a struct field, in the order
+	// of the keys, such as to log deprecation warnings. Returning an error
+	// makes decoding fail with that error, joined with the other errors of
+	// decoding, and OnUnused isn't called for the remaining keys of the
+	// struct. It is called before ErrorUnused is checked, and the keys are
+	// still recorded in Metadata.Unused.
+	OnUnused func(path string, value interface{}) error
+
+	// Trace, if set, is called with an event for each step of decoding,
+	// such as entering a struct field or assigning a value, which helps
+	// understanding how a complex input is decoded. It is meant for
+	// debugging and has no cost when nil.
+	Trace func(event TraceEvent)
+
 	// If ErrorUnset is true, then it is an error for there to exist
 	// fields in the result that were not set in the decoding process
 	// (extra fields). This only applies to decoding to a struct. This
-	// will affect all nested structs as well.
+	// will affect all nested structs as well. A field with the ",remain"
+	// option is never unset: it still captures the keys that match no
+	// other field, while the other fields are checked as usual.
 	ErrorUnset bool
 
+	// OnUnset, if set, is called with the path of each struct field that
+	// no key of the input matched, in the order of the paths, such as to
+	// require a field only when another one is set. The paths are those
+	// recorded in Metadata.Unset, such as "Server.Port". Returning an
+	// error makes decoding fail with that error, joined with the other
+	// errors of decoding, and OnUnset isn't called for the remaining fields
+	// of the struct. It is called before ErrorUnset is checked.
+	OnUnset func(path string) error
+
+	// DetectDuplicates, if set to true, records the keys of the input that
+	// matched more than one struct field, for example because of case
+	// insensitive matching or a field of a squashed struct with the same
+	// name as a field of the parent struct, in the Duplicates field of
+	// Metadata. Each of those fields is decoded from the key.
+	DetectDuplicates bool
+
+	// If ErrorDuplicates is true, then it is an error for a key of the input
+	// to match more than one struct field.
+	ErrorDuplicates bool
+
+	// SliceMergeMode controls how a slice in the input is decoded into a
+	// slice that already has elements, such as when decoding multiple inputs
+	// with DecodeAll. By default the slice is replaced. Struct fields with
+	// the ",append" option use SliceMergeAppend regardless, for example to
+	// accumulate values across several calls to Decode:
+	//
+	//	type Config struct {
+	//	    Plugins []string `mapstructure:"plugins,append"`
+	//	}
+	//
+	// ZeroFields takes precedence: with it, slices are cleared before
+	// decoding, so nothing is appended.
+	SliceMergeMode SliceMergeMode
+
+	// DecodeNil, if set to true, will set pointer, slice, map and interface
+	// targets to nil when the input is nil, including typed nil pointers,
+	// slices and maps. Typed nil slices and maps are then handled like a nil
+	// input for targets of any type. By default these targets are left
+	// untouched, unless ZeroFields is set.
+	DecodeNil bool
+
 	// ZeroFields, if set to true, will zero fields before writing them.
 	// For example, a map will be emptied before decoded values are put in
-	// it. If this is false, a map will be merged.
+	// it. If this is false, a map will be merged. Struct fields with the
+	// ",zero" or ",nozero" option are decoded as if ZeroFields was set or
+	// not, respectively, regardless of this setting. Types implementing
+	// Unmarshaler or MergeUnmarshaler aren't zeroed before decoding input
+	// that isn't nil, so a MergeUnmarshaler always receives its current
+	// value.
 	ZeroFields bool
 
+	// MapValueTemplate maps the value types of maps, such as *Inner for a
+	// map[string]*Inner, to factories for new values of these maps. When
+	// a map entry is decoded, its value is created by calling the factory
+	// and the input is then decoded into it, which allows entries to start
+	// with defaults. Entries merged into existing ones by DecodeAll keep
+	// their value instead. The factory is called for each entry and must
+	// return a new value of the type, not one shared between entries.
+	// Templates are not used if ZeroFields is set, as values are then
+	// zeroed before decoding.
+	MapValueTemplate map[reflect.Type]func() interface{}
+
+	// InternStrings, if set to true, makes strings decoded into string
+	// targets with the same value share their storage, which saves memory
+	// when decoding large inputs with many repeated values, such as the
+	// same status in thousands of records. The cache only lives for the
+	// duration of a single Decode.
+	InternStrings bool
+
+	// TrimStrings, if set to true, removes the leading and trailing white
+	// space of the strings decoded into string targets, after the
+	// DecodeHook ran, wherever they are nested. Map keys are left as is,
+	// as are the fields with the ",notrim" tag option.
+	TrimStrings bool
+
+	// UseSetters, if set to true, makes the decoder call the setters of
+	// the structs it decodes into instead of setting their fields, so that
+	// the setters can validate the values. The setter of a field Name is
+	// the SetName method with a single parameter, which may return an
+	// error, or else the SetField method of a struct implementing
+	// FieldSetter. The value passed to the setter is decoded as usual,
+	// into the type of the parameter, and fields with a setter may be
+	// unexported.
+	UseSetters bool
+
 	// If WeaklyTypedInput is true, the decoder will make the following
-	// "weak" conversions:
+	// "weak" conversions between bools, numbers and strings, in addition
+	// to the conversions between int, uint and float that are always made:
 	//
-	//   - bools to string (true = "1", false = "0")
-	//   - numbers to string (base 10)
-	//   - bools to int/uint (true = 1, false = 0)
-	//   - strings to int/uint (base implied by prefix)
-	//   - int to bool (true if value != 0)
+	//   - bools to int/uint/float (true = 1, false = 0)
+	//   - bools to string (true = "1", false = "0", or "true" and "false"
+	//     with WeaklyTypedBoolWords)
+	//   - int/uint/float to bool (true if value != 0)
+	//   - int/uint to string (base 10)
+	//   - float to string (shortest representation, never an exponent)
 	//   - string to bool (accepts: 1, t, T, TRUE, true, True, 0, f, F,
-	//     FALSE, false, False. Anything else is an error)
+	//     FALSE, false, False and the empty string for false. Anything
+	//     else is an error)
+	//   - string to int/uint (base implied by prefix, "" = 0)
+	//   - string to float ("" = 0)
+	//
+	// and the following conversions between other kinds:
+	//
+	//   - byte slices and arrays to string
 	//   - empty array = empty map and vice versa
-	//   - negative numbers to overflowed uint values (base 10)
+	//   - negative numbers to overflowed uint values (base 10), except
+	//     for the elements of a byte slice, which must be within 0-255
 	//   - slice of maps to a merged map
 	//   - single values are converted to slices if required. Each
 	//     element is weakly decoded. For example: "4" can become []int{4}
@@ -246,6 +777,49 @@ type DecoderConfig struct {
 	//
 	WeaklyTypedInput bool
 
+	// WeaklyTypedBoolWords, if set to true, makes WeaklyTypedInput decode
+	// bools into strings as "true" and "false" rather than "1" and "0",
+	// which round-trips through the weak string to bool conversion as
+	// words.
+	WeaklyTypedBoolWords bool
+
+	// Strict, if set to true, is a shorthand for decoding strictly or not
+	// at all. NewDecoder then sets ErrorUnused and ErrorUnset to true and
+	// WeaklyTypedInput to false. In addition, numeric values must fit the
+	// type they are decoded into: it is an error for them to overflow it,
+	// for negative values to be decoded into unsigned types and for floats
+	// with a fractional part to be decoded into integer types.
+	Strict bool
+
+	// ErrorOnFloatTruncation, if set to true, makes it an error to decode
+	// a float with a fractional part, such as 3.7, into an integer type,
+	// regardless of WeaklyTypedInput. Otherwise the fractional part is
+	// dropped. Floats without a fractional part, such as 3.0, are decoded
+	// either way.
+	ErrorOnFloatTruncation bool
+
+	// ErrorOnHeterogeneousSlice, if set to true, makes it an error for the
+	// elements of an input slice decoded into a slice to have different
+	// kinds, such as [1, "two", 3], which WeaklyTypedInput would otherwise
+	// convert element by element. Every element whose kind differs from
+	// the kind of the element type of the target is reported with its
+	// index and type. Inputs whose elements all have the same kind, such as
+	// ["1", "2"], are converted as usual.
+	ErrorOnHeterogeneousSlice bool
+
+	// TrackCoercions, if set to true, records every implicit type
+	// conversion made while decoding, such as those enabled by
+	// WeaklyTypedInput, in the Coercions field of Metadata. It has no
+	// effect if Metadata is nil.
+	TrackCoercions bool
+
+	// TrackChanges, if set to true, records the struct fields whose value
+	// differs after decoding from the value they held before, as compared
+	// by reflect.DeepEqual, in the Changed field of Metadata. This is meant
+	// for decoding a new configuration into the current one to find out
+	// what to reload. It has no effect if Metadata is nil.
+	TrackChanges bool
+
 	// Squash will squash embedded structs.  A squash tag may also be
 	// added to an individual struct field using a tag.  For example:
 	//
@@ -254,26 +828,123 @@ type DecoderConfig struct {
 	//  }
 	Squash bool
 
+	// SlicePositional, if set to true, decodes a slice or an array into a
+	// struct with no ",pos=" options by decoding its elements into the
+	// fields in the order they are declared. See the package documentation
+	// for details.
+	SlicePositional bool
+
 	// Metadata is the struct that will contain extra metadata about
 	// the decoding. If this is nil, then no metadata will be tracked.
 	Metadata *Metadata
 
 	// Result is a pointer to the struct that will contain the decoded
-	// value.
+	// value. It may be nil if the decoder is only used with DecodeValue.
 	Result interface{}
 
 	// The tag name that mapstructure reads for field names. This
 	// defaults to "mapstructure"
 	TagName string
 
+	// FallbackTagNames are the tag names read, in order, for the fields
+	// that don't have a TagName tag, such as "json" to decode into structs
+	// that are already tagged for encoding/json without tagging them again.
+	// Fallback tags are read like a TagName tag, including their "-" name
+	// and options such as ",omitempty".
+	FallbackTagNames []string
+
 	// IgnoreUntaggedFields ignores all struct fields without explicit
 	// TagName, comparable to `mapstructure:"-"` as default behaviour.
 	IgnoreUntaggedFields bool
 
+	// OnlyIncludedFields ignores all struct fields unless their tag has
+	// the ",include" option, turning the set of decoded fields into a
+	// whitelist. Embedded structs with the ",squash" option and fields
+	// with the ",remain" option are always processed; the fields within a
+	// squashed struct are checked individually.
+	OnlyIncludedFields bool
+
 	// MatchName is the function used to match the map key to the struct
 	// field name or tag. Defaults to `strings.EqualFold`. This can be used
 	// to implement case-sensitive tag values, support snake casing, etc.
 	MatchName func(mapKey, fieldName string) bool
+
+	// MatchNameWithPath, if set, is used instead of MatchName and is also
+	// given the path of the struct the field belongs to, as used in error
+	// messages, such as "Server.TLS" or "" for the root. This allows
+	// matching keys differently in different parts of the structure.
+	MatchNameWithPath func(path, mapKey, fieldName string) bool
+
+	// KeyCaseTransform, if set, converts the keys of the input to a case
+	// such as SnakeCase to match them with the struct fields that no key
+	// matches as is. Field names are converted to the same case, but
+	// names set with a tag are used as written: tags win. With SnakeCase,
+	// the key "maxConnections" thus matches both a MaxConnections field
+	// and a field tagged "max_connections", but not one tagged
+	// "maxConnections" through the key "max_connections". The converted
+	// names are still compared with MatchName or MatchNameWithPath, so
+	// they match case insensitively by default.
+	KeyCaseTransform KeyCaseTransform
+
+	// EncodeFieldName is the function used to turn a struct field into a
+	// map key when decoding from a struct, either into a map or into the
+	// intermediary map used for struct to struct decoding. It is the
+	// inverse of MatchName. Field names set with a tag are used as is.
+	// Defaults to using the field name.
+	EncodeFieldName func(field reflect.StructField) string
+
+	// FlattenHook, if set, is called with the intermediary map a struct is
+	// turned into when decoding it into another struct, before the map is
+	// decoded into the target struct. The returned map is decoded instead,
+	// which allows renaming, adding or removing keys. It is called for each
+	// struct decoded from a struct, including nested ones.
+	FlattenHook func(map[string]interface{}) (map[string]interface{}, error)
+
+	// TimeLayout is the layout a time.Time is formatted with when a struct
+	// with a time.Time field is decoded into a struct with a string field.
+	// Times are only decoded into strings this way when flattening structs.
+	// Defaults to time.RFC3339.
+	TimeLayout string
+
+	// BreakCycles, if set to true, replaces a pointer that refers back to a
+	// struct that's already being decoded into a map with a nil value.
+	// Otherwise such a cycle is an error.
+	BreakCycles bool
+
+	// MaxDepth, if greater than zero, is the maximum number of maps,
+	// slices, arrays and structs that can be nested in the result, the
+	// result itself included. Decoding deeper input is an error naming the
+	// path where the limit was exceeded. This protects against stack
+	// exhaustion on untrusted input. Defaults to 0, which is unlimited.
+	MaxDepth int
+
+	// MaxSliceLen, if greater than zero, is the maximum number of elements
+	// of the slices and maps decoded from slices and maps of the input.
+	// Larger inputs are an error, returned before memory is allocated for
+	// them. Struct fields with the ",maxlen=" option followed by a number
+	// use that limit instead for the field and the values nested in it:
+	//
+	//	type Source struct {
+	//	    Hosts []string `mapstructure:"hosts,maxlen=100"`
+	//	}
+	//
+	// Defaults to 0, which is unlimited.
+	MaxSliceLen int
+
+	// SubConfigs are named configurations that struct fields can be decoded
+	// with instead of this one by using the ",config=" option followed by
+	// the name, for example `mapstructure:"legacy,config=weak"`. This allows
+	// decoding parts of a struct with other options or decode hooks. The
+	// Result of these configurations is ignored and the Metadata of this
+	// configuration is used instead.
+	SubConfigs map[string]*DecoderConfig
+
+	// StripFieldPrefix, if set, is removed from the start of struct field
+	// names before they are matched against map keys, so that for example
+	// the key "port" matches the field HTTPPort if StripFieldPrefix is
+	// "HTTP". Field names set with a tag are never stripped. It is an error
+	// for a stripped field name to collide with the name of another field.
+	StripFieldPrefix string
 }
 
 // A Decoder takes a raw interface value and turns it into structured
@@ -282,14 +953,72 @@ type DecoderConfig struct {
 // more finely control how the Decoder behaves using the DecoderConfig
 // structure. The top-level Decode method is just a convenience that sets
 // up the most basic Decoder.
+//
+// A Decoder is safe for concurrent use: decoding keeps no state in it. The
+// Result and Metadata of its configuration are shared, though, so
+// goroutines sharing a Decoder should decode into their own targets with
+// DecodeValue and leave Metadata unset.
 type Decoder struct {
 	config *DecoderConfig
+
+	// merge is set by DecodeAll to merge maps deeply instead of replacing
+	// their values.
+	merge bool
+
+	// encode is set by Encode to encode the values of struct fields with
+	// encodeValue when decoding structs into maps.
+	encode bool
+
+	// hooks holds the hooks of TypedHooks composed with the DecodeHook, by
+	// target type.
+	hooks map[reflect.Type]DecodeHookFunc
+
+	// subDecoders holds the decoders of the SubConfigs, by name.
+	subDecoders map[string]*Decoder
+}
+
+// decoder is a Decoder in the middle of a single call to Decode. The
+// Decoder itself is never modified once created, so that it can be shared
+// between goroutines, while the state of the call is kept here.
+type decoder struct {
+	*Decoder
+
+	state *decodeState
+
+	// fromStruct is set while decoding the values of a struct flattened
+	// from another struct, where time.Time values are formatted when
+	// decoded into strings.
+	fromStruct bool
+}
+
+// decodeState is the state of a single call to Decode, shared by the
+// decoders derived for struct fields along the way.
+type decodeState struct {
+	// visiting holds the pointers to structs that are being decoded into
+	// maps, to detect cycles.
+	visiting map[visitedPtr]struct{}
+
+	// depth is the number of maps, slices, arrays and structs being
+	// decoded into, checked against MaxDepth.
+	depth int
+
+	// interned holds the strings decoded so far if InternStrings is set.
+	interned map[string]string
+}
+
+// visitedPtr identifies a pointer for cycle detection. The type is part
+// of the identity because a struct and its first field share an address.
+type visitedPtr struct {
+	ptr uintptr
+	typ reflect.Type
 }
 
 // Metadata contains information about decoding a structure that
 // is tedious or difficult to get otherwise.
 type Metadata struct {
-	// Keys are the keys of the structure which were successfully decoded
+	// Keys are the keys of the structure which were successfully decoded.
+	// Struct fields and map entries are joined with dots, as in
+	// "Services.web.Port", and slice elements are indexed, as in "Hosts[0]".
 	Keys []string
 
 	// Unused is a slice of keys that were found in the raw value but
@@ -300,6 +1029,88 @@ type Metadata struct {
 	// but weren't set in the decoding process since there was no matching value
 	// in the input
 	Unset []string
+
+	// Coercions is a slice of the implicit type conversions that were made
+	// in the decoding process. It is only populated if TrackCoercions is set
+	// in the DecoderConfig.
+	Coercions []Coercion
+
+	// Duplicates is a slice of keys in the input that matched more than one
+	// field of a struct. It is only populated if DetectDuplicates is set in
+	// the DecoderConfig.
+	Duplicates []string
+
+	// Changed is a slice of the fields whose value was changed by decoding.
+	// The structs decoded from a map aren't listed themselves, their changed
+	// fields are listed instead. It is only populated if TrackChanges is set
+	// in the DecoderConfig.
+	Changed []string
+
+	// Hooks maps the keys of the structure which were decoded from the
+	// result of a hook created with OrComposeNamedDecodeHookFunc to the
+	// name of the hook that produced it. It is only populated if that hook
+	// is the DecodeHook of the DecoderConfig.
+	Hooks map[string]string
+}
+
+// Coercion describes an implicit type conversion made while decoding.
+type Coercion struct {
+	// Path is the name of the value that was converted.
+	Path string
+
+	// From and To are the kinds of the input and the result.
+	From, To reflect.Kind
+}
+
+// TraceEventKind is the kind of step of decoding a TraceEvent describes.
+type TraceEventKind int
+
+const (
+	// TraceEnterField is emitted before decoding the value of a struct
+	// field, with the raw input of the field.
+	TraceEnterField TraceEventKind = iota
+
+	// TraceHookApplied is emitted after the DecodeHook processed a value,
+	// with the value it returned.
+	TraceHookApplied
+
+	// TraceAssigned is emitted after decoding a value that isn't a struct,
+	// map, slice, array or pointer, with the decoded value.
+	TraceAssigned
+
+	// TraceUnusedKey is emitted for each key of a map that wasn't decoded
+	// into a struct field, with its value.
+	TraceUnusedKey
+)
+
+// String returns a description of the kind of event.
+func (k TraceEventKind) String() string {
+	switch k {
+	case TraceEnterField:
+		return "enter field"
+	case TraceHookApplied:
+		return "hook applied"
+	case TraceAssigned:
+		return "assigned value"
+	case TraceUnusedKey:
+		return "unused key"
+	default:
+		return "TraceEventKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// TraceEvent describes a step of decoding, passed to the Trace function of
+// the DecoderConfig.
+type TraceEvent struct {
+	// Kind is the kind of step.
+	Kind TraceEventKind
+
+	// Path is the name of the value the step applies to, such as
+	// "Server.Port" or "Hosts[0]".
+	Path string
+
+	// Value is the value of the step, as described by its kind.
+	Value interface{}
 }
 
 // Decode takes an input structure and uses reflection to translate it to
@@ -374,13 +1185,15 @@ func WeakDecodeMetadata(input interface{}, output interface{}, metadata *Metadat
 // again.
 func NewDecoder(config *DecoderConfig) (*Decoder, error) {
 	val := reflect.ValueOf(config.Result)
-	if val.Kind() != reflect.Ptr {
-		return nil, errors.New("result must be a pointer")
-	}
+	if config.Result != nil {
+		if val.Kind() != reflect.Ptr {
+			return nil, errors.New("result must be a pointer")
+		}
 
-	val = val.Elem()
-	if !val.CanAddr() {
-		return nil, errors.New("result must be addressable (a pointer)")
+		val = val.Elem()
+		if !val.CanAddr() {
+			return nil, errors.New("result must be addressable (a pointer)")
+		}
 	}
 
 	if config.Metadata != nil {
@@ -395,60 +1208,227 @@ func NewDecoder(config *DecoderConfig) (*Decoder, error) {
 		if config.Metadata.Unset == nil {
 			config.Metadata.Unset = make([]string, 0)
 		}
-	}
 
-	if config.TagName == "" {
-		config.TagName = "mapstructure"
+		if config.TrackCoercions && config.Metadata.Coercions == nil {
+			config.Metadata.Coercions = make([]Coercion, 0)
+		}
+
+		if config.DetectDuplicates && config.Metadata.Duplicates == nil {
+			config.Metadata.Duplicates = make([]string, 0)
+		}
+
+		if config.TrackChanges && config.Metadata.Changed == nil {
+			config.Metadata.Changed = make([]string, 0)
+		}
 	}
 
-	if config.MatchName == nil {
-		config.MatchName = strings.EqualFold
+	setConfigDefaults(config)
+
+	if config.Result != nil {
+		if err := checkPrimaryFields(val.Type(), config, map[reflect.Type]struct{}{}); err != nil {
+			return nil, err
+		}
+		if err := checkNamedHooks(val.Type(), config, map[reflect.Type]struct{}{}); err != nil {
+			return nil, err
+		}
 	}
 
 	result := &Decoder{
-		config: config,
+		config:      config,
+		hooks:       typedHooks(config),
+		subDecoders: newSubDecoders(config.SubConfigs, config.Metadata, map[uintptr]map[string]*Decoder{}),
 	}
 
 	return result, nil
 }
 
+// newSubDecoders returns the decoders of the given SubConfigs, which use
+// metadata instead of their own Metadata. Configurations without
+// SubConfigs of their own use configs. built holds the decoders already
+// returned for each map of SubConfigs, so that configurations referring
+// to each other are only built once.
+func newSubDecoders(configs map[string]*DecoderConfig, metadata *Metadata, built map[uintptr]map[string]*Decoder) map[string]*Decoder {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	key := reflect.ValueOf(configs).Pointer()
+	if decoders, ok := built[key]; ok {
+		return decoders
+	}
+
+	decoders := make(map[string]*Decoder, len(configs))
+	built[key] = decoders
+	for name, sub := range configs {
+		config := *sub
+		config.Result = nil
+		config.Metadata = metadata
+		if config.SubConfigs == nil {
+			config.SubConfigs = configs
+		}
+
+		setConfigDefaults(&config)
+
+		decoders[name] = &Decoder{
+			config:      &config,
+			hooks:       typedHooks(&config),
+			subDecoders: newSubDecoders(config.SubConfigs, metadata, built),
+		}
+	}
+
+	return decoders
+}
+
+// typedHooks returns the hooks of the TypedHooks of config, each composed
+// with the DecodeHook so that it runs first.
+func typedHooks(config *DecoderConfig) map[reflect.Type]DecodeHookFunc {
+	if len(config.TypedHooks) == 0 {
+		return nil
+	}
+
+	hooks := make(map[reflect.Type]DecodeHookFunc, len(config.TypedHooks))
+	for typ, hook := range config.TypedHooks {
+		if config.DecodeHook != nil {
+			hook = ComposeDecodeHookFunc(hook, config.DecodeHook)
+		}
+		hooks[typ] = hook
+	}
+	return hooks
+}
+
+// setConfigDefaults fills in the defaults of the options of the given
+// configuration that aren't set.
+func setConfigDefaults(config *DecoderConfig) {
+	if config.Strict {
+		config.ErrorUnused = true
+		config.ErrorUnset = true
+		config.WeaklyTypedInput = false
+	}
+
+	if config.TagName == "" {
+		config.TagName = "mapstructure"
+	}
+
+	if config.MatchName == nil {
+		config.MatchName = strings.EqualFold
+	}
+}
+
 // Decode decodes the given raw interface to the target pointer specified
 // by the configuration.
 func (d *Decoder) Decode(input interface{}) error {
-	err := d.decode("", input, reflect.ValueOf(d.config.Result).Elem())
+	if d.config.Result == nil {
+		return errors.New("result must be a pointer")
+	}
+
+	return d.decodeRoot(input, reflect.ValueOf(d.config.Result).Elem())
+}
+
+// DecodeValue decodes the given raw interface into target instead of the
+// Result of the configuration, which may then be nil. This allows
+// libraries that manage their own reflection to decode into values such
+// as struct fields or map elements. The target must be settable.
+func (d *Decoder) DecodeValue(target reflect.Value, input interface{}) error {
+	if !target.IsValid() {
+		return errors.New("target must be a valid value")
+	}
+	if !target.CanSet() {
+		return fmt.Errorf("target of type '%s' must be settable", target.Type())
+	}
+
+	if err := checkPrimaryFields(target.Type(), d.config, map[reflect.Type]struct{}{}); err != nil {
+		return err
+	}
+	if err := checkNamedHooks(target.Type(), d.config, map[reflect.Type]struct{}{}); err != nil {
+		return err
+	}
+
+	return d.decodeRoot(input, target)
+}
+
+// decodeRoot decodes the input into the root value of a decoding.
+func (d *Decoder) decodeRoot(input interface{}, outVal reflect.Value) error {
+	err := (&decoder{Decoder: d, state: &decodeState{}}).decode("", input, outVal)
+	if err == nil {
+		return nil
+	}
 
-	// Retain some of the original behavior when multiple errors ocurr
+	// Name the type of the result when aggregating errors, so that errors
+	// coming from deep within a library still say what was being decoded.
 	var joinedErr interface{ Unwrap() []error }
 	if errors.As(err, &joinedErr) {
-		return fmt.Errorf("decoding failed due to the following error(s):\n\n%w", err)
+		return fmt.Errorf("decoding into '%s' failed due to the following error(s):\n\n%w", outVal.Type(), err)
 	}
 
 	return err
 }
 
+// DecodeAll decodes each of the inputs into the result in order, so that
+// values of later inputs override values of earlier ones. This allows
+// layering configurations, for example defaults, a file and the
+// environment. Unlike with consecutive calls to Decode, maps are merged
+// deeply: a map in an input is merged into the map already in the result
+// at the same key, at any level. Slices are replaced unless SliceMergeMode
+// is SliceMergeAppend. Structs are merged field by field as with Decode.
+// The inputs themselves are never modified.
+func (d *Decoder) DecodeAll(inputs ...interface{}) error {
+	merging := *d
+	merging.merge = true
+
+	for _, input := range inputs {
+		if err := merging.Decode(input); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeNil handles a nil input. If the data is nil, then we don't set
+// anything, unless ZeroFields is set to true, or DecodeNil is set and the
+// target can be nil.
+func (d *decoder) decodeNil(name string, outVal reflect.Value) {
+	if d.config.ZeroFields || d.config.DecodeNil && isNillable(outVal) {
+		outVal.Set(reflect.Zero(outVal.Type()))
+
+		if d.config.Metadata != nil && name != "" {
+			d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
+		}
+	}
+}
+
 // Decodes an unknown data type into a specific reflection value.
-func (d *Decoder) decode(name string, input interface{}, outVal reflect.Value) error {
+func (d *decoder) decode(name string, input interface{}, outVal reflect.Value) error {
+	return d.decodeField(name, input, outVal, reflect.StructField{}, nil, 0)
+}
+
+// decodeField is like decode, but for the value of the struct field field,
+// which is passed to DecodeHookFuncField hooks. The fieldHook, if not nil,
+// runs before the DecodeHook for the value of the field only. It fails if
+// the hooks take longer than hookTimeout to process the input. A zero
+// hookTimeout means there is no limit.
+func (d *decoder) decodeField(name string, input interface{}, outVal reflect.Value, field reflect.StructField, fieldHook DecodeHookFunc, hookTimeout time.Duration) error {
 	var inputVal reflect.Value
 	if input != nil {
 		inputVal = reflect.ValueOf(input)
 
 		// We need to check here if input is a typed nil. Typed nils won't
-		// match the "input == nil" below so we check that here.
-		if inputVal.Kind() == reflect.Ptr && inputVal.IsNil() {
-			input = nil
+		// match the "input == nil" below so we check that here. Typed nil
+		// maps and slices only count as nil when DecodeNil is set.
+		switch inputVal.Kind() {
+		case reflect.Ptr:
+			if inputVal.IsNil() {
+				input = nil
+			}
+		case reflect.Map, reflect.Slice:
+			if d.config.DecodeNil && inputVal.IsNil() {
+				input = nil
+			}
 		}
 	}
 
 	if input == nil {
-		// If the data is nil, then we don't set anything, unless ZeroFields is set
-		// to true.
-		if d.config.ZeroFields {
-			outVal.Set(reflect.Zero(outVal.Type()))
-
-			if d.config.Metadata != nil && name != "" {
-				d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
-			}
-		}
+		d.decodeNil(name, outVal)
 		return nil
 	}
 
@@ -462,18 +1442,112 @@ func (d *Decoder) decode(name string, input interface{}, outVal reflect.Value) e
 		return nil
 	}
 
-	if d.config.DecodeHook != nil {
-		// We have a DecodeHook, so let's pre-process the input.
-		var err error
-		input, err = DecodeHookExec(d.config.DecodeHook, inputVal, outVal)
-		if err != nil {
-			return fmt.Errorf("error decoding '%s': %w", name, err)
+	hook := d.config.DecodeHook
+	if typed, ok := d.hooks[outVal.Type()]; ok {
+		hook = typed
+	}
+	if fieldHook != nil {
+		if hook != nil {
+			hook = ComposeDecodeHookFunc(fieldHook, hook)
+		} else {
+			hook = fieldHook
 		}
 	}
+	if hook != nil {
+		// We have a DecodeHook, so let's pre-process the input.
+		// hookName is only read once exec has returned without an error,
+		// so it is safe to set from the goroutine of a hook timeout.
+		// The result is kept as a reflect.Value, so that values returned by
+		// a DecodeHookFuncReflect are decoded without being copied into an
+		// interface{} first.
+		var hookName string
+		from := inputVal
+		exec := func(to reflect.Value) (data reflect.Value, err error) {
+			defer d.recoverPanic(name, &err)
+			if f, ok := hook.(namedDecodeHookFunc); ok {
+				var raw interface{}
+				raw, hookName, err = f(from, to, field)
+				return reflect.ValueOf(raw), err
+			}
+			return decodeHookExecValue(hook, from, to, field)
+		}
+
+		var err error
+		converted := false
+		if d.config.DecodeHooksIntoInterfaces && outVal.Kind() == reflect.Interface && outVal.NumMethod() == 0 {
+			inputVal, converted = d.execDecodeHookIntoInterface(exec, from)
+		}
+		switch {
+		case converted:
+			// The hook already picked the type of the value.
+		case hookTimeout > 0:
+			inputVal, err = execDecodeHookWithTimeout(exec, outVal, hookTimeout)
+		default:
+			inputVal, err = exec(outVal)
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding '%s': %w", name, err)
+		}
+		if inputVal.Kind() == reflect.Interface {
+			inputVal = inputVal.Elem()
+		}
+
+		if d.config.Trace != nil {
+			d.trace(TraceHookApplied, name, valueInterface(inputVal))
+		}
+
+		if hookName != "" && d.config.Metadata != nil && name != "" {
+			if d.config.Metadata.Hooks == nil {
+				d.config.Metadata.Hooks = make(map[string]string)
+			}
+			d.config.Metadata.Hooks[name] = hookName
+		}
+	}
+
+	if inputVal.IsValid() && inputVal.Type() == reflect.TypeOf(nullValue{}) {
+		// A hook such as StringNullHookFunc turned the input into a nil.
+		d.decodeNil(name, outVal)
+		return nil
+	}
+
+	if inputVal.IsValid() && inputVal.Type() == reflect.TypeOf(discriminatedValue{}) {
+		// DiscriminatorHookFunc chose the type of the value.
+		return d.decodeDiscriminated(name, inputVal.Interface().(discriminatedValue), outVal)
+	}
+
+	if !d.config.DisableUnmarshaler {
+		if ok, err := d.decodeUnmarshaler(name, inputVal, outVal); ok {
+			return err
+		}
+	}
+
+	if ok, err := d.decodeOrderedMap(name, inputVal, outVal); ok {
+		return err
+	}
 
 	var err error
 	outputKind := getKind(outVal)
 	addMetaKey := true
+
+	// Ordered entries decode like a map into maps and structs.
+	if inputVal.IsValid() && inputVal.Type() == reflect.TypeOf([]KV(nil)) && (outputKind == reflect.Map || outputKind == reflect.Struct) {
+		input = kvsToMap(inputVal.Interface().([]KV))
+		inputVal = reflect.ValueOf(input)
+	} else if hook != nil && outputKind != reflect.Struct && outputKind != reflect.Array {
+		// Structs and arrays are decoded from inputVal directly, the
+		// other kinds from the value it holds.
+		input = valueInterface(inputVal)
+	}
+	if d.config.MaxDepth > 0 {
+		switch outputKind {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			if d.state.depth >= d.config.MaxDepth {
+				return fmt.Errorf("'%s' exceeds the maximum depth of %d", name, d.config.MaxDepth)
+			}
+			d.state.depth++
+			defer func() { d.state.depth-- }()
+		}
+	}
 	switch outputKind {
 	case reflect.Bool:
 		err = d.decodeBool(name, input, outVal)
@@ -490,7 +1564,7 @@ func (d *Decoder) decode(name string, input interface{}, outVal reflect.Value) e
 	case reflect.Complex64:
 		err = d.decodeComplex(name, input, outVal)
 	case reflect.Struct:
-		err = d.decodeStruct(name, input, outVal)
+		err = d.decodeStruct(name, inputVal, outVal)
 	case reflect.Map:
 		err = d.decodeMap(name, input, outVal)
 	case reflect.Ptr:
@@ -498,14 +1572,28 @@ func (d *Decoder) decode(name string, input interface{}, outVal reflect.Value) e
 	case reflect.Slice:
 		err = d.decodeSlice(name, input, outVal)
 	case reflect.Array:
-		err = d.decodeArray(name, input, outVal)
+		err = d.decodeArray(name, inputVal, outVal)
 	case reflect.Func:
 		err = d.decodeFunc(name, input, outVal)
+	case reflect.Chan, reflect.UnsafePointer:
+		// These kinds can never be produced from decoded data, so fail
+		// with a clear error rather than a confusing reflect one.
+		err = fmt.Errorf(
+			"'%s' has unsupported kind '%s', use the tag `%s:\"-\"` to skip it",
+			name, outputKind, d.config.TagName)
 	default:
 		// If we reached this point then we weren't able to decode it
 		return fmt.Errorf("%s: unsupported type: %s", name, outputKind)
 	}
 
+	if d.config.Trace != nil && err == nil && outVal.CanInterface() {
+		switch outputKind {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		default:
+			d.trace(TraceAssigned, name, outVal.Interface())
+		}
+	}
+
 	// If we reached here, then we successfully decoded SOMETHING, so
 	// mark the key as used if we're tracking metainput.
 	if addMetaKey && d.config.Metadata != nil && name != "" {
@@ -515,11 +1603,218 @@ func (d *Decoder) decode(name string, input interface{}, outVal reflect.Value) e
 	return err
 }
 
+// trace calls the Trace function of the configuration, if any, with an
+// event of the given kind.
+func (d *decoder) trace(kind TraceEventKind, path string, value interface{}) {
+	if d.config.Trace != nil {
+		d.config.Trace(TraceEvent{Kind: kind, Path: path, Value: value})
+	}
+}
+
+// execDecodeHookIntoInterface executes the decode hook with a target of
+// each of the InterfaceHookTypes in turn, and returns the first result of
+// that type. It reports whether there was such a result.
+func (d *decoder) execDecodeHookIntoInterface(
+	exec func(to reflect.Value) (reflect.Value, error),
+	from reflect.Value,
+) (reflect.Value, bool) {
+	for _, typ := range d.config.InterfaceHookTypes {
+		if from.Type() == typ {
+			break
+		}
+
+		data, err := exec(reflect.New(typ).Elem())
+		if err == nil && data.IsValid() && data.Type() == typ {
+			return data, true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// execDecodeHookWithTimeout executes the given decode hook in a separate
+// goroutine and returns an error if it doesn't finish within timeout. The
+// hook works on a shallow copy of the target value, which is only set on
+// the target if the hook finishes in time. Maps, slices and pointers in the
+// target are still shared with the hook. Hooks get no way to be cancelled,
+// so a hook that keeps running after the timeout is left to finish on its
+// own, and its goroutine lives until then. A panic in the hook is recovered
+// in its goroutine, which would otherwise crash the program, and raised
+// again here if the hook finished in time.
+func execDecodeHookWithTimeout(
+	exec func(to reflect.Value) (reflect.Value, error),
+	to reflect.Value,
+	timeout time.Duration,
+) (reflect.Value, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	toCopy := reflect.New(to.Type()).Elem()
+	toCopy.Set(to)
+
+	type result struct {
+		data     reflect.Value
+		err      error
+		panicked bool
+		panicVal interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		r := result{panicked: true}
+		defer func() {
+			if r.panicked {
+				r.panicVal = recover()
+			}
+			done <- r
+		}()
+
+		r.data, r.err = exec(toCopy)
+		r.panicked = false
+	}()
+
+	select {
+	case r := <-done:
+		if r.panicked {
+			panic(r.panicVal)
+		}
+
+		// Hooks may modify the target directly, so carry that over.
+		to.Set(toCopy)
+		return r.data, r.err
+	case <-timer.C:
+		return reflect.Value{}, fmt.Errorf("decode hook exceeded timeout of %s", timeout)
+	}
+}
+
+// recoverPanic turns a panic into a *PanicError stored in err if
+// RecoverFromPanics is set. It must be called with defer.
+func (d *decoder) recoverPanic(name string, err *error) {
+	if !d.config.RecoverFromPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		*err = &PanicError{Path: name, Value: r, Stack: debug.Stack()}
+	}
+}
+
+// decodeOrderedMap decodes the input by calling the OrderedMapSetter
+// implementation of the target, if it has one. It reports whether such an
+// implementation was used.
+func (d *decoder) decodeOrderedMap(name string, inputVal, outVal reflect.Value) (bool, error) {
+	if !outVal.CanAddr() {
+		return false, nil
+	}
+	setter, ok := outVal.Addr().Interface().(OrderedMapSetter)
+	if !ok {
+		return false, nil
+	}
+
+	input := valueInterface(inputVal)
+	if kvs, ok := input.([]KV); ok {
+		for _, kv := range kvs {
+			setter.SetOrdered(kv.Key, kv.Value)
+		}
+	} else {
+		dataVal := reflect.Indirect(reflect.ValueOf(input))
+		if dataVal.Kind() != reflect.Map || dataVal.Type().Key().Kind() != reflect.String {
+			return true, fmt.Errorf("'%s' expected a map with string keys or []KV, got '%s'", name, dataVal.Type())
+		}
+
+		// Maps have no order, so use the order of the keys.
+		keys := dataVal.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			setter.SetOrdered(key.String(), dataVal.MapIndex(key).Interface())
+		}
+	}
+
+	if d.config.Metadata != nil && name != "" {
+		d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
+	}
+	return true, nil
+}
+
+// decodeDiscriminated decodes the data of v into a new value of the type
+// chosen by DiscriminatorHookFunc, and sets outVal to it.
+func (d *decoder) decodeDiscriminated(name string, v discriminatedValue, outVal reflect.Value) error {
+	result := reflect.New(v.typ).Elem()
+	elem := result
+	if v.typ.Kind() == reflect.Ptr {
+		result = reflect.New(v.typ.Elem())
+		elem = result.Elem()
+	}
+
+	if err := d.decode(name, v.data, elem); err != nil {
+		return err
+	}
+
+	outVal.Set(result)
+	return nil
+}
+
+// valueInterface returns the value held by v, or nil if v is the zero
+// Value, such as the result of a hook that returned nil.
+func valueInterface(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// kvsToMap returns a map with the entries of kvs. Later entries replace
+// earlier ones with the same key.
+func kvsToMap(kvs []KV) map[string]interface{} {
+	m := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+// decodeUnmarshaler decodes the input by calling the MergeUnmarshaler or
+// Unmarshaler implementation of the target, if it has one. It reports
+// whether such an implementation was used.
+func (d *decoder) decodeUnmarshaler(name string, inputVal, outVal reflect.Value) (bool, error) {
+	if !outVal.CanAddr() {
+		return false, nil
+	}
+
+	var unmarshal func() error
+	switch u := outVal.Addr().Interface().(type) {
+	case MergeUnmarshaler:
+		unmarshal = func() error { return u.MergeMapstructure(outVal.Interface(), valueInterface(inputVal)) }
+	case Unmarshaler:
+		unmarshal = func() error { return u.UnmarshalMapstructure(valueInterface(inputVal)) }
+	default:
+		return false, nil
+	}
+
+	err := func() (err error) {
+		defer d.recoverPanic(name, &err)
+		return unmarshal()
+	}()
+	if err != nil {
+		return true, fmt.Errorf("error decoding '%s': %w", name, err)
+	}
+
+	if d.config.Metadata != nil && name != "" {
+		d.config.Metadata.Keys = append(d.config.Metadata.Keys, name)
+	}
+	return true, nil
+}
+
 // This decodes a basic type (bool, int, string, etc.) and sets the
 // value to "data" of that type.
-func (d *Decoder) decodeBasic(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeBasic(name string, data interface{}, val reflect.Value) error {
 	if val.IsValid() && val.Elem().IsValid() {
 		elem := val.Elem()
+		if elem.Kind() == reflect.Ptr {
+			// Don't modify the value the pointer refers to, as it may be
+			// a default that is shared with other values.
+			elem = copyInterfaceValue(val)
+		}
 
 		// If we can't address this element, then its not writable. Instead,
 		// we make a copy of the value (which is a pointer and therefore
@@ -573,7 +1868,17 @@ func (d *Decoder) decodeBasic(name string, data interface{}, val reflect.Value)
 	return nil
 }
 
-func (d *Decoder) decodeString(name string, data interface{}, val reflect.Value) error {
+// timeType is the type of time.Time, which is formatted when decoded into
+// a string.
+var timeType = reflect.TypeOf(time.Time{})
+
+// stripMonotonic returns the value v of a time type without its monotonic
+// clock reading, so that it compares equal to the same time parsed back.
+func stripMonotonic(v reflect.Value) reflect.Value {
+	return reflect.ValueOf(v.Convert(timeType).Interface().(time.Time).Round(0)).Convert(v.Type())
+}
+
+func (d *decoder) decodeString(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 
@@ -581,10 +1886,19 @@ func (d *Decoder) decodeString(name string, data interface{}, val reflect.Value)
 	switch {
 	case dataKind == reflect.String:
 		val.SetString(dataVal.String())
+	case dataKind == reflect.Struct && isTimeType(dataVal.Type()) && d.fromStruct:
+		layout := d.config.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		val.SetString(dataVal.Convert(timeType).Interface().(time.Time).Format(layout))
 	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
-		if dataVal.Bool() {
+		switch {
+		case d.config.WeaklyTypedBoolWords:
+			val.SetString(strconv.FormatBool(dataVal.Bool()))
+		case dataVal.Bool():
 			val.SetString("1")
-		} else {
+		default:
 			val.SetString("0")
 		}
 	case dataKind == reflect.Int && d.config.WeaklyTypedInput:
@@ -617,26 +1931,65 @@ func (d *Decoder) decodeString(name string, data interface{}, val reflect.Value)
 	}
 
 	if !converted {
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
+	}
+
+	if d.config.TrimStrings {
+		val.SetString(strings.TrimSpace(val.String()))
 	}
 
+	if d.config.InternStrings {
+		val.SetString(d.intern(val.String()))
+	}
+
+	d.trackCoercion(name, dataVal, val)
+
 	return nil
 }
 
-func (d *Decoder) decodeInt(name string, data interface{}, val reflect.Value) error {
+// intern returns the string equal to s that was decoded first, so that
+// equal strings share their storage.
+func (d *decoder) intern(s string) string {
+	if interned, ok := d.state.interned[s]; ok {
+		return interned
+	}
+	if d.state.interned == nil {
+		d.state.interned = make(map[string]string)
+	}
+	d.state.interned[s] = s
+	return s
+}
+
+func (d *decoder) decodeInt(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 	dataType := dataVal.Type()
 
+	if d.config.Strict && (dataKind == reflect.Int || dataKind == reflect.Uint || dataKind == reflect.Float32) {
+		if err := setNumber(dataVal, val); err != nil {
+			return fmt.Errorf("cannot parse '%s': %w", name, err)
+		}
+
+		d.trackCoercion(name, dataVal, val)
+		return nil
+	}
+
 	switch {
 	case dataKind == reflect.Int:
 		val.SetInt(dataVal.Int())
 	case dataKind == reflect.Uint:
 		val.SetInt(int64(dataVal.Uint()))
 	case dataKind == reflect.Float32:
-		val.SetInt(int64(dataVal.Float()))
+		f := dataVal.Float()
+		if err := d.checkFloatTruncation(name, f, val); err != nil {
+			return err
+		}
+		val.SetInt(int64(f))
 	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
 		if dataVal.Bool() {
 			val.SetInt(1)
@@ -656,27 +2009,40 @@ func (d *Decoder) decodeInt(name string, data interface{}, val reflect.Value) er
 			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
 		}
 	case dataType.PkgPath() == "encoding/json" && dataType.Name() == "Number":
-		jn := data.(json.Number)
-		i, err := jn.Int64()
+		i, err := parseJSONNumberInt(dataVal.String(), val.Type().Bits())
 		if err != nil {
 			return fmt.Errorf(
 				"error decoding json.Number into %s: %s", name, err)
 		}
 		val.SetInt(i)
 	default:
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
 	}
 
+	d.trackCoercion(name, dataVal, val)
+
 	return nil
 }
 
-func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeUint(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 	dataType := dataVal.Type()
 
+	if d.config.Strict && (dataKind == reflect.Int || dataKind == reflect.Uint || dataKind == reflect.Float32) {
+		if err := setNumber(dataVal, val); err != nil {
+			return fmt.Errorf("cannot parse '%s': %w", name, err)
+		}
+
+		d.trackCoercion(name, dataVal, val)
+		return nil
+	}
+
 	switch {
 	case dataKind == reflect.Int:
 		i := dataVal.Int()
@@ -693,6 +2059,9 @@ func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) e
 			return fmt.Errorf("cannot parse '%s', %f overflows uint",
 				name, f)
 		}
+		if err := d.checkFloatTruncation(name, f, val); err != nil {
+			return err
+		}
 		val.SetUint(uint64(f))
 	case dataKind == reflect.Bool && d.config.WeaklyTypedInput:
 		if dataVal.Bool() {
@@ -713,23 +2082,37 @@ func (d *Decoder) decodeUint(name string, data interface{}, val reflect.Value) e
 			return fmt.Errorf("cannot parse '%s' as uint: %s", name, err)
 		}
 	case dataType.PkgPath() == "encoding/json" && dataType.Name() == "Number":
-		jn := data.(json.Number)
-		i, err := strconv.ParseUint(string(jn), 0, 64)
+		i, err := parseJSONNumberUint(dataVal.String(), val.Type().Bits())
 		if err != nil {
 			return fmt.Errorf(
 				"error decoding json.Number into %s: %s", name, err)
 		}
 		val.SetUint(i)
 	default:
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
 	}
 
+	d.trackCoercion(name, dataVal, val)
+
 	return nil
 }
 
-func (d *Decoder) decodeBool(name string, data interface{}, val reflect.Value) error {
+// checkFloatTruncation returns an error if ErrorOnFloatTruncation is set and
+// the float f has a fractional part that would be lost by decoding it into
+// the integer val.
+func (d *decoder) checkFloatTruncation(name string, f float64, val reflect.Value) error {
+	if !d.config.ErrorOnFloatTruncation || f == math.Trunc(f) {
+		return nil
+	}
+	return fmt.Errorf("cannot parse '%s', %v would be truncated when decoded into '%s'", name, f, val.Type())
+}
+
+func (d *decoder) decodeBool(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 
@@ -752,19 +2135,33 @@ func (d *Decoder) decodeBool(name string, data interface{}, val reflect.Value) e
 			return fmt.Errorf("cannot parse '%s' as bool: %s", name, err)
 		}
 	default:
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
 	}
 
+	d.trackCoercion(name, dataVal, val)
+
 	return nil
 }
 
-func (d *Decoder) decodeFloat(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeFloat(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 	dataType := dataVal.Type()
 
+	if d.config.Strict && (dataKind == reflect.Int || dataKind == reflect.Uint || dataKind == reflect.Float32) {
+		if err := setNumber(dataVal, val); err != nil {
+			return fmt.Errorf("cannot parse '%s': %w", name, err)
+		}
+
+		d.trackCoercion(name, dataVal, val)
+		return nil
+	}
+
 	switch {
 	case dataKind == reflect.Int:
 		val.SetFloat(float64(dataVal.Int()))
@@ -791,23 +2188,72 @@ func (d *Decoder) decodeFloat(name string, data interface{}, val reflect.Value)
 			return fmt.Errorf("cannot parse '%s' as float: %s", name, err)
 		}
 	case dataType.PkgPath() == "encoding/json" && dataType.Name() == "Number":
-		jn := data.(json.Number)
-		i, err := jn.Float64()
+		i, err := strconv.ParseFloat(dataVal.String(), val.Type().Bits())
 		if err != nil {
 			return fmt.Errorf(
 				"error decoding json.Number into %s: %s", name, err)
 		}
 		val.SetFloat(i)
 	default:
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
 	}
 
+	d.trackCoercion(name, dataVal, val)
+
 	return nil
 }
 
-func (d *Decoder) decodeComplex(name string, data interface{}, val reflect.Value) error {
+// parseJSONNumberInt parses the json.Number n as an integer of the given bit
+// size. Numbers with a fraction or an exponent are accepted as long as they
+// are whole numbers, and are never rounded through a float64.
+func parseJSONNumberInt(n string, bitSize int) (int64, error) {
+	i, err := strconv.ParseInt(n, 10, bitSize)
+	if isSyntaxError(err) {
+		if whole, ok := wholeNumber(n); ok {
+			return strconv.ParseInt(whole, 10, bitSize)
+		}
+	}
+	return i, err
+}
+
+// parseJSONNumberUint is like parseJSONNumberInt, for unsigned integers.
+func parseJSONNumberUint(n string, bitSize int) (uint64, error) {
+	i, err := strconv.ParseUint(n, 0, bitSize)
+	if isSyntaxError(err) {
+		if whole, ok := wholeNumber(n); ok {
+			return strconv.ParseUint(whole, 10, bitSize)
+		}
+	}
+	return i, err
+}
+
+// wholeNumber returns the decimal representation of the number n, which
+// may have a fraction or an exponent, if it is a whole number.
+func wholeNumber(n string) (string, bool) {
+	// Bound the exponent before computing the exact value, numbers this
+	// large don't fit any integer type anyway.
+	if f, err := strconv.ParseFloat(n, 64); err != nil || math.Abs(f) >= 1e20 {
+		return "", false
+	}
+
+	r, ok := new(big.Rat).SetString(n)
+	if !ok || !r.IsInt() {
+		return "", false
+	}
+	return r.Num().String(), true
+}
+
+func isSyntaxError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrSyntax
+}
+
+func (d *decoder) decodeComplex(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataKind := getKind(dataVal)
 
@@ -815,15 +2261,18 @@ func (d *Decoder) decodeComplex(name string, data interface{}, val reflect.Value
 	case dataKind == reflect.Complex64:
 		val.SetComplex(dataVal.Complex())
 	default:
-		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+		return &UnconvertibleTypeError{
+			Path:  name,
+			From:  dataVal.Type(),
+			To:    val.Type(),
+			Value: data,
+		}
 	}
 
 	return nil
 }
 
-func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeMap(name string, data interface{}, val reflect.Value) error {
 	valType := val.Type()
 	valKeyType := valType.Key()
 	valElemType := valType.Elem()
@@ -836,6 +2285,13 @@ func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) er
 		// Make a new map to hold our result
 		mapType := reflect.MapOf(valKeyType, valElemType)
 		valMap = reflect.MakeMap(mapType)
+	} else if d.merge {
+		// Copy the map when merging, as it may be part of an earlier input.
+		valMap = reflect.MakeMapWithSize(valType, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			valMap.SetMapIndex(iter.Key(), iter.Value())
+		}
 	}
 
 	dataVal := reflect.ValueOf(data)
@@ -865,7 +2321,9 @@ func (d *Decoder) decodeMap(name string, data interface{}, val reflect.Value) er
 	}
 }
 
-func (d *Decoder) decodeMapFromSlice(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+func (d *decoder) decodeMapFromSlice(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+	d.trackCoercion(name, dataVal, val)
+
 	// Special case for BC reasons (covered by tests)
 	if dataVal.Len() == 0 {
 		val.Set(valMap)
@@ -884,7 +2342,7 @@ func (d *Decoder) decodeMapFromSlice(name string, dataVal reflect.Value, val ref
 	return nil
 }
 
-func (d *Decoder) decodeMapFromMap(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+func (d *decoder) decodeMapFromMap(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
 	valType := val.Type()
 	valKeyType := valType.Key()
 	valElemType := valType.Elem()
@@ -906,19 +2364,49 @@ func (d *Decoder) decodeMapFromMap(name string, dataVal reflect.Value, val refle
 		return nil
 	}
 
+	if err := d.checkMaxLen(name, dataVal.Len()); err != nil {
+		return err
+	}
+
 	for _, k := range dataVal.MapKeys() {
-		fieldName := name + "[" + k.String() + "]"
+		// Entries are pathed like struct fields, so that the metadata
+		// of nested maps reads like "Services.web.Port".
+		fieldName := fmt.Sprint(k.Interface())
+		if name != "" {
+			fieldName = name + "." + fieldName
+		}
 
-		// First decode the key into the proper type
+		// First decode the key into the proper type. The key isn't a value
+		// of the result, so it isn't recorded in the metadata.
+		var keysLen int
+		if d.config.Metadata != nil {
+			keysLen = len(d.config.Metadata.Keys)
+		}
 		currentKey := reflect.Indirect(reflect.New(valKeyType))
-		if err := d.decode(fieldName, k.Interface(), currentKey); err != nil {
-			errs = append(errs, err)
+		if err := d.decodeMapKey(fieldName, k.Interface(), currentKey); err != nil {
+			errs = append(errs, fmt.Errorf("'%s' has invalid key '%v': %w", name, k.Interface(), err))
 			continue
 		}
+		if d.config.Metadata != nil {
+			d.config.Metadata.Keys = d.config.Metadata.Keys[:keysLen]
+		}
 
 		// Next decode the data into the proper type
 		v := dataVal.MapIndex(k).Interface()
 		currentVal := reflect.Indirect(reflect.New(valElemType))
+		if existing := valMap.MapIndex(currentKey); d.merge && existing.IsValid() {
+			currentVal.Set(existing)
+		} else if newValue, ok := d.config.MapValueTemplate[valElemType]; ok && !d.config.ZeroFields {
+			value := newValue()
+			template := reflect.ValueOf(value)
+			if !template.IsValid() || !template.Type().AssignableTo(valElemType) {
+				errs = append(errs, fmt.Errorf(
+					"'%s' MapValueTemplate for '%s' returned a value of type '%T'",
+					fieldName, valElemType, value))
+				continue
+			}
+			currentVal.Set(template)
+		}
 		if err := d.decode(fieldName, v, currentVal); err != nil {
 			errs = append(errs, err)
 			continue
@@ -933,7 +2421,81 @@ func (d *Decoder) decodeMapFromMap(name string, dataVal reflect.Value, val refle
 	return errors.Join(errs...)
 }
 
-func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+// decodeMapKey decodes the map key input into key. Since the keys of the
+// maps decoded from formats such as JSON are always strings, string keys
+// that the hooks leave as is are also parsed into integer, unsigned integer
+// and float key types, as encoding/json does, without WeaklyTypedInput.
+func (d *decoder) decodeMapKey(name string, input interface{}, key reflect.Value) error {
+	if d.config.TrimStrings {
+		d = d.withConfig(func(config *DecoderConfig) { config.TrimStrings = false })
+	}
+
+	err := d.decode(name, input, key)
+	if err == nil {
+		return nil
+	}
+
+	str, ok := input.(string)
+	if !ok {
+		return err
+	}
+	switch getKind(key) {
+	case reflect.Int:
+		if i, perr := strconv.ParseInt(str, 10, key.Type().Bits()); perr == nil {
+			key.SetInt(i)
+			return nil
+		}
+	case reflect.Uint:
+		if i, perr := strconv.ParseUint(str, 10, key.Type().Bits()); perr == nil {
+			key.SetUint(i)
+			return nil
+		}
+	case reflect.Float32:
+		if f, perr := strconv.ParseFloat(str, key.Type().Bits()); perr == nil {
+			key.SetFloat(f)
+			return nil
+		}
+	}
+	return err
+}
+
+// structFieldMapKey returns the key name of a struct field of the struct at
+// path as a key of type keyType, decoding it as decodeMapFromMap does with
+// the keys of a map if keyType isn't string.
+func (d *decoder) structFieldMapKey(path, keyName string, keyType reflect.Type) (reflect.Value, error) {
+	key := reflect.ValueOf(keyName)
+	if keyType == key.Type() {
+		return key, nil
+	}
+
+	fieldName := keyName
+	if path != "" {
+		fieldName = path + "." + fieldName
+	}
+
+	// The key isn't a value of the result, so it isn't recorded in the
+	// metadata.
+	var keysLen int
+	if d.config.Metadata != nil {
+		keysLen = len(d.config.Metadata.Keys)
+	}
+	key = reflect.New(keyType).Elem()
+	if err := d.decodeMapKey(fieldName, keyName, key); err != nil {
+		return reflect.Value{}, fmt.Errorf("'%s' has invalid key '%s': %w", path, keyName, err)
+	}
+	if d.config.Metadata != nil {
+		d.config.Metadata.Keys = d.config.Metadata.Keys[:keysLen]
+	}
+
+	return key, nil
+}
+
+func (d *decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val reflect.Value, valMap reflect.Value) error {
+	// Squashed maps are merged in last so that keys set by struct fields
+	// always take precedence over their entries.
+	var squashedMaps []reflect.Value
+	fieldKeys := make(map[interface{}]struct{})
+
 	typ := dataVal.Type()
 	for i := 0; i < typ.NumField(); i++ {
 		// Get the StructField first since this is a cheap operation. If the
@@ -950,17 +2512,22 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 			return fmt.Errorf("cannot assign type '%s' to map value field of type '%s'", v.Type(), valMap.Type().Elem())
 		}
 
-		tagValue := f.Tag.Get(d.config.TagName)
+		tagValue := fieldTag(f, d.config)
 		keyName := f.Name
+		if d.config.EncodeFieldName != nil {
+			keyName = d.config.EncodeFieldName(f)
+		}
 
-		if tagValue == "" && d.config.IgnoreUntaggedFields {
+		if !d.isFieldIncluded(tagValue) {
 			continue
 		}
 
 		// If Squash is set in the config, we squash the field down.
 		squash := d.config.Squash && v.Kind() == reflect.Struct && f.Anonymous
 
-		v = dereferencePtrToStructIfNeeded(v, d.config.TagName)
+		// Remember the pointer to detect cycles if we recurse into the struct.
+		ptr := v
+		v = dereferencePtrToStructIfNeeded(v, d.config)
 
 		// Determine the name of the key in the map
 		if index := strings.Index(tagValue, ","); index != -1 {
@@ -971,6 +2538,10 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 			if strings.Index(tagValue[index+1:], "omitempty") != -1 && isEmptyValue(v) {
 				continue
 			}
+			// If "omitzero" is specified in the tag, it ignores zero values.
+			if strings.Index(tagValue[index+1:], "omitzero") != -1 && isZeroValue(ptr) {
+				continue
+			}
 
 			// If "squash" is specified in the tag, we squash the field down.
 			squash = squash || strings.Index(tagValue[index+1:], "squash") != -1
@@ -980,7 +2551,13 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 					v = v.Elem()
 				}
 
-				// The final type must be a struct
+				// Squashed maps have their entries merged into the parent.
+				if v.Kind() == reflect.Map {
+					squashedMaps = append(squashedMaps, v)
+					continue
+				}
+
+				// The final type must be a struct or a map
 				if v.Kind() != reflect.Struct {
 					return fmt.Errorf("cannot squash non-struct type '%s'", v.Type())
 				}
@@ -1007,9 +2584,50 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 			keyName = tagValue
 		}
 
+		var mapKey reflect.Value
+		if !squash {
+			var err error
+			if mapKey, err = d.structFieldMapKey(name, keyName, valMap.Type().Key()); err != nil {
+				return err
+			}
+		}
+
+		if d.encode && !squash {
+			encoded, err := d.encodeValue(keyName, ptr)
+			if err != nil {
+				return err
+			}
+			valMap.SetMapIndex(mapKey, valueOrZero(encoded, valMap.Type().Elem()))
+			fieldKeys[mapKey.Interface()] = struct{}{}
+			continue
+		}
+
 		switch v.Kind() {
 		// this is an embedded struct, so handle it differently
 		case reflect.Struct:
+			var visited *visitedPtr
+			if ptr.Kind() == reflect.Ptr {
+				key := visitedPtr{ptr.Pointer(), ptr.Type()}
+				if _, ok := d.state.visiting[key]; ok {
+					if !d.config.BreakCycles {
+						return fmt.Errorf("'%s' contains a cycle through a pointer of type '%s'", keyName, ptr.Type())
+					}
+
+					// Break the cycle, leaving a nil placeholder.
+					if !squash {
+						valMap.SetMapIndex(mapKey, reflect.Zero(valMap.Type().Elem()))
+						fieldKeys[mapKey.Interface()] = struct{}{}
+					}
+					continue
+				}
+
+				if d.state.visiting == nil {
+					d.state.visiting = make(map[visitedPtr]struct{})
+				}
+				d.state.visiting[key] = struct{}{}
+				visited = &key
+			}
+
 			x := reflect.New(v.Type())
 			x.Elem().Set(v)
 
@@ -1027,6 +2645,9 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 			reflect.Indirect(addrVal).Set(vMap)
 
 			err := d.decode(keyName, x.Interface(), reflect.Indirect(addrVal))
+			if visited != nil {
+				delete(d.state.visiting, *visited)
+			}
 			if err != nil {
 				return err
 			}
@@ -1035,16 +2656,44 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 			// it indirectly out of the enclosing value.
 			vMap = reflect.Indirect(addrVal)
 
+			// A time has no exported fields, so when flattening a struct
+			// into another one, keep the time itself without the monotonic
+			// clock reading unless a hook turned it into a map. It can then
+			// be decoded into a time or a string.
+			if d.fromStruct && isTimeType(v.Type()) && !squash && vMap.Len() == 0 && v.Type().AssignableTo(vElemType) {
+				valMap.SetMapIndex(mapKey, stripMonotonic(v))
+				fieldKeys[mapKey.Interface()] = struct{}{}
+				continue
+			}
+
 			if squash {
 				for _, k := range vMap.MapKeys() {
 					valMap.SetMapIndex(k, vMap.MapIndex(k))
+					fieldKeys[k.Interface()] = struct{}{}
 				}
 			} else {
-				valMap.SetMapIndex(reflect.ValueOf(keyName), vMap)
+				valMap.SetMapIndex(mapKey, vMap)
+				fieldKeys[mapKey.Interface()] = struct{}{}
 			}
 
 		default:
-			valMap.SetMapIndex(reflect.ValueOf(keyName), v)
+			valMap.SetMapIndex(mapKey, v)
+			fieldKeys[mapKey.Interface()] = struct{}{}
+		}
+	}
+
+	for _, m := range squashedMaps {
+		if !m.Type().Key().AssignableTo(valMap.Type().Key()) ||
+			!m.Type().Elem().AssignableTo(valMap.Type().Elem()) {
+			return fmt.Errorf("cannot squash map type '%s' into map of type '%s'", m.Type(), valMap.Type())
+		}
+
+		iter := m.MapRange()
+		for iter.Next() {
+			if _, ok := fieldKeys[iter.Key().Interface()]; ok {
+				continue
+			}
+			valMap.SetMapIndex(iter.Key(), iter.Value())
 		}
 	}
 
@@ -1055,7 +2704,7 @@ func (d *Decoder) decodeMapFromStruct(name string, dataVal reflect.Value, val re
 	return nil
 }
 
-func (d *Decoder) decodePtr(name string, data interface{}, val reflect.Value) (bool, error) {
+func (d *decoder) decodePtr(name string, data interface{}, val reflect.Value) (bool, error) {
 	// If the input data is nil, then we want to just set the output
 	// pointer to be nil as well.
 	isNil := data == nil
@@ -1102,20 +2751,20 @@ func (d *Decoder) decodePtr(name string, data interface{}, val reflect.Value) (b
 	return false, nil
 }
 
-func (d *Decoder) decodeFunc(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeFunc(name string, data interface{}, val reflect.Value) error {
 	// Create an element of the concrete (non pointer) type and decode
 	// into that. Then set the value of the pointer to this type.
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	if val.Type() != dataVal.Type() {
 		return fmt.Errorf(
-			"'%s' expected type '%s', got unconvertible type '%s', value: '%v'",
-			name, val.Type(), dataVal.Type(), data)
+			"'%s' is a func of type '%s' and can only be decoded from the same type, got '%s'",
+			name, val.Type(), dataVal.Type())
 	}
 	val.Set(dataVal)
 	return nil
 }
 
-func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value) error {
+func (d *decoder) decodeSlice(name string, data interface{}, val reflect.Value) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	dataValKind := dataVal.Kind()
 	valType := val.Type()
@@ -1125,6 +2774,8 @@ func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value)
 	// If we have a non array/slice type then we first attempt to convert.
 	if dataValKind != reflect.Array && dataValKind != reflect.Slice {
 		if d.config.WeaklyTypedInput {
+			d.trackCoercion(name, dataVal, val)
+
 			switch {
 			// Slice and array we use the normal logic
 			case dataValKind == reflect.Slice, dataValKind == reflect.Array:
@@ -1156,28 +2807,60 @@ func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value)
 
 	// If the input value is nil, then don't allocate since empty != nil
 	if dataValKind != reflect.Array && dataVal.IsNil() {
+		if d.config.DecodeNil && !val.IsNil() {
+			val.Set(reflect.Zero(val.Type()))
+		}
 		return nil
 	}
 
+	if err := d.checkMaxLen(name, dataVal.Len()); err != nil {
+		return err
+	}
+
+	if d.config.ErrorOnHeterogeneousSlice {
+		if err := checkHeterogeneousSlice(name, dataVal, valElemType); err != nil {
+			return err
+		}
+	}
+
 	valSlice := val
+	offset := 0
 	if valSlice.IsNil() || d.config.ZeroFields {
 		// Make a new slice to hold our result, same size as the original data.
 		valSlice = reflect.MakeSlice(sliceType, dataVal.Len(), dataVal.Len())
+	} else if d.config.SliceMergeMode == SliceMergeAppend {
+		// Append to a copy, as the slice may be part of an earlier input.
+		offset = valSlice.Len()
+		valSlice = reflect.AppendSlice(reflect.MakeSlice(sliceType, 0, offset+dataVal.Len()), valSlice)
+	} else if d.merge {
+		// Replace the slice without modifying it, as it may be part of an
+		// earlier input.
+		valSlice = reflect.MakeSlice(sliceType, dataVal.Len(), dataVal.Len())
 	} else if valSlice.Len() > dataVal.Len() {
 		valSlice = valSlice.Slice(0, dataVal.Len())
 	}
 
+	// Numbers decoded into bytes would otherwise wrap around, such as 300
+	// into 44, unless they are bytes already.
+	checkBytes := valElemType.Kind() == reflect.Uint8 && dataVal.Type().Elem().Kind() != reflect.Uint8
+
 	// Accumulate any errors
 	var errs []error
 
 	for i := 0; i < dataVal.Len(); i++ {
 		currentData := dataVal.Index(i).Interface()
-		for valSlice.Len() <= i {
+		for valSlice.Len() <= offset+i {
 			valSlice = reflect.Append(valSlice, reflect.Zero(valElemType))
 		}
-		currentField := valSlice.Index(i)
+		currentField := valSlice.Index(offset + i)
 
-		fieldName := name + "[" + strconv.Itoa(i) + "]"
+		fieldName := name + "[" + strconv.Itoa(offset+i) + "]"
+		if checkBytes {
+			if err := checkByteRange(fieldName, currentData); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
 		if err := d.decode(fieldName, currentData, currentField); err != nil {
 			errs = append(errs, err)
 		}
@@ -1189,8 +2872,78 @@ func (d *Decoder) decodeSlice(name string, data interface{}, val reflect.Value)
 	return errors.Join(errs...)
 }
 
-func (d *Decoder) decodeArray(name string, data interface{}, val reflect.Value) error {
+// checkByteRange returns an error if data is a number outside of the range
+// of a byte, 0 to 255. Other values are left to decodeUint.
+func checkByteRange(name string, data interface{}) error {
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	inRange := true
+	switch getKind(dataVal) {
+	case reflect.Int:
+		inRange = dataVal.Int() >= 0 && dataVal.Int() <= math.MaxUint8
+	case reflect.Uint:
+		inRange = dataVal.Uint() <= math.MaxUint8
+	case reflect.Float32:
+		inRange = dataVal.Float() >= 0 && dataVal.Float() < math.MaxUint8+1
+	}
+
+	if !inRange {
+		return fmt.Errorf("'%s' value %v is out of range for a byte (0-255)", name, dataVal.Interface())
+	}
+	return nil
+}
+
+// checkHeterogeneousSlice returns an error for each element of the slice
+// dataVal whose kind differs from the kind of elemType, if the elements of
+// dataVal have different kinds. Nil elements are ignored.
+func checkHeterogeneousSlice(name string, dataVal reflect.Value, elemType reflect.Type) error {
+	if elemType.Kind() == reflect.Interface {
+		return nil
+	}
+
+	elems := make([]reflect.Value, dataVal.Len())
+	first := reflect.Invalid
+	mixed := false
+	for i := range elems {
+		elem := dataVal.Index(i)
+		if elem.Kind() == reflect.Interface {
+			elem = elem.Elem()
+		}
+		if elems[i] = reflect.Indirect(elem); !elems[i].IsValid() {
+			continue
+		}
+		if kind := getKind(elems[i]); first == reflect.Invalid {
+			first = kind
+		} else if kind != first {
+			mixed = true
+		}
+	}
+	if !mixed {
+		return nil
+	}
+
+	want := getKind(reflect.Zero(elemType))
+	var errs []error
+	for i, elem := range elems {
+		if elem.IsValid() && getKind(elem) != want {
+			errs = append(errs, fmt.Errorf(
+				"'%s[%d]' has type '%s' in a slice of mixed types, expected '%s'",
+				name, i, elem.Type(), elemType))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkMaxLen returns an error if a slice or map with n elements exceeds
+// MaxSliceLen.
+func (d *decoder) checkMaxLen(name string, n int) error {
+	if d.config.MaxSliceLen > 0 && n > d.config.MaxSliceLen {
+		return fmt.Errorf("'%s' has %d elements, more than the maximum of %d", name, n, d.config.MaxSliceLen)
+	}
+	return nil
+}
+
+func (d *decoder) decodeArray(name string, data reflect.Value, val reflect.Value) error {
+	dataVal := reflect.Indirect(data)
 	dataValKind := dataVal.Kind()
 	valType := val.Type()
 	valElemType := valType.Elem()
@@ -1202,6 +2955,8 @@ func (d *Decoder) decodeArray(name string, data interface{}, val reflect.Value)
 		// Check input type
 		if dataValKind != reflect.Array && dataValKind != reflect.Slice {
 			if d.config.WeaklyTypedInput {
+				d.trackCoercion(name, dataVal, val)
+
 				switch {
 				// Empty maps turn into empty arrays
 				case dataValKind == reflect.Map:
@@ -1214,7 +2969,7 @@ func (d *Decoder) decodeArray(name string, data interface{}, val reflect.Value)
 				// and "lift" it into it. i.e. a string becomes a string array.
 				default:
 					// Just re-try this function with data as a slice.
-					return d.decodeArray(name, []interface{}{data}, val)
+					return d.decodeArray(name, reflect.ValueOf([]interface{}{valueInterface(data)}), val)
 				}
 			}
 
@@ -1250,8 +3005,8 @@ func (d *Decoder) decodeArray(name string, data interface{}, val reflect.Value)
 	return errors.Join(errs...)
 }
 
-func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value) error {
-	dataVal := reflect.Indirect(reflect.ValueOf(data))
+func (d *decoder) decodeStruct(name string, data reflect.Value, val reflect.Value) error {
+	dataVal := reflect.Indirect(data)
 
 	// If the type of the value to write to and the data match directly,
 	// then we just set it directly instead of recursing into the structure.
@@ -1266,13 +3021,25 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 		return d.decodeStructFromMap(name, dataVal, val)
 
 	case reflect.Struct:
-		// Not the most efficient way to do this but we can optimize later if
-		// we want to. To convert from struct to struct we go to map first
+		// Times of the struct may be formatted into strings.
+		d = &decoder{Decoder: d.Decoder, state: d.state, fromStruct: true}
+
+		// Copy the fields directly if the result is the same as with the
+		// map below.
+		if d.canCopyStruct(dataVal.Type(), val.Type()) {
+			return d.copyStruct(name, dataVal, val)
+		}
+
+		// Otherwise, to convert from struct to struct we go to map first
 		// as an intermediary.
 
-		// Make a new map to hold our result
-		mapType := reflect.TypeOf((map[string]interface{})(nil))
-		mval := reflect.MakeMap(mapType)
+		// Take a map to hold our result from the pool. The map is never
+		// referenced once decoded, unless it's handed to the FlattenHook.
+		m := flattenMapPool.Get().(map[string]interface{})
+		if d.config.FlattenHook == nil {
+			defer releaseFlattenMap(m)
+		}
+		mval := reflect.ValueOf(m)
 
 		// Creating a pointer to a map so that other methods can completely
 		// overwrite the map if need be (looking at you decodeMapFromMap). The
@@ -1285,20 +3052,272 @@ func (d *Decoder) decodeStruct(name string, data interface{}, val reflect.Value)
 			return err
 		}
 
-		result := d.decodeStructFromMap(name, reflect.Indirect(addrVal), val)
+		flattened := reflect.Indirect(addrVal)
+		if d.config.FlattenHook != nil {
+			m, err := func() (m map[string]interface{}, err error) {
+				defer d.recoverPanic(name, &err)
+				return d.config.FlattenHook(flattened.Interface().(map[string]interface{}))
+			}()
+			if err != nil {
+				return fmt.Errorf("error flattening '%s': %w", name, err)
+			}
+			flattened = reflect.ValueOf(m)
+		}
+
+		result := d.decodeStructFromMap(name, flattened, val)
 		return result
 
+	case reflect.Slice, reflect.Array:
+		if ok, err := d.decodeStructFromSlice(name, dataVal, val); ok {
+			return err
+		}
+
+		fallthrough
+
 	default:
+		// Scalars can be decoded into the field marked as primary.
+		if i, ok := primaryFieldIndex(val.Type(), d.config); ok {
+			fieldName := val.Type().Field(i).Name
+			if name != "" {
+				fieldName = name + "." + fieldName
+			}
+			return d.decode(fieldName, valueInterface(data), val.Field(i))
+		}
+
 		return fmt.Errorf("'%s' expected a map, got '%s'", name, dataVal.Kind())
 	}
 }
 
-func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) error {
-	dataValType := dataVal.Type()
-	if kind := dataValType.Key().Kind(); kind != reflect.String && kind != reflect.Interface {
-		return fmt.Errorf(
-			"'%s' needs a map with string keys, has '%s' keys",
-			name, dataValType.Key().Kind())
+// flattenMapPool holds the intermediary maps of struct to struct decoding,
+// to reduce allocations. The maps are empty while in the pool.
+var flattenMapPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]interface{})
+	},
+}
+
+// releaseFlattenMap empties m, so that it holds no references to decoded
+// values, and puts it back in flattenMapPool.
+func releaseFlattenMap(m map[string]interface{}) {
+	for k := range m {
+		delete(m, k)
+	}
+	flattenMapPool.Put(m)
+}
+
+// decodeStructFromSlice decodes the elements of the slice or array dataVal
+// into the fields of the struct val by position. It reports whether the
+// struct can be decoded from a slice, which is the case if it has fields
+// with the "pos=" tag option or if SlicePositional is set.
+func (d *decoder) decodeStructFromSlice(name string, dataVal, val reflect.Value) (bool, error) {
+	fields, positions, err := d.positionalFields(val.Type())
+	if err != nil {
+		return true, fmt.Errorf("'%s' %w", name, err)
+	}
+	if fields == nil {
+		return false, nil
+	}
+
+	// Without positions the fields are in declaration order, so every
+	// element must have a field.
+	if positions == nil && dataVal.Len() != len(fields) {
+		return true, fmt.Errorf(
+			"'%s' expected %d elements, one per field, got %d", name, len(fields), dataVal.Len())
+	}
+
+	var errs []error
+	used := make(map[int]struct{}, len(fields))
+	for i, f := range fields {
+		pos := i
+		if positions != nil {
+			pos = positions[i]
+		}
+
+		fieldName := f.Name
+		if tagValue := strings.Split(fieldTag(f, d.config), ",")[0]; tagValue != "" {
+			fieldName = tagValue
+		}
+		if name != "" {
+			fieldName = name + "." + fieldName
+		}
+
+		if pos >= dataVal.Len() {
+			errs = append(errs, fmt.Errorf(
+				"'%s' position %d is out of range for %d elements", fieldName, pos, dataVal.Len()))
+			continue
+		}
+		used[pos] = struct{}{}
+
+		if err := d.decode(fieldName, dataVal.Index(pos).Interface(), val.FieldByIndex(f.Index)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if d.config.ErrorUnused {
+		var unused []string
+		for i := 0; i < dataVal.Len(); i++ {
+			if _, ok := used[i]; !ok {
+				unused = append(unused, strconv.Itoa(i))
+			}
+		}
+		if len(unused) > 0 {
+			errs = append(errs, fmt.Errorf(
+				"'%s' has unused elements at positions: %s", name, strings.Join(unused, ", ")))
+		}
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// positionalFields returns the fields of the given struct type that a slice
+// is decoded into. If some fields have the "pos=" tag option, only those
+// are returned along with their positions. Otherwise, if SlicePositional is
+// set, all the fields taking part in decoding are returned in declaration
+// order with nil positions.
+func (d *decoder) positionalFields(typ reflect.Type) ([]reflect.StructField, []int, error) {
+	var fields, tagged []reflect.StructField
+	var positions []int
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tagValue := fieldTag(f, d.config)
+		tagParts := strings.Split(tagValue, ",")
+		if tagParts[0] == "-" || !d.isFieldIncluded(tagValue) {
+			continue
+		}
+		fields = append(fields, f)
+
+		for _, tag := range tagParts[1:] {
+			if value := strings.TrimPrefix(tag, "pos="); value != tag {
+				pos, err := strconv.Atoi(value)
+				if err != nil || pos < 0 {
+					return nil, nil, fmt.Errorf("field '%s' has invalid position '%s'", f.Name, value)
+				}
+				tagged = append(tagged, f)
+				positions = append(positions, pos)
+			}
+		}
+	}
+
+	if tagged != nil {
+		return tagged, positions, nil
+	}
+	if d.config.SlicePositional {
+		return fields, nil, nil
+	}
+	return nil, nil, nil
+}
+
+// fieldTag returns the tag of the given struct field: its TagName tag if it
+// has one, or else its first tag among the FallbackTagNames.
+func fieldTag(field reflect.StructField, config *DecoderConfig) string {
+	if tag, ok := field.Tag.Lookup(config.TagName); ok || len(config.FallbackTagNames) == 0 {
+		return tag
+	}
+	for _, name := range config.FallbackTagNames {
+		if tag, ok := field.Tag.Lookup(name); ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// primaryFieldIndex returns the index of the field of the given struct type
+// that has the "primary" tag option.
+func primaryFieldIndex(typ reflect.Type, config *DecoderConfig) (int, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		tagParts := strings.Split(fieldTag(typ.Field(i), config), ",")
+		for _, tag := range tagParts[1:] {
+			if tag == "primary" {
+				return i, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// checkPrimaryFields walks the given type and returns an error if any
+// struct within it has more than one field with the "primary" tag option.
+func checkPrimaryFields(typ reflect.Type, config *DecoderConfig, visited map[reflect.Type]struct{}) error {
+	if _, ok := visited[typ]; ok {
+		return nil
+	}
+	visited[typ] = struct{}{}
+
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return checkPrimaryFields(typ.Elem(), config, visited)
+	case reflect.Struct:
+		var primary []string
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			tagParts := strings.Split(fieldTag(f, config), ",")
+			for _, tag := range tagParts[1:] {
+				if tag == "primary" {
+					primary = append(primary, f.Name)
+				}
+			}
+
+			if err := checkPrimaryFields(f.Type, config, visited); err != nil {
+				return err
+			}
+		}
+
+		if len(primary) > 1 {
+			return fmt.Errorf(
+				"struct '%s' has multiple primary fields: %s",
+				typ, strings.Join(primary, ", "))
+		}
+	}
+
+	return nil
+}
+
+// checkNamedHooks returns an error if a field of the given type or of a
+// struct within it has the "hook=" tag option with a name that isn't one
+// of the NamedHooks of config.
+func checkNamedHooks(typ reflect.Type, config *DecoderConfig, visited map[reflect.Type]struct{}) error {
+	if _, ok := visited[typ]; ok {
+		return nil
+	}
+	visited[typ] = struct{}{}
+
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return checkNamedHooks(typ.Elem(), config, visited)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			tagParts := strings.Split(fieldTag(f, config), ",")
+			for _, tag := range tagParts[1:] {
+				hookName := strings.TrimPrefix(tag, "hook=")
+				if hookName == tag {
+					continue
+				}
+				if _, ok := config.NamedHooks[hookName]; !ok {
+					return fmt.Errorf("field '%s' of struct '%s' has unknown hook '%s'", f.Name, typ, hookName)
+				}
+			}
+
+			if err := checkNamedHooks(f.Type, config, visited); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) error {
+	if !hasStringKeys(dataVal) {
+		var err error
+		if dataVal, err = stringifyMapKeys(name, dataVal); err != nil {
+			return err
+		}
 	}
 
 	dataValKeys := make(map[reflect.Value]struct{})
@@ -1320,15 +3339,18 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 
 	// Compile the list of all the fields that we're going to be decoding
 	// from all the structs.
-	type field struct {
-		field reflect.StructField
-		val   reflect.Value
-	}
-
 	// remainField is set to a valid field set with the "remain" tag if
 	// we are keeping track of remaining values.
 	var remainField *field
 
+	// squashedMapFields are map fields with the "squash" tag, which collect
+	// the keys that don't match any other field.
+	var squashedMapFields []field
+
+	// squashedInterfaces are the interface fields with the "squash" tag
+	// paired with the copy of their concrete value being decoded into.
+	var squashedInterfaces [][2]reflect.Value
+
 	fields := []field{}
 	for len(structs) > 0 {
 		structVal := structs[0]
@@ -1349,7 +3371,7 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 			remain := false
 
 			// We always parse the tags cause we're looking for other tags too
-			tagParts := strings.Split(fieldType.Tag.Get(d.config.TagName), ",")
+			tagParts := strings.Split(fieldTag(fieldType, d.config), ",")
 			for _, tag := range tagParts[1:] {
 				if tag == "squash" {
 					squash = true
@@ -1362,6 +3384,25 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 				}
 			}
 
+			if fieldVal.Kind() == reflect.Interface && !fieldVal.IsNil() {
+				squash = squash || d.config.Squash && fieldType.Anonymous
+				if squash {
+					// Decode into a copy of the concrete value held by the
+					// interface, which is assigned back once decoded.
+					concrete := copyInterfaceValue(fieldVal)
+					if v := reflect.Indirect(concrete); v.Kind() == reflect.Struct {
+						structs = append(structs, v)
+						squashedInterfaces = append(squashedInterfaces, [2]reflect.Value{fieldVal, concrete})
+						continue
+					}
+				}
+			}
+
+			if squash && fieldVal.Kind() == reflect.Map {
+				squashedMapFields = append(squashedMapFields, field{fieldType, fieldVal})
+				continue
+			}
+
 			if squash {
 				if fieldVal.Kind() != reflect.Struct {
 					errs = append(errs, fmt.Errorf("%s: unsupported type for squash: %s", fieldType.Name, fieldVal.Kind()))
@@ -1381,60 +3422,229 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 		}
 	}
 
+	if d.config.StripFieldPrefix != "" {
+		if err := d.checkStrippedFieldNames(name, fields); err != nil {
+			return err
+		}
+	}
+
+	// The field with the "version" option is decoded first, since the
+	// fields with a "minversion=" option depend on its value.
+	var versionField *field
+	for i, f := range fields {
+		if hasTagOption(fieldTag(f.field, d.config), "version") {
+			reordered := make([]field, 0, len(fields))
+			reordered = append(reordered, f)
+			reordered = append(reordered, fields[:i]...)
+			fields = append(reordered, fields[i+1:]...)
+			versionField = &fields[0]
+			break
+		}
+	}
+
+	// matchedFieldNames tracks which fields were present in the input and
+	// conflicts the pairs of fields that must not both be present.
+	matchedFieldNames := make(map[string]struct{})
+	var conflicts [][2]string
+
+	// rawFieldNames tracks the fields that are targets of a "rawonfail"
+	// option. They are only set on failure, so they never count as unset.
+	rawFieldNames := make(map[string]struct{})
+
+	// keyFieldCounts counts the fields each key of the input matched, to
+	// detect duplicates.
+	keyFieldCounts := make(map[interface{}]int)
+
 	// for fieldType, field := range fields {
 	for _, f := range fields {
 		field, fieldValue := f.field, f.val
 		fieldName := field.Name
 
-		tagValue := field.Tag.Get(d.config.TagName)
-		if tagValue == "" && d.config.IgnoreUntaggedFields {
+		tagValue := fieldTag(field, d.config)
+		if !d.isFieldIncluded(tagValue) {
+			continue
+		}
+		tagParts := strings.Split(tagValue, ",")
+		tagValue = tagParts[0]
+		if tagValue == "-" {
 			continue
 		}
-		tagValue = strings.SplitN(tagValue, ",", 2)[0]
 		if tagValue != "" {
 			fieldName = tagValue
+		} else {
+			fieldName = d.stripFieldPrefix(fieldName)
 		}
-
-		rawMapKey := reflect.ValueOf(fieldName)
-		rawMapVal := dataVal.MapIndex(rawMapKey)
-		if !rawMapVal.IsValid() {
-			// Do a slower search by iterating over each key and
-			// doing case-insensitive search.
-			for dataValKey := range dataValKeys {
-				mK, ok := dataValKey.Interface().(string)
-				if !ok {
-					// Not a string key
-					continue
+		rawOnFail := ""
+		var hookTimeout time.Duration
+		var fieldHook DecodeHookFunc
+		plural := false
+		unique := false
+		rawUnmarshal := false
+		var zeroFields *bool
+		noTrim := false
+		appendSlices := false
+		maxLen := 0
+		minVersion, hasMinVersion := int64(0), false
+		entryDefault, hasEntryDefault := "", false
+		fieldDecoder := d
+		for _, tag := range tagParts[1:] {
+			if configName := strings.TrimPrefix(tag, "config="); configName != tag {
+				var err error
+				if fieldDecoder, err = d.subDecoder(configName); err != nil {
+					errs = append(errs, fmt.Errorf("'%s' %w", fieldName, err))
+					fieldDecoder = d
 				}
-
-				if d.config.MatchName(mK, fieldName) {
-					rawMapKey = dataValKey
-					rawMapVal = dataVal.MapIndex(dataValKey)
-					break
+			}
+			if tag == "plural" {
+				plural = true
+			}
+			if tag == "unique" {
+				unique = true
+			}
+			if tag == "rawunmarshal" {
+				rawUnmarshal = true
+			}
+			if tag == "notrim" {
+				noTrim = true
+			}
+			if tag == "append" {
+				appendSlices = true
+			}
+			if value := strings.TrimPrefix(tag, "maxlen="); value != tag {
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					errs = append(errs, fmt.Errorf("'%s' has invalid maxlen '%s'", fieldName, value))
+				} else {
+					maxLen = n
+				}
+			}
+			if tag == "zero" || tag == "nozero" {
+				zero := tag == "zero"
+				zeroFields = &zero
+			}
+			if value := strings.TrimPrefix(tag, "minversion="); value != tag {
+				var err error
+				if minVersion, err = strconv.ParseInt(value, 10, 64); err != nil {
+					errs = append(errs, fmt.Errorf("'%s' has invalid minversion: %w", fieldName, err))
+				}
+				hasMinVersion = true
+			}
+			if value := strings.TrimPrefix(tag, "entrydefault="); value != tag {
+				entryDefault, hasEntryDefault = value, true
+			}
+			if timeout := strings.TrimPrefix(tag, "timeout="); timeout != tag {
+				var err error
+				if hookTimeout, err = time.ParseDuration(timeout); err != nil {
+					errs = append(errs, fmt.Errorf("'%s' has invalid timeout: %w", fieldName, err))
+				}
+			}
+			if hookName := strings.TrimPrefix(tag, "hook="); hookName != tag {
+				var ok bool
+				if fieldHook, ok = d.config.NamedHooks[hookName]; !ok {
+					errs = append(errs, fmt.Errorf("'%s' has unknown hook '%s'", fieldName, hookName))
+				}
+			}
+			if other := strings.TrimPrefix(tag, "conflictswith="); other != tag {
+				if otherName, ok := d.resolveFieldName(name, fields, other); ok {
+					conflicts = append(conflicts, [2]string{fieldName, otherName})
+				} else {
+					errs = append(errs, fmt.Errorf("'%s' conflicts with unknown field '%s'", fieldName, other))
 				}
 			}
+			if other := strings.TrimPrefix(tag, "rawonfail="); other != tag {
+				rawOnFail = other
+				rawFieldNames[other] = struct{}{}
+			}
+		}
+
+		if zeroFields != nil && *zeroFields != fieldDecoder.config.ZeroFields {
+			fieldDecoder = fieldDecoder.withConfig(func(config *DecoderConfig) { config.ZeroFields = *zeroFields })
+		}
+		if maxLen > 0 && maxLen != fieldDecoder.config.MaxSliceLen {
+			fieldDecoder = fieldDecoder.withConfig(func(config *DecoderConfig) { config.MaxSliceLen = maxLen })
+		}
+		if noTrim && fieldDecoder.config.TrimStrings {
+			fieldDecoder = fieldDecoder.withConfig(func(config *DecoderConfig) { config.TrimStrings = false })
+		}
+		if appendSlices && fieldDecoder.config.SliceMergeMode != SliceMergeAppend {
+			fieldDecoder = fieldDecoder.withConfig(func(config *DecoderConfig) { config.SliceMergeMode = SliceMergeAppend })
+		}
+
+		rawMapKey, rawMapVal := d.lookupMapKey(name, dataVal, dataValKeys, fieldName, tagValue != "")
 
-			if !rawMapVal.IsValid() {
-				// There was no matching key in the map for the value in
-				// the struct. Remember it for potential errors and metadata.
-				targetValKeysUnused[fieldName] = struct{}{}
+		if hasMinVersion {
+			active, err := structVersionAtLeast(versionField, minVersion)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("'%s' %w", name, err))
+				continue
+			}
+			if !active {
+				// Fields of other versions are neither unset nor is their
+				// key unused.
+				if rawMapVal.IsValid() {
+					delete(dataValKeysUnused, rawMapKey.Interface())
+				}
 				continue
 			}
 		}
 
+		if !rawMapVal.IsValid() && plural {
+			// Fall back to the singular key, lifting a single value
+			// into a slice.
+			if singular := strings.TrimSuffix(fieldName, "s"); singular != fieldName && singular != "" {
+				rawMapKey, rawMapVal = d.lookupMapKey(name, dataVal, dataValKeys, singular, tagValue != "")
+				if rawMapVal.IsValid() && rawMapVal.Interface() != nil {
+					switch reflect.Indirect(reflect.ValueOf(rawMapVal.Interface())).Kind() {
+					case reflect.Slice, reflect.Array:
+					default:
+						rawMapVal = reflect.ValueOf([]interface{}{rawMapVal.Interface()})
+					}
+				}
+			}
+		}
+
+		if !rawMapVal.IsValid() {
+			// There was no matching key in the map for the value in
+			// the struct. Remember it for potential errors and metadata.
+			targetValKeysUnused[fieldName] = struct{}{}
+			continue
+		}
+
 		if !fieldValue.IsValid() {
 			// This should never happen
 			panic("field is not valid")
 		}
 
+		var setter func(reflect.Value) error
+		var setterType reflect.Type
+		if d.config.UseSetters {
+			setter, setterType = fieldSetter(val, field)
+		}
+
 		// If we can't set the field, then it is unexported or something,
-		// and we just continue onwards.
-		if !fieldValue.CanSet() {
-			continue
+		// and we just continue onwards, unless unexported fields are
+		// allowed or it has a setter.
+		if !fieldValue.CanSet() && setter == nil {
+			if !d.config.AllowUnexportedFields || field.PkgPath == "" || !fieldValue.CanAddr() {
+				continue
+			}
+			if tagValue == "" {
+				path := fieldName
+				if name != "" {
+					path = name + "." + path
+				}
+				errs = append(errs, fmt.Errorf(
+					"'%s' matches unexported field '%s', which must be tagged to be decoded",
+					path, field.Name))
+				continue
+			}
+			fieldValue = settableField(fieldValue)
 		}
 
 		// Delete the key we're using from the unused map so we stop tracking
 		delete(dataValKeysUnused, rawMapKey.Interface())
+		matchedFieldNames[fieldName] = struct{}{}
+		keyFieldCounts[rawMapKey.Interface()]++
 
 		// If the name is empty string, then we're at the root, and we
 		// don't dot-join the fields.
@@ -1442,22 +3652,170 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 			fieldName = name + "." + fieldName
 		}
 
-		if err := d.decode(fieldName, rawMapVal.Interface(), fieldValue); err != nil {
-			errs = append(errs, err)
+		if setter != nil {
+			// Decode into a value for the setter, starting from a copy of
+			// the current value of the field if it can be read, which the
+			// field keeps until the setter is called.
+			current := fieldValue
+			fieldValue = reflect.New(setterType).Elem()
+			if current.CanInterface() && current.Type().AssignableTo(setterType) {
+				fieldValue.Set(deepCopy(current, make(map[visitedPtr]reflect.Value)))
+			}
 		}
-	}
 
-	// If we have a "remain"-tagged field and we have unused keys then
-	// we put the unused keys directly into the remain field.
-	if remainField != nil && len(dataValKeysUnused) > 0 {
-		// Build a map of only the unused values
-		remain := map[interface{}]interface{}{}
-		for key := range dataValKeysUnused {
+		d.trace(TraceEnterField, fieldName, rawMapVal.Interface())
+
+		if hasEntryDefault {
+			withDefaults, err := d.applyEntryDefault(fieldName, rawMapVal, fieldValue.Type(), entryDefault)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			rawMapVal = withDefaults
+		}
+
+		// The prior value is copied since decoding may modify the slices,
+		// maps and pointers it holds in place.
+		var prior reflect.Value
+		var changedLen int
+		if d.config.TrackChanges && d.config.Metadata != nil {
+			prior = deepCopy(fieldValue, make(map[visitedPtr]reflect.Value))
+			changedLen = len(d.config.Metadata.Changed)
+		}
+
+		decoded := false
+		var err error
+		if rawUnmarshal && rawMapVal.Interface() != nil && !fieldDecoder.config.DisableUnmarshaler {
+			// Hand the input to the Unmarshaler before any DecodeHook sees it.
+			decoded, err = fieldDecoder.decodeUnmarshaler(fieldName, reflect.ValueOf(rawMapVal.Interface()), fieldValue)
+		}
+		if !decoded {
+			err = fieldDecoder.decodeField(fieldName, rawMapVal.Interface(), fieldValue, field, fieldHook, hookTimeout)
+		}
+		if err != nil {
+			if rawOnFail == "" {
+				errs = append(errs, err)
+				continue
+			}
+
+			// Quarantine the raw input in the sibling field instead of
+			// failing, leaving the typed field at its zero value.
+			rawField, ok := d.findStructField(fields, rawOnFail)
+			if !ok {
+				errs = append(errs, fmt.Errorf("'%s' rawonfail field '%s' does not exist", fieldName, rawOnFail))
+				continue
+			}
+
+			raw := reflect.ValueOf(rawMapVal.Interface())
+			if !rawField.CanSet() || !raw.Type().AssignableTo(rawField.Type()) {
+				errs = append(errs, fmt.Errorf(
+					"'%s' rawonfail field '%s' of type '%s' cannot hold value of type '%s'",
+					fieldName, rawOnFail, rawField.Type(), raw.Type()))
+				continue
+			}
+
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			rawField.Set(raw)
+		}
+
+		if unique {
+			if err := uniqueSlice(fieldName, fieldValue); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if setter != nil && err == nil {
+			if err := setter(fieldValue); err != nil {
+				errs = append(errs, fmt.Errorf("error setting '%s': %w", fieldName, err))
+				continue
+			}
+		}
+
+		// Fields whose nested fields were recorded as changed aren't
+		// recorded themselves.
+		if prior.IsValid() && len(d.config.Metadata.Changed) == changedLen &&
+			!reflect.DeepEqual(prior.Interface(), fieldValue.Interface()) {
+			d.config.Metadata.Changed = append(d.config.Metadata.Changed, fieldName)
+		}
+	}
+
+	for _, pair := range squashedInterfaces {
+		if pair[0].CanSet() {
+			pair[0].Set(pair[1])
+		}
+	}
+
+	for rawFieldName := range rawFieldNames {
+		delete(targetValKeysUnused, rawFieldName)
+	}
+
+	var duplicateKeys []string
+	for rawKey, count := range keyFieldCounts {
+		if count > 1 {
+			duplicateKeys = append(duplicateKeys, fmt.Sprint(rawKey))
+		}
+	}
+	sort.Strings(duplicateKeys)
+
+	if d.config.ErrorDuplicates && len(duplicateKeys) > 0 {
+		errs = append(errs, fmt.Errorf(
+			"'%s' has keys matching multiple fields: %s", name, strings.Join(duplicateKeys, ", ")))
+	}
+
+	for _, conflict := range conflicts {
+		_, ok1 := matchedFieldNames[conflict[0]]
+		_, ok2 := matchedFieldNames[conflict[1]]
+		if ok1 && ok2 {
+			errs = append(errs, fmt.Errorf(
+				"'%s' has conflicting fields: %s, %s", name, conflict[0], conflict[1]))
+		}
+	}
+
+	// Squashed map fields take all the keys that weren't used by other
+	// fields, which means nothing is left over for a "remain" field.
+	if len(squashedMapFields) > 0 && len(dataValKeysUnused) > 0 {
+		unused := map[interface{}]interface{}{}
+		for key := range dataValKeysUnused {
+			unused[key] = dataVal.MapIndex(reflect.ValueOf(key)).Interface()
+		}
+
+		for _, f := range squashedMapFields {
+			if !f.val.CanSet() {
+				continue
+			}
+
+			if err := d.decodeMap(name, unused, f.val); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		dataValKeysUnused = nil
+	}
+
+	// If we have a "remain"-tagged field and we have unused keys then
+	// we put the unused keys directly into the remain field.
+	if remainField != nil && len(dataValKeysUnused) > 0 {
+		// Build a map of only the unused values
+		remain := map[interface{}]interface{}{}
+		for key := range dataValKeysUnused {
 			remain[key] = dataVal.MapIndex(reflect.ValueOf(key)).Interface()
 		}
 
-		// Decode it as-if we were just decoding this map onto our map.
-		if err := d.decodeMap(name, remain, remainField.val); err != nil {
+		remainName := remainField.field.Name
+		if name != "" {
+			remainName = name + "." + remainName
+		}
+
+		// Decode it as-if we were just decoding this map onto our map or
+		// struct. Keys left unused by a struct are reported by it.
+		remainType := remainField.val.Type()
+		if remainType.Kind() == reflect.Ptr {
+			remainType = remainType.Elem()
+		}
+		if remainType.Kind() != reflect.Map && remainType.Kind() != reflect.Struct {
+			errs = append(errs, fmt.Errorf(
+				"'%s' remain field must be a map or struct, got '%s'", remainName, remainField.val.Type()))
+		} else if err := d.decode(remainName, remain, remainField.val); err != nil {
 			errs = append(errs, err)
 		}
 
@@ -1466,6 +3824,32 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 		dataValKeysUnused = nil
 	}
 
+	if (d.config.OnUnused != nil || d.config.Trace != nil) && len(dataValKeysUnused) > 0 {
+		onUnused := d.config.OnUnused
+		keys := make([]string, 0, len(dataValKeysUnused))
+		for rawKey := range dataValKeysUnused {
+			keys = append(keys, rawKey.(string))
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			path := key
+			if name != "" {
+				path = name + "." + key
+			}
+
+			value := dataVal.MapIndex(reflect.ValueOf(key)).Interface()
+			d.trace(TraceUnusedKey, path, value)
+			if onUnused == nil {
+				continue
+			}
+			if err := onUnused(path, value); err != nil {
+				errs = append(errs, fmt.Errorf("unused key '%s': %w", path, err))
+				onUnused = nil
+			}
+		}
+	}
+
 	if d.config.ErrorUnused && len(dataValKeysUnused) > 0 {
 		keys := make([]string, 0, len(dataValKeysUnused))
 		for rawKey := range dataValKeysUnused {
@@ -1473,8 +3857,27 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 		}
 		sort.Strings(keys)
 
-		err := fmt.Errorf("'%s' has invalid keys: %s", name, strings.Join(keys, ", "))
-		errs = append(errs, err)
+		errs = append(errs, &UnusedKeysError{Path: name, Keys: keys})
+	}
+
+	if d.config.OnUnset != nil && len(targetValKeysUnused) > 0 {
+		keys := make([]string, 0, len(targetValKeysUnused))
+		for rawKey := range targetValKeysUnused {
+			keys = append(keys, rawKey.(string))
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			path := key
+			if name != "" {
+				path = name + "." + key
+			}
+
+			if err := d.config.OnUnset(path); err != nil {
+				errs = append(errs, fmt.Errorf("unset field '%s': %w", path, err))
+				break
+			}
+		}
 	}
 
 	if d.config.ErrorUnset && len(targetValKeysUnused) > 0 {
@@ -1484,8 +3887,7 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 		}
 		sort.Strings(keys)
 
-		err := fmt.Errorf("'%s' has unset fields: %s", name, strings.Join(keys, ", "))
-		errs = append(errs, err)
+		errs = append(errs, &UnsetFieldsError{Path: name, Fields: keys})
 	}
 
 	if err := errors.Join(errs...); err != nil {
@@ -1510,11 +3912,432 @@ func (d *Decoder) decodeStructFromMap(name string, dataVal, val reflect.Value) e
 
 			d.config.Metadata.Unset = append(d.config.Metadata.Unset, key)
 		}
+		if d.config.DetectDuplicates {
+			for _, key := range duplicateKeys {
+				if name != "" {
+					key = name + "." + key
+				}
+
+				d.config.Metadata.Duplicates = append(d.config.Metadata.Duplicates, key)
+			}
+		}
 	}
 
 	return nil
 }
 
+// hasStringKeys reports whether all the keys of the map dataVal are strings.
+func hasStringKeys(dataVal reflect.Value) bool {
+	switch dataVal.Type().Key().Kind() {
+	case reflect.String:
+		return true
+	case reflect.Interface:
+		for _, k := range dataVal.MapKeys() {
+			if _, ok := k.Interface().(string); !ok {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// stringifyMapKeys returns a copy of the map dataVal with its keys
+// replaced by their fmt.Sprint form, for matching them against struct
+// field names.
+func stringifyMapKeys(name string, dataVal reflect.Value) (reflect.Value, error) {
+	m := make(map[string]interface{}, dataVal.Len())
+	for _, k := range dataVal.MapKeys() {
+		key := fmt.Sprint(k.Interface())
+		if _, ok := m[key]; ok {
+			return reflect.Value{}, fmt.Errorf(
+				"'%s' has more than one key with the string form '%s'", name, key)
+		}
+		m[key] = dataVal.MapIndex(k).Interface()
+	}
+
+	return reflect.ValueOf(m), nil
+}
+
+// subDecoder returns a decoder for the configuration with the given name in
+// SubConfigs, which shares the state of the current call.
+func (d *decoder) subDecoder(configName string) (*decoder, error) {
+	sub, ok := d.subDecoders[configName]
+	if !ok {
+		return nil, fmt.Errorf("references unknown config '%s'", configName)
+	}
+
+	derived := *sub
+	derived.merge, derived.encode = d.merge, d.encode
+	return &decoder{Decoder: &derived, state: d.state, fromStruct: d.fromStruct}, nil
+}
+
+// fieldSetter returns the function setting the value of the given field of
+// the struct val through its setter, along with the type of the value it
+// takes, or nil if the field has no setter.
+func fieldSetter(val reflect.Value, field reflect.StructField) (func(reflect.Value) error, reflect.Type) {
+	if !val.CanAddr() || !val.Addr().CanInterface() {
+		return nil, nil
+	}
+	ptr := val.Addr()
+
+	first, size := utf8.DecodeRuneInString(field.Name)
+	method := ptr.MethodByName("Set" + string(unicode.ToUpper(first)) + field.Name[size:])
+	if method.IsValid() {
+		typ := method.Type()
+		errorType := reflect.TypeOf((*error)(nil)).Elem()
+		if typ.NumIn() == 1 && (typ.NumOut() == 0 || typ.NumOut() == 1 && typ.Out(0) == errorType) {
+			return func(v reflect.Value) error {
+				out := method.Call([]reflect.Value{v})
+				if len(out) == 0 || out[0].IsNil() {
+					return nil
+				}
+				return out[0].Interface().(error)
+			}, typ.In(0)
+		}
+	}
+
+	if setter, ok := ptr.Interface().(FieldSetter); ok {
+		return func(v reflect.Value) error {
+			return setter.SetField(field.Name, v.Interface())
+		}, field.Type
+	}
+
+	return nil, nil
+}
+
+// withConfig returns a copy of the decoder whose configuration is changed
+// by set, such as for fields with options overriding the configuration.
+// The copy shares the state of the current call.
+func (d *decoder) withConfig(set func(config *DecoderConfig)) *decoder {
+	config := *d.config
+	set(&config)
+
+	derived := *d.Decoder
+	derived.config = &config
+	return &decoder{Decoder: &derived, state: d.state, fromStruct: d.fromStruct}
+}
+
+// applyEntryDefault returns a copy of the map in dataVal where null and
+// empty string values are replaced with entryDefault, decoded into the
+// element type of the map type typ.
+func (d *decoder) applyEntryDefault(name string, dataVal reflect.Value, typ reflect.Type, entryDefault string) (reflect.Value, error) {
+	if typ.Kind() != reflect.Map {
+		return reflect.Value{}, fmt.Errorf("'%s' has entrydefault option but is not a map", name)
+	}
+
+	mapVal := reflect.Indirect(reflect.ValueOf(dataVal.Interface()))
+	if mapVal.Kind() != reflect.Map {
+		// Leave nil and other inputs to the decoding of the field.
+		return dataVal, nil
+	}
+	dataVal = mapVal
+
+	// The default is a string, so decode it weakly while keeping any hook.
+	defaultDecoder := d.withConfig(func(config *DecoderConfig) {
+		config.WeaklyTypedInput = true
+		config.Metadata = nil
+	})
+	defaultVal := reflect.New(typ.Elem()).Elem()
+	if err := defaultDecoder.decode(name, entryDefault, defaultVal); err != nil {
+		return reflect.Value{}, fmt.Errorf("'%s' has invalid entrydefault: %w", name, err)
+	}
+
+	result := make(map[interface{}]interface{}, dataVal.Len())
+	iter := dataVal.MapRange()
+	for iter.Next() {
+		v := iter.Value().Interface()
+		if s, ok := v.(string); v == nil || ok && s == "" {
+			v = defaultVal.Interface()
+		}
+		result[iter.Key().Interface()] = v
+	}
+
+	return reflect.ValueOf(result), nil
+}
+
+// lookupMapKey returns the key of dataVal matching the given field name of
+// the struct at path along with its value, which is invalid if there is no
+// such key. The field name is set with a tag if tagged is true.
+func (d *decoder) lookupMapKey(path string, dataVal reflect.Value, dataValKeys map[reflect.Value]struct{}, fieldName string, tagged bool) (reflect.Value, reflect.Value) {
+	rawMapKey := reflect.ValueOf(fieldName)
+	rawMapVal := dataVal.MapIndex(rawMapKey)
+	if rawMapVal.IsValid() {
+		return rawMapKey, rawMapVal
+	}
+
+	// Do a slower search by iterating over each key and
+	// doing case-insensitive search.
+	for dataValKey := range dataValKeys {
+		mK, ok := dataValKey.Interface().(string)
+		if !ok {
+			// Not a string key
+			continue
+		}
+
+		if d.matchName(path, mK, fieldName) {
+			return dataValKey, dataVal.MapIndex(dataValKey)
+		}
+	}
+
+	// Only then match the keys in the case of KeyCaseTransform, so that
+	// a key matching as is wins.
+	for dataValKey := range dataValKeys {
+		if mK, ok := dataValKey.Interface().(string); ok && d.matchKeyCase(path, mK, fieldName, tagged) {
+			return dataValKey, dataVal.MapIndex(dataValKey)
+		}
+	}
+
+	return rawMapKey, rawMapVal
+}
+
+// matchName reports whether mapKey matches the given field name of the
+// struct at path, using MatchNameWithPath if it is set and MatchName
+// otherwise.
+func (d *decoder) matchName(path, mapKey, fieldName string) bool {
+	if d.config.MatchNameWithPath != nil {
+		return d.config.MatchNameWithPath(path, mapKey, fieldName)
+	}
+	return d.config.MatchName(mapKey, fieldName)
+}
+
+// findStructField returns the value of the field among fields whose key
+// name, taken from the tag or the field name, is name.
+func (d *decoder) findStructField(fields []field, name string) (reflect.Value, bool) {
+	for _, f := range fields {
+		if d.fieldKeyName(f.field) == name {
+			return f.val, true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// resolveFieldName returns the key name of the field among fields that
+// name refers to, either exactly or as a key of the input would match it.
+// Fields that aren't decoded are never found.
+func (d *decoder) resolveFieldName(path string, fields []field, name string) (string, bool) {
+	var keyNames []string
+	for _, f := range fields {
+		tagValue := fieldTag(f.field, d.config)
+		if !d.isFieldIncluded(tagValue) || strings.SplitN(tagValue, ",", 2)[0] == "-" {
+			continue
+		}
+		keyName := d.fieldKeyName(f.field)
+		if keyName == name {
+			return keyName, true
+		}
+		keyNames = append(keyNames, keyName)
+	}
+
+	for _, keyName := range keyNames {
+		if d.matchName(path, name, keyName) {
+			return keyName, true
+		}
+	}
+
+	return "", false
+}
+
+// fieldKeyName returns the name used to look up the given struct field in
+// a map: its tag name if there is one, or its field name otherwise.
+func (d *decoder) fieldKeyName(field reflect.StructField) string {
+	if tagValue := strings.SplitN(fieldTag(field, d.config), ",", 2)[0]; tagValue != "" {
+		return tagValue
+	}
+	return d.stripFieldPrefix(field.Name)
+}
+
+// stripFieldPrefix removes StripFieldPrefix from the given field name,
+// unless that would leave nothing.
+func (d *decoder) stripFieldPrefix(fieldName string) string {
+	if stripped := strings.TrimPrefix(fieldName, d.config.StripFieldPrefix); stripped != "" {
+		return stripped
+	}
+	return fieldName
+}
+
+// isFieldPrefixStripped reports whether StripFieldPrefix was removed from
+// the name of the given field to get its key name.
+func (d *decoder) isFieldPrefixStripped(field reflect.StructField) bool {
+	return strings.SplitN(fieldTag(field, d.config), ",", 2)[0] == "" &&
+		d.stripFieldPrefix(field.Name) != field.Name
+}
+
+// checkStrippedFieldNames returns an error if stripping StripFieldPrefix
+// made the names of two fields match each other.
+func (d *decoder) checkStrippedFieldNames(name string, fields []field) error {
+	for i := range fields {
+		iName := d.fieldKeyName(fields[i].field)
+		if iName == "-" {
+			continue
+		}
+
+		for j := i + 1; j < len(fields); j++ {
+			jName := d.fieldKeyName(fields[j].field)
+			if jName == "-" {
+				continue
+			}
+
+			stripped := d.isFieldPrefixStripped(fields[i].field) || d.isFieldPrefixStripped(fields[j].field)
+			if stripped && d.matchName(name, iName, jName) {
+				return fmt.Errorf(
+					"'%s' fields %s and %s collide after stripping prefix '%s'",
+					name, fields[i].field.Name, fields[j].field.Name, d.config.StripFieldPrefix)
+			}
+		}
+	}
+
+	return nil
+}
+
+// trackCoercion records the conversion of from into to in the metadata if
+// TrackCoercions is set and their kinds differ.
+func (d *decoder) trackCoercion(name string, from reflect.Value, to reflect.Value) {
+	if !d.config.TrackCoercions || d.config.Metadata == nil {
+		return
+	}
+
+	// json.Number is a numeric type in disguise, so decoding it into a
+	// number is not a coercion.
+	if getKind(from) == getKind(to) || from.Type() == reflect.TypeOf(json.Number("")) {
+		return
+	}
+
+	d.config.Metadata.Coercions = append(d.config.Metadata.Coercions, Coercion{
+		Path: name,
+		From: from.Kind(),
+		To:   to.Kind(),
+	})
+}
+
+// isFieldIncluded reports whether a struct field with the given tag value
+// takes part in decoding according to IgnoreUntaggedFields and
+// OnlyIncludedFields.
+func (d *decoder) isFieldIncluded(tagValue string) bool {
+	if tagValue == "" {
+		return !d.config.IgnoreUntaggedFields && !d.config.OnlyIncludedFields
+	}
+
+	if !d.config.OnlyIncludedFields {
+		return true
+	}
+
+	for _, tag := range strings.Split(tagValue, ",")[1:] {
+		switch tag {
+		case "include", "squash", "remain":
+			return true
+		}
+	}
+
+	return false
+}
+
+// field is a struct field along with the value it holds in the struct
+// being decoded.
+type field struct {
+	field reflect.StructField
+	val   reflect.Value
+}
+
+// hasTagOption reports whether the tag value has the given option.
+func hasTagOption(tagValue, option string) bool {
+	for _, tag := range strings.Split(tagValue, ",")[1:] {
+		if tag == option {
+			return true
+		}
+	}
+	return false
+}
+
+// structVersionAtLeast reports whether the value of the version field of a
+// struct, already decoded, is at least minVersion.
+func structVersionAtLeast(versionField *field, minVersion int64) (bool, error) {
+	if versionField == nil {
+		return false, errors.New("has fields with a minversion option but no version field")
+	}
+
+	switch v := versionField.val; getKind(v) {
+	case reflect.Int:
+		return v.Int() >= minVersion, nil
+	case reflect.Uint:
+		return minVersion <= 0 || v.Uint() >= uint64(minVersion), nil
+	default:
+		return false, fmt.Errorf(
+			"version field '%s' must be an integer, got '%s'", versionField.field.Name, v.Type())
+	}
+}
+
+// uniqueSlice removes the duplicate elements of the slice val, keeping the
+// first occurrence of each, for fields with the "unique" tag option.
+func uniqueSlice(name string, val reflect.Value) error {
+	if val.Kind() != reflect.Slice {
+		return fmt.Errorf("'%s' unique option requires a slice, got '%s'", name, val.Type())
+	}
+	if elemType := val.Type().Elem(); !elemType.Comparable() {
+		return fmt.Errorf("'%s' unique option requires comparable elements, got '%s'", name, elemType)
+	}
+
+	seen := make(map[interface{}]struct{}, val.Len())
+	result := reflect.MakeSlice(val.Type(), 0, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		elem := val.Index(i)
+
+		// Interface types are comparable, but the values they hold may
+		// not be.
+		if elem.Kind() == reflect.Interface && !elem.IsNil() && !isComparable(elem) {
+			return fmt.Errorf(
+				"'%s[%d]' unique option requires comparable elements, got '%s'",
+				name, i, reflect.ValueOf(elem.Interface()).Type())
+		}
+
+		key := elem.Interface()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = reflect.Append(result, elem)
+	}
+
+	if result.Len() < val.Len() {
+		val.Set(result)
+	}
+	return nil
+}
+
+// settableField returns a settable value for the addressable but
+// unexported struct field v, bypassing the restrictions of the reflect
+// package with the unsafe package.
+func settableField(v reflect.Value) reflect.Value {
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+// copyInterfaceValue returns a copy of the concrete value held by the
+// non-nil interface value iface. If the concrete value is a non-nil
+// pointer, the value it points to is copied as well.
+func copyInterfaceValue(iface reflect.Value) reflect.Value {
+	elem := iface.Elem()
+	if elem.Kind() == reflect.Ptr && !elem.IsNil() {
+		ptr := reflect.New(elem.Type().Elem())
+		ptr.Elem().Set(elem.Elem())
+		return ptr
+	}
+
+	copied := reflect.New(elem.Type()).Elem()
+	copied.Set(elem)
+	return copied
+}
+
+// isNillable reports whether v is of a kind that can be set to nil.
+func isNillable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch getKind(v) {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
@@ -1533,6 +4356,91 @@ func isEmptyValue(v reflect.Value) bool {
 	return false
 }
 
+// isZeroValue reports whether v is the zero value of its type, using its
+// IsZero method if it has one.
+func isZeroValue(v reflect.Value) bool {
+	if z, ok := v.Interface().(interface{ IsZero() bool }); ok {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return true
+		}
+		return z.IsZero()
+	}
+	return v.IsZero()
+}
+
+// deepCopy returns a copy of v that shares no memory with it, not even
+// through slices, maps or pointers. The copies of the pointers already
+// copied are kept in copies, so that cycles are copied as cycles.
+func deepCopy(v reflect.Value, copies map[visitedPtr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		key := visitedPtr{v.Pointer(), v.Type()}
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copies[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), copies))
+		return c
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), copies))
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copies))
+		}
+		return c
+
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value(), copies))
+		}
+		return c
+
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copies))
+		}
+		return c
+
+	case reflect.Struct:
+		// Unexported fields can't be set through reflection, so they are
+		// copied as is along with the struct.
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), copies))
+			}
+		}
+		return c
+
+	default:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		return c
+	}
+}
+
 func getKind(val reflect.Value) reflect.Kind {
 	kind := val.Kind()
 
@@ -1550,26 +4458,26 @@ func getKind(val reflect.Value) reflect.Kind {
 	}
 }
 
-func isStructTypeConvertibleToMap(typ reflect.Type, checkMapstructureTags bool, tagName string) bool {
+func isStructTypeConvertibleToMap(typ reflect.Type, checkMapstructureTags bool, config *DecoderConfig) bool {
 	for i := 0; i < typ.NumField(); i++ {
 		f := typ.Field(i)
 		if f.PkgPath == "" && !checkMapstructureTags { // check for unexported fields
 			return true
 		}
-		if checkMapstructureTags && f.Tag.Get(tagName) != "" { // check for mapstructure tags inside
+		if checkMapstructureTags && fieldTag(f, config) != "" { // check for mapstructure tags inside
 			return true
 		}
 	}
 	return false
 }
 
-func dereferencePtrToStructIfNeeded(v reflect.Value, tagName string) reflect.Value {
+func dereferencePtrToStructIfNeeded(v reflect.Value, config *DecoderConfig) reflect.Value {
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
 		return v
 	}
 	deref := v.Elem()
 	derefT := deref.Type()
-	if isStructTypeConvertibleToMap(derefT, true, tagName) {
+	if isStructTypeConvertibleToMap(derefT, true, config) {
 		return deref
 	}
 	return v
@@ -2,6 +2,8 @@ package mapstructure
 
 import (
 	"encoding/json"
+	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -309,3 +311,177 @@ func Benchmark_DecodeWithRemainingFields(b *testing.B) {
 		_ = Decode(&person, &result)
 	}
 }
+
+func Benchmark_DecodeStructToStruct(b *testing.B) {
+	type Address struct {
+		Street string
+		City   string
+	}
+	type Source struct {
+		Name    string
+		Age     int
+		Emails  []string
+		Address Address
+	}
+	type Target struct {
+		Name    string
+		Age     int
+		Emails  []string
+		Address Address
+	}
+
+	input := Source{
+		Name:    "Mitchell",
+		Age:     91,
+		Emails:  []string{"one", "two", "three"},
+		Address: Address{Street: "Main", City: "Springfield"},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var result Target
+		_ = Decode(input, &result)
+	}
+}
+
+func Benchmark_DecodeInternStrings(b *testing.B) {
+	type Record struct {
+		ID     int
+		Status string
+	}
+
+	// Each status is a distinct copy, as a parser would produce, so that
+	// the heap retained by the result shows the effect of interning.
+	statuses := []string{"active", "pending", "suspended"}
+	newInput := func() []interface{} {
+		input := make([]interface{}, 10000)
+		for i := range input {
+			status := statuses[i%len(statuses)] + " account status"
+			input[i] = map[string]interface{}{"id": i, "status": string([]byte(status))}
+		}
+		return input
+	}
+
+	for _, intern := range []bool{false, true} {
+		name := "plain"
+		if intern {
+			name = "interned"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			var retained uint64
+			var stats runtime.MemStats
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				input := newInput()
+				b.StartTimer()
+
+				var result []Record
+				decoder, _ := NewDecoder(&DecoderConfig{InternStrings: intern, Result: &result})
+				decoder.Decode(input)
+
+				b.StopTimer()
+				input = nil
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				before := stats.HeapAlloc
+				result = nil
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				if before > stats.HeapAlloc {
+					retained += before - stats.HeapAlloc
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
+
+func Benchmark_DecodeTypedHooks(b *testing.B) {
+	type Target struct {
+		A, B, C, D string
+		E, F, G, H int
+	}
+
+	input := map[string]interface{}{
+		"a": "1", "b": "2", "c": "3", "d": "4",
+		"e": 5, "f": 6, "g": 7, "h": 8,
+	}
+
+	// Hooks for many types, none of which is decoded into, as in a large
+	// application that registers hooks for all its types.
+	var chain []DecodeHookFunc
+	hooks := map[reflect.Type]DecodeHookFunc{}
+	for i := 0; i < 20; i++ {
+		typ := reflect.ArrayOf(i+1, reflect.TypeOf(""))
+		hook := func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+			return data, nil
+		}
+		chain = append(chain, TypedHook(typ, hook))
+		hooks[typ] = hook
+	}
+
+	b.Run("composed", func(b *testing.B) {
+		var result Target
+		decoder, _ := NewDecoder(&DecoderConfig{DecodeHook: ComposeDecodeHookFunc(chain...), Result: &result})
+		for i := 0; i < b.N; i++ {
+			decoder.Decode(input)
+		}
+	})
+
+	b.Run("typed", func(b *testing.B) {
+		var result Target
+		decoder, _ := NewDecoder(&DecoderConfig{TypedHooks: hooks, Result: &result})
+		for i := 0; i < b.N; i++ {
+			decoder.Decode(input)
+		}
+	})
+}
+
+type benchmarkLarge struct {
+	Values [64]int
+}
+
+// benchmarkPresets are looked up by name by the hooks of
+// benchmarkDecodeHook.
+var benchmarkPresets = map[string]*benchmarkLarge{"value": {}}
+
+func Benchmark_DecodeHookFuncType(b *testing.B) {
+	hook := func(f, t reflect.Type, data interface{}) (interface{}, error) {
+		if t != reflect.TypeOf(benchmarkLarge{}) {
+			return data, nil
+		}
+		return *benchmarkPresets[data.(string)], nil
+	}
+	benchmarkDecodeHook(b, hook)
+}
+
+func Benchmark_DecodeHookFuncReflect(b *testing.B) {
+	hook := func(f, t reflect.Type, data reflect.Value) (reflect.Value, error) {
+		if t != reflect.TypeOf(benchmarkLarge{}) {
+			return data, nil
+		}
+		return reflect.ValueOf(benchmarkPresets[data.String()]).Elem(), nil
+	}
+	benchmarkDecodeHook(b, hook)
+}
+
+// benchmarkDecodeHook decodes a struct with a large field, which hook
+// looks up from a string.
+func benchmarkDecodeHook(b *testing.B, hook DecodeHookFunc) {
+	var result struct {
+		Large benchmarkLarge
+	}
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: hook, Result: &result})
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	input := map[string]interface{}{"large": "value"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decoder.Decode(input)
+	}
+}
@@ -63,7 +63,7 @@ func ExampleDecode_errors() {
 
 	fmt.Println(err.Error())
 	// Output:
-	// decoding failed due to the following error(s):
+	// decoding into 'mapstructure.Person' failed due to the following error(s):
 	//
 	// 'Name' expected type 'string', got unconvertible type 'int', value: '123'
 	// 'Age' expected type 'int', got unconvertible type 'string', value: 'bad value'
@@ -3,12 +3,16 @@ package mapstructure
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode"
+	"unsafe"
 )
 
 type Basic struct {
@@ -441,6 +445,110 @@ func TestBasic_interfaceStructNonPtr(t *testing.T) {
 	}
 }
 
+func TestDecode_JSONNumber(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Int     int64
+		Int8    int8
+		Uint    uint64
+		Float32 float32
+	}
+
+	cases := []struct {
+		name   string
+		input  interface{}
+		result Target
+		err    string
+	}{
+		{
+			"large integers are exact",
+			map[string]interface{}{
+				"int":  json.Number("9007199254740993"),
+				"uint": json.Number("18446744073709551615"),
+			},
+			Target{Int: 9007199254740993, Uint: 18446744073709551615},
+			"",
+		},
+		{
+			"whole numbers with exponent or fraction",
+			map[string]interface{}{
+				"int":  json.Number("1e3"),
+				"int8": json.Number("-12.0"),
+				"uint": json.Number("9.007199254740993e15"),
+			},
+			Target{Int: 1000, Int8: -12, Uint: 9007199254740993},
+			"",
+		},
+		{
+			"pointer",
+			map[string]interface{}{
+				"int": func() *json.Number { n := json.Number("42"); return &n }(),
+			},
+			Target{Int: 42},
+			"",
+		},
+		{
+			"int overflow",
+			map[string]interface{}{"int8": json.Number("128")},
+			Target{},
+			"error decoding json.Number into Int8",
+		},
+		{
+			"uint overflow",
+			map[string]interface{}{"uint": json.Number("18446744073709551616")},
+			Target{},
+			"error decoding json.Number into Uint",
+		},
+		{
+			"negative uint",
+			map[string]interface{}{"uint": json.Number("-1")},
+			Target{},
+			"error decoding json.Number into Uint",
+		},
+		{
+			"fraction",
+			map[string]interface{}{"int": json.Number("1.5")},
+			Target{},
+			"error decoding json.Number into Int",
+		},
+		{
+			"huge exponent",
+			map[string]interface{}{"int": json.Number("1e1000000000")},
+			Target{},
+			"error decoding json.Number into Int",
+		},
+		{
+			"float overflow",
+			map[string]interface{}{"float32": json.Number("1e39")},
+			Target{},
+			"error decoding json.Number into Float32",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var result Target
+			err := Decode(tc.input, &result)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an err: %s", err)
+			}
+			if !reflect.DeepEqual(tc.result, result) {
+				t.Fatalf("expected %#v, got %#v", tc.result, result)
+			}
+		})
+	}
+}
+
 func TestDecode_BasicSquash(t *testing.T) {
 	t.Parallel()
 
@@ -489,6 +597,74 @@ func TestDecodeFrom_BasicSquash(t *testing.T) {
 	}
 }
 
+func TestDecode_SquashMap(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Name   string
+		Labels map[string]string `mapstructure:",squash"`
+	}
+
+	input := map[string]interface{}{
+		"name": "foo",
+		"env":  "prod",
+		"team": "core",
+	}
+
+	var result Target
+	config := &DecoderConfig{
+		ErrorUnused: true,
+		Result:      &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Target{
+		Name:   "foo",
+		Labels: map[string]string{"env": "prod", "team": "core"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
+	}
+}
+
+func TestDecodeFrom_SquashMap(t *testing.T) {
+	t.Parallel()
+
+	type Source struct {
+		Name   string
+		Labels map[string]interface{} `mapstructure:",squash"`
+	}
+
+	input := Source{
+		Name: "foo",
+		Labels: map[string]interface{}{
+			"env":  "prod",
+			"Name": "shadowed",
+		},
+	}
+
+	var result map[string]interface{}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"Name": "foo",
+		"env":  "prod",
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
+	}
+}
+
 func TestDecode_Embedded(t *testing.T) {
 	t.Parallel()
 
@@ -1064,6 +1240,64 @@ func TestDecode_DecodeHookType(t *testing.T) {
 	}
 }
 
+func TestDecode_DecodeHookReflect(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		Name string
+	}
+	type Target struct {
+		Inner Inner
+		Array [2]int
+		Vint  int
+	}
+
+	input := map[string]interface{}{
+		"inner": "foo",
+		"array": "bar",
+		"vint":  "baz",
+	}
+
+	decodeHook := func(from reflect.Type, to reflect.Type, v reflect.Value) (reflect.Value, error) {
+		if from.Kind() != reflect.String {
+			return v, nil
+		}
+
+		// Return addressable values, as a hook building them would.
+		out := reflect.New(to).Elem()
+		switch to.Kind() {
+		case reflect.Struct:
+			out.Field(0).SetString(v.String())
+		case reflect.Array:
+			out.Index(1).SetInt(int64(v.Len()))
+		case reflect.Int:
+			out.SetInt(5)
+		}
+		return out, nil
+	}
+
+	var result Target
+	config := &DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Target{Inner: Inner{Name: "foo"}, Array: [2]int{0, 3}, Vint: 5}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+}
+
 func TestDecode_Nil(t *testing.T) {
 	t.Parallel()
 
@@ -1191,6 +1425,262 @@ func TestDecode_FuncHook(t *testing.T) {
 	}
 }
 
+func TestDecode_UnsupportedKinds(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Name    string
+		Func    func() string
+		Chan    chan int
+		Pointer unsafe.Pointer
+		Skipped chan int `mapstructure:"-"`
+	}
+
+	cases := []struct {
+		input    map[string]interface{}
+		expected string
+	}{
+		{
+			map[string]interface{}{"func": "foo"},
+			"'Func' is a func of type 'func() string' and can only be decoded from the same type, got 'string'",
+		},
+		{
+			map[string]interface{}{"chan": 1},
+			"'Chan' has unsupported kind 'chan', use the tag `mapstructure:\"-\"` to skip it",
+		},
+		{
+			map[string]interface{}{"pointer": 1},
+			"'Pointer' has unsupported kind 'unsafe.Pointer', use the tag `mapstructure:\"-\"` to skip it",
+		},
+	}
+
+	for i, tc := range cases {
+		var result Target
+		err := Decode(tc.input, &result)
+		if err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("case %d: unexpected error: %s", i, err)
+		}
+	}
+
+	var result Target
+	input := map[string]interface{}{
+		"name": "foo",
+		"-":    1,
+	}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if result.Name != "foo" || result.Skipped != nil {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+}
+
+func TestDecode_DecodeHookFuncField(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		User     string
+		Password string   `redact:"true"`
+		Tokens   []string `redact:"true"`
+	}
+
+	var fieldNames []string
+	redact := func(from reflect.Value, to reflect.Value, field reflect.StructField) (interface{}, error) {
+		fieldNames = append(fieldNames, field.Name)
+		if field.Tag.Get("redact") == "true" && from.Kind() == reflect.String {
+			return "***", nil
+		}
+		return from.Interface(), nil
+	}
+
+	input := map[string]interface{}{
+		"user":     "admin",
+		"password": "secret",
+		"tokens":   []string{"a", "b"},
+	}
+
+	for _, hook := range []DecodeHookFunc{
+		DecodeHookFuncField(redact),
+		redact,
+		ComposeDecodeHookFunc(StringToTimeDurationHookFunc(), redact),
+	} {
+		fieldNames = nil
+
+		var result Target
+		config := &DecoderConfig{
+			DecodeHook: hook,
+			Result:     &result,
+		}
+
+		decoder, err := NewDecoder(config)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := decoder.Decode(input); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		expected := Target{
+			User:     "admin",
+			Password: "***",
+			Tokens:   []string{"a", "b"},
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("expected %#v, got %#v", expected, result)
+		}
+
+		// Only struct fields carry a field, not the result nor the
+		// elements of the slice.
+		sort.Strings(fieldNames)
+		if !reflect.DeepEqual([]string{"", "", "", "Password", "Tokens", "User"}, fieldNames) {
+			t.Fatalf("bad field names: %#v", fieldNames)
+		}
+	}
+}
+
+func TestDecode_HookTimeout(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Fast string `mapstructure:"fast,timeout=1s"`
+		Slow string `mapstructure:"slow,timeout=10ms"`
+	}
+
+	decodeHook := func(f, t reflect.Type, v interface{}) (interface{}, error) {
+		if v == "slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return v, nil
+	}
+
+	var result Target
+	config := &DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"fast": "fast"})
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if result.Fast != "fast" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"slow": "slow"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "error decoding 'slow': decode hook exceeded timeout of 10ms") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Slow != "" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+}
+
+func TestDecode_HookTimeoutMap(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Labels map[string]string `mapstructure:"labels,timeout=10ms"`
+	}
+
+	labels := map[string]string{"env": "prod"}
+	release := make(chan struct{})
+	shared := make(chan bool, 1)
+	decodeHook := func(from, to reflect.Value) (interface{}, error) {
+		if to.Kind() != reflect.Map {
+			return from.Interface(), nil
+		}
+		<-release
+		shared <- to.Pointer() == reflect.ValueOf(labels).Pointer()
+		return from.Interface(), nil
+	}
+
+	result := Target{Labels: labels}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{
+		"labels": map[string]interface{}{"env": "dev"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "error decoding 'labels': decode hook exceeded timeout of 10ms") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if !reflect.DeepEqual(map[string]string{"env": "prod"}, result.Labels) {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+
+	// The abandoned hook is still running, and its target holds the same
+	// map as the field.
+	close(release)
+	if !<-shared {
+		t.Fatal("expected the hook to share the map of the field")
+	}
+}
+
+func TestDecode_HookTimeoutPanic(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Value string `mapstructure:"value,timeout=1s"`
+	}
+
+	decodeHook := func(f, t reflect.Type, v interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	input := map[string]interface{}{"value": "x"}
+
+	// The panic is recovered like one in a hook without a timeout.
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook:        decodeHook,
+		RecoverFromPanics: true,
+		Result:            &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode(input)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+
+	// Without RecoverFromPanics, the panic reaches the caller of Decode
+	// rather than crashing the program from the goroutine of the hook.
+	decoder, err = NewDecoder(&DecoderConfig{
+		DecodeHook: decodeHook,
+		Result:     &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected the panic of the hook, got %v", r)
+			}
+		}()
+		_ = decoder.Decode(input)
+	}()
+}
+
 func TestDecode_NonStruct(t *testing.T) {
 	t.Parallel()
 
@@ -1230,20 +1720,97 @@ func TestDecode_StructMatch(t *testing.T) {
 	}
 }
 
-func TestDecode_TypeConversion(t *testing.T) {
-	input := map[string]interface{}{
-		"IntToFloat":         42,
-		"IntToUint":          42,
-		"IntToBool":          1,
-		"IntToString":        42,
-		"UintToInt":          42,
-		"UintToFloat":        42,
-		"UintToBool":         42,
-		"UintToString":       42,
-		"BoolToInt":          true,
-		"BoolToUint":         true,
-		"BoolToFloat":        true,
-		"BoolToString":       true,
+func TestDecode_WeaklyTypedInputMatrix(t *testing.T) {
+	t.Parallel()
+
+	sources := []struct {
+		name  string
+		value interface{}
+	}{
+		{"true", true},
+		{"false", false},
+		{"int", -7},
+		{"zero int", 0},
+		{"uint", uint(7)},
+		{"float", 7.5},
+		{"zero float", 0.0},
+		{"string", "7"},
+		{"bool string", "true"},
+		{"empty string", ""},
+	}
+
+	// expected has, for each source, the result of decoding it into a
+	// bool, int, uint, float64 and string. A nil entry means an error.
+	expected := map[string][5]interface{}{
+		"true":         {true, 1, uint(1), 1.0, "1"},
+		"false":        {false, 0, uint(0), 0.0, "0"},
+		"int":          {true, -7, ^uint(6), -7.0, "-7"},
+		"zero int":     {false, 0, uint(0), 0.0, "0"},
+		"uint":         {true, 7, uint(7), 7.0, "7"},
+		"float":        {true, 7, uint(7), 7.5, "7.5"},
+		"zero float":   {false, 0, uint(0), 0.0, "0"},
+		"string":       {nil, 7, uint(7), 7.0, "7"},
+		"bool string":  {true, nil, nil, nil, "true"},
+		"empty string": {false, 0, uint(0), 0.0, ""},
+	}
+
+	for _, source := range sources {
+		var results [5]interface{}
+		targets := []interface{}{new(bool), new(int), new(uint), new(float64), new(string)}
+		for i, target := range targets {
+			if err := WeakDecode(source.value, target); err == nil {
+				results[i] = reflect.ValueOf(target).Elem().Interface()
+			}
+		}
+
+		if !reflect.DeepEqual(expected[source.name], results) {
+			t.Errorf("%s: expected %#v, got %#v", source.name, expected[source.name], results)
+		}
+	}
+}
+
+func TestDecode_WeaklyTypedBoolWords(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		words    bool
+		expected [2]string
+	}{
+		{false, [2]string{"1", "0"}},
+		{true, [2]string{"true", "false"}},
+	} {
+		var result [2]string
+		decoder, err := NewDecoder(&DecoderConfig{
+			WeaklyTypedInput:     true,
+			WeaklyTypedBoolWords: tc.words,
+			Result:               &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := decoder.Decode([]interface{}{true, false}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result != tc.expected {
+			t.Fatalf("words %v: expected %#v, got %#v", tc.words, tc.expected, result)
+		}
+	}
+}
+
+func TestDecode_TypeConversion(t *testing.T) {
+	input := map[string]interface{}{
+		"IntToFloat":         42,
+		"IntToUint":          42,
+		"IntToBool":          1,
+		"IntToString":        42,
+		"UintToInt":          42,
+		"UintToFloat":        42,
+		"UintToBool":         42,
+		"UintToString":       42,
+		"BoolToInt":          true,
+		"BoolToUint":         true,
+		"BoolToFloat":        true,
+		"BoolToString":       true,
 		"FloatToInt":         42.42,
 		"FloatToUint":        42.42,
 		"FloatToBool":        42.42,
@@ -1420,717 +1987,1805 @@ func TestDecoder_ErrorUnset(t *testing.T) {
 	}
 }
 
-func TestMap(t *testing.T) {
+func TestDecoder_ErrorUnsetWithRemain(t *testing.T) {
 	t.Parallel()
 
+	type Config struct {
+		Name  string
+		Port  int
+		Extra map[string]interface{} `mapstructure:",remain"`
+	}
+
 	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vother": map[interface{}]interface{}{
-			"foo": "foo",
-			"bar": "bar",
-		},
+		"name":    "api",
+		"timeout": "5s",
+		"debug":   true,
+	}
+
+	for _, config := range []DecoderConfig{
+		{ErrorUnset: true},
+		{Strict: true},
+	} {
+		var result Config
+		config.Result = &result
+		decoder, err := NewDecoder(&config)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		err = decoder.Decode(input)
+		var unsetErr *UnsetFieldsError
+		if !errors.As(err, &unsetErr) || !reflect.DeepEqual(unsetErr.Fields, []string{"Port"}) {
+			t.Fatalf("expected Port to be unset, got %v", err)
+		}
+		if strings.Contains(err.Error(), "Extra") || strings.Contains(err.Error(), "invalid keys") {
+			t.Fatalf("expected the extra keys to be captured, got %s", err)
+		}
+
+		expected := Config{
+			Name:  "api",
+			Extra: map[string]interface{}{"timeout": "5s", "debug": true},
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("expected %#v, got %#v", expected, result)
+		}
 	}
 
-	var result Map
-	err := Decode(input, &result)
+	// Without extra keys the remain field isn't reported either.
+	var result Config
+	decoder, err := NewDecoder(&DecoderConfig{ErrorUnset: true, Result: &result})
 	if err != nil {
-		t.Fatalf("got an error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
-
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	if err := decoder.Decode(map[string]interface{}{"name": "api", "port": 80}); err != nil {
+		t.Fatalf("err: %s", err)
 	}
+}
 
-	if result.Vother == nil {
-		t.Fatal("vother should not be nil")
-	}
+func TestDecoder_ConflictsWith(t *testing.T) {
+	t.Parallel()
 
-	if len(result.Vother) != 2 {
-		t.Error("vother should have two items")
+	type Source struct {
+		File   string `mapstructure:"file,conflictswith=inline"`
+		Inline string `mapstructure:"inline"`
 	}
 
-	if result.Vother["foo"] != "foo" {
-		t.Errorf("'foo' key should be foo, got: %#v", result.Vother["foo"])
+	cases := []struct {
+		input map[string]interface{}
+		err   bool
+	}{
+		{map[string]interface{}{"file": "a.txt"}, false},
+		{map[string]interface{}{"inline": "data"}, false},
+		{map[string]interface{}{}, false},
+		{map[string]interface{}{"file": "a.txt", "inline": "data"}, true},
 	}
 
-	if result.Vother["bar"] != "bar" {
-		t.Errorf("'bar' key should be bar, got: %#v", result.Vother["bar"])
+	for i, tc := range cases {
+		var result Source
+		err := Decode(tc.input, &result)
+		if tc.err != (err != nil) {
+			t.Fatalf("case %d: unexpected err: %v", i, err)
+		}
+		if tc.err && !strings.Contains(err.Error(), "has conflicting fields: file, inline") {
+			t.Fatalf("case %d: unexpected err: %s", i, err)
+		}
 	}
-}
 
-func TestMapMerge(t *testing.T) {
-	t.Parallel()
-
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vother": map[interface{}]interface{}{
-			"foo": "foo",
-			"bar": "bar",
-		},
+	// The other field is matched like a key, so an untagged field can be
+	// referred to by its lowercased name.
+	type Untagged struct {
+		File   string `mapstructure:"file,conflictswith=inline"`
+		Inline string
 	}
 
-	var result Map
-	result.Vother = map[string]string{"hello": "world"}
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an error: %s", err)
+	var untagged Untagged
+	err := Decode(map[string]interface{}{"file": "a.txt", "inline": "data"}, &untagged)
+	if err == nil || !strings.Contains(err.Error(), "has conflicting fields: file, Inline") {
+		t.Fatalf("expected a conflict error, got %v", err)
 	}
 
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	type Unknown struct {
+		File   string `mapstructure:"file,conflictswith=zzz"`
+		Inline string `mapstructure:"inline"`
 	}
 
-	expected := map[string]string{
-		"foo":   "foo",
-		"bar":   "bar",
-		"hello": "world",
-	}
-	if !reflect.DeepEqual(result.Vother, expected) {
-		t.Errorf("bad: %#v", result.Vother)
+	var unknown Unknown
+	err = Decode(map[string]interface{}{"file": "a.txt"}, &unknown)
+	if err == nil || !strings.Contains(err.Error(), "'file' conflicts with unknown field 'zzz'") {
+		t.Fatalf("expected an unknown field error, got %v", err)
 	}
 }
 
-func TestMapOfStruct(t *testing.T) {
+func TestDecoder_RawOnFail(t *testing.T) {
 	t.Parallel()
 
+	type Source struct {
+		Name    string
+		Port    int         `mapstructure:"port,rawonfail=port_raw"`
+		PortRaw interface{} `mapstructure:"port_raw"`
+	}
+
 	input := map[string]interface{}{
-		"value": map[string]interface{}{
-			"foo": map[string]string{"vstring": "one"},
-			"bar": map[string]string{"vstring": "two"},
-		},
+		"name": "foo",
+		"port": "not a number",
 	}
 
-	var result MapOfStruct
-	err := Decode(input, &result)
+	var result Source
+	config := &DecoderConfig{
+		ErrorUnset: true,
+		Result:     &result,
+	}
+
+	decoder, err := NewDecoder(config)
 	if err != nil {
-		t.Fatalf("got an err: %s", err)
+		t.Fatalf("err: %s", err)
 	}
 
-	if result.Value == nil {
-		t.Fatal("value should not be nil")
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if len(result.Value) != 2 {
-		t.Error("value should have two items")
+	expected := Source{Name: "foo", PortRaw: "not a number"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
 	}
 
-	if result.Value["foo"].Vstring != "one" {
-		t.Errorf("foo value should be 'one', got: %s", result.Value["foo"].Vstring)
+	result = Source{}
+	if err := Decode(map[string]interface{}{"port": 8080}, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Value["bar"].Vstring != "two" {
-		t.Errorf("bar value should be 'two', got: %s", result.Value["bar"].Vstring)
+	expected = Source{Port: 8080}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
 	}
 }
 
-func TestNestedType(t *testing.T) {
+func TestDecode_PrimaryField(t *testing.T) {
 	t.Parallel()
 
+	type Port struct {
+		Value int `mapstructure:",primary"`
+		Raw   string
+	}
+	type Target struct {
+		Ports []Port
+	}
+
 	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": map[string]interface{}{
-			"vstring": "foo",
-			"vint":    42,
-			"vbool":   true,
+		"ports": []interface{}{
+			8080,
+			map[string]interface{}{"value": 9090, "raw": "9090/tcp"},
 		},
 	}
 
-	var result Nested
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an err: %s", err.Error())
+	var result Target
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	expected := Target{
+		Ports: []Port{
+			{Value: 8080},
+			{Value: 9090, Raw: "9090/tcp"},
+		},
 	}
-
-	if result.Vbar.Vstring != "foo" {
-		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
 	}
+}
 
-	if result.Vbar.Vint != 42 {
-		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
-	}
+func TestDecode_PrimaryFieldMultiple(t *testing.T) {
+	t.Parallel()
 
-	if result.Vbar.Vbool != true {
-		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
+	type Port struct {
+		Value int    `mapstructure:",primary"`
+		Raw   string `mapstructure:",primary"`
+	}
+	type Target struct {
+		Port *Port
 	}
 
-	if result.Vbar.Vextra != "" {
-		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	var result Target
+	_, err := NewDecoder(&DecoderConfig{Result: &result})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "has multiple primary fields: Value, Raw") {
+		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
-func TestNestedTypePointer(t *testing.T) {
+func TestDecode_PositionalFields(t *testing.T) {
 	t.Parallel()
 
+	type Server struct {
+		Env   string `mapstructure:",pos=0"`
+		Port  int    `mapstructure:",pos=1"`
+		TLS   bool   `mapstructure:"tls,pos=2"`
+		Notes string
+	}
+	type Target struct {
+		Servers []Server
+	}
+
 	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": &map[string]interface{}{
-			"vstring": "foo",
-			"vint":    42,
-			"vbool":   true,
+		"servers": []interface{}{
+			[]interface{}{"prod", 8080, true},
+			[2]interface{}{"dev", 3000},
 		},
 	}
 
-	var result NestedPointer
+	var result Target
 	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an err: %s", err.Error())
+	if err == nil {
+		t.Fatal("expected error")
 	}
-
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	if !strings.Contains(err.Error(), "'Servers[1].tls' position 2 is out of range for 2 elements") {
+		t.Fatalf("unexpected error: %s", err)
 	}
 
-	if result.Vbar.Vstring != "foo" {
-		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
+	input["servers"] = []interface{}{[]interface{}{"prod", 8080, true, "ignored"}}
+	result = Target{}
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Vbar.Vint != 42 {
-		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
+	expected := Target{Servers: []Server{{Env: "prod", Port: 8080, TLS: true}}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
 	}
 
-	if result.Vbar.Vbool != true {
-		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
+	decoder, err := NewDecoder(&DecoderConfig{ErrorUnused: true, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
-
-	if result.Vbar.Vextra != "" {
-		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'Servers[0]' has unused elements at positions: 3") {
+		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
-// Test for issue #46.
-func TestNestedTypeInterface(t *testing.T) {
+func TestDecode_SlicePositional(t *testing.T) {
 	t.Parallel()
 
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": &map[string]interface{}{
-			"vstring": "foo",
-			"vint":    42,
-			"vbool":   true,
+	type Server struct {
+		Env     string
+		Port    int
+		TLS     bool
+		ignored string
+		Skipped string `mapstructure:"-"`
+	}
 
-			"vdata": map[string]interface{}{
-				"vstring": "bar",
-			},
+	cases := []struct {
+		name     string
+		input    interface{}
+		expected Server
+		err      string
+	}{
+		{
+			"exact",
+			[]interface{}{"prod", 8080, true},
+			Server{Env: "prod", Port: 8080, TLS: true},
+			"",
+		},
+		{
+			"too few",
+			[]interface{}{"prod", 8080},
+			Server{},
+			"'' expected 3 elements, one per field, got 2",
+		},
+		{
+			"too many",
+			[]interface{}{"prod", 8080, true, "extra"},
+			Server{},
+			"'' expected 3 elements, one per field, got 4",
+		},
+		{
+			"bad element",
+			[]interface{}{"prod", "http", true},
+			Server{Env: "prod", TLS: true},
+			"'Port' expected type 'int'",
 		},
 	}
 
-	var result NestedPointer
-	result.Vbar = new(Basic)
-	result.Vbar.Vdata = new(Basic)
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got an err: %s", err.Error())
-	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-	if result.Vfoo != "foo" {
-		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
-	}
+			var result Server
+			decoder, err := NewDecoder(&DecoderConfig{
+				SlicePositional: true,
+				Result:          &result,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
 
-	if result.Vbar.Vstring != "foo" {
-		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
-	}
+			err = decoder.Decode(tc.input)
+			if tc.err == "" && err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
 
-	if result.Vbar.Vint != 42 {
-		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
+			if !reflect.DeepEqual(tc.expected, result) {
+				t.Fatalf("Decode() expected: %#v\ngot: %#v", tc.expected, result)
+			}
+		})
 	}
+}
 
-	if result.Vbar.Vbool != true {
-		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
-	}
+func TestDecode_PluralKeys(t *testing.T) {
+	t.Parallel()
 
-	if result.Vbar.Vextra != "" {
-		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	type Target struct {
+		Hosts []string `mapstructure:"hosts,plural"`
 	}
 
-	if result.Vbar.Vdata.(*Basic).Vstring != "bar" {
-		t.Errorf("vstring value should be 'bar': %#v", result.Vbar.Vdata.(*Basic).Vstring)
-	}
-}
+	cases := []struct {
+		input    map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"host": "a"}, []string{"a"}},
+		{map[string]interface{}{"hosts": []string{"a", "b"}}, []string{"a", "b"}},
+		{map[string]interface{}{"host": []string{"a", "b"}}, []string{"a", "b"}},
+		{map[string]interface{}{"Host": "a"}, []string{"a"}},
+		{map[string]interface{}{}, nil},
+	}
+
+	for i, tc := range cases {
+		var result Target
+		config := &DecoderConfig{
+			ErrorUnused: true,
+			Result:      &result,
+		}
 
-func TestSlice(t *testing.T) {
-	t.Parallel()
+		decoder, err := NewDecoder(config)
+		if err != nil {
+			t.Fatalf("case %d: err: %s", i, err)
+		}
 
-	inputStringSlice := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": []string{"foo", "bar", "baz"},
+		if err := decoder.Decode(tc.input); err != nil {
+			t.Fatalf("case %d: got an err: %s", i, err)
+		}
+
+		if !reflect.DeepEqual(tc.expected, result.Hosts) {
+			t.Fatalf("case %d: expected %#v, got %#v", i, tc.expected, result.Hosts)
+		}
 	}
+}
 
-	inputStringSlicePointer := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": &[]string{"foo", "bar", "baz"},
+func TestDecode_UniqueSlice(t *testing.T) {
+	t.Parallel()
+
+	type Label struct {
+		Key   string
+		Value string
+	}
+	type Target struct {
+		Tags   []string      `mapstructure:"tags,unique"`
+		Ports  []int         `mapstructure:"ports,unique"`
+		Labels []Label       `mapstructure:"labels,unique"`
+		Any    []interface{} `mapstructure:"any,unique"`
+		Nil    []string      `mapstructure:"nil,unique"`
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		SliceMergeMode: SliceMergeAppend,
+		Result:         &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
 
-	outputStringSlice := &Slice{
-		"foo",
-		[]string{"foo", "bar", "baz"},
+	err = decoder.DecodeAll(
+		map[string]interface{}{
+			"tags":   []string{"b", "a", "b"},
+			"ports":  []int{80, 443},
+			"labels": []map[string]interface{}{{"key": "env", "value": "prod"}},
+			"any":    []interface{}{1, "1", 1},
+		},
+		map[string]interface{}{
+			"tags":   []string{"c", "a"},
+			"ports":  []int{443, 8080, 80},
+			"labels": []map[string]interface{}{{"key": "env", "value": "prod"}, {"key": "env", "value": "dev"}},
+		},
+	)
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
 
-	testSliceInput(t, inputStringSlice, outputStringSlice)
-	testSliceInput(t, inputStringSlicePointer, outputStringSlice)
+	expected := Target{
+		Tags:   []string{"b", "a", "c"},
+		Ports:  []int{80, 443, 8080},
+		Labels: []Label{{"env", "prod"}, {"env", "dev"}},
+		Any:    []interface{}{1, "1"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
+	}
 }
 
-func TestNotEmptyByteSlice(t *testing.T) {
+func TestDecode_UniqueSliceInvalid(t *testing.T) {
 	t.Parallel()
 
-	inputByteSlice := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": []byte(`{"bar": "bar"}`),
+	cases := []struct {
+		name   string
+		result interface{}
+		input  map[string]interface{}
+		err    string
+	}{
+		{
+			"not a slice",
+			&struct {
+				Tags string `mapstructure:"tags,unique"`
+			}{},
+			map[string]interface{}{"tags": "a"},
+			"'tags' unique option requires a slice, got 'string'",
+		},
+		{
+			"non-comparable element type",
+			&struct {
+				Tags [][]string `mapstructure:"tags,unique"`
+			}{},
+			map[string]interface{}{"tags": [][]string{{"a"}}},
+			"'tags' unique option requires comparable elements, got '[]string'",
+		},
+		{
+			"non-comparable element value",
+			&struct {
+				Tags []interface{} `mapstructure:"tags,unique"`
+			}{},
+			map[string]interface{}{"tags": []interface{}{"a", []string{"b"}}},
+			"'tags[1]' unique option requires comparable elements, got '[]string'",
+		},
 	}
 
-	result := SliceOfByte{
-		Vfoo: "another foo",
-		Vbar: []byte(`{"bar": "bar bar bar bar bar bar bar bar"}`),
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Decode(tc.input, tc.result)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
 	}
+}
 
-	err := Decode(inputByteSlice, &result)
-	if err != nil {
-		t.Fatalf("got unexpected error: %s", err)
+func TestDecode_VersionedFields(t *testing.T) {
+	t.Parallel()
+
+	type TLS struct {
+		Cert string
+	}
+	type Config struct {
+		Host    string   `mapstructure:"host"`
+		Hosts   []string `mapstructure:"hosts,minversion=2"`
+		TLS     *TLS     `mapstructure:"tls,minversion=3"`
+		Version uint     `mapstructure:"version,version"`
 	}
 
-	expected := SliceOfByte{
-		Vfoo: "foo",
-		Vbar: []byte(`{"bar": "bar"}`),
+	cases := []struct {
+		name     string
+		input    map[string]interface{}
+		expected Config
+	}{
+		{
+			"version 1",
+			map[string]interface{}{"version": 1, "host": "a", "hosts": []string{"b"}, "tls": map[string]interface{}{}},
+			Config{Version: 1, Host: "a"},
+		},
+		{
+			"version 2",
+			map[string]interface{}{"version": 2, "host": "a", "hosts": []string{"b"}, "tls": map[string]interface{}{}},
+			Config{Version: 2, Host: "a", Hosts: []string{"b"}},
+		},
+		{
+			"version 3",
+			map[string]interface{}{"version": 3, "host": "a", "hosts": []string{"b"}, "tls": map[string]interface{}{"cert": "c"}},
+			Config{Version: 3, Host: "a", Hosts: []string{"b"}, TLS: &TLS{Cert: "c"}},
+		},
+		{
+			"no version",
+			map[string]interface{}{"host": "a", "hosts": []string{"b"}},
+			Config{Host: "a"},
+		},
 	}
 
-	if !reflect.DeepEqual(result, expected) {
-		t.Errorf("bad: %#v", result)
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var result Config
+			decoder, err := NewDecoder(&DecoderConfig{
+				ErrorUnused: true,
+				Result:      &result,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if err := decoder.Decode(tc.input); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !reflect.DeepEqual(tc.expected, result) {
+				t.Fatalf("Decode() expected: %#v\ngot: %#v", tc.expected, result)
+			}
+		})
 	}
 }
 
-func TestInvalidSlice(t *testing.T) {
+func TestDecode_VersionedFieldsInvalid(t *testing.T) {
 	t.Parallel()
 
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": 42,
+	cases := []struct {
+		name   string
+		result interface{}
+		err    string
+	}{
+		{
+			"no version field",
+			&struct {
+				Hosts []string `mapstructure:"hosts,minversion=2"`
+			}{},
+			"'' has fields with a minversion option but no version field",
+		},
+		{
+			"version not an integer",
+			&struct {
+				Version string   `mapstructure:"version,version"`
+				Hosts   []string `mapstructure:"hosts,minversion=2"`
+			}{},
+			"'' version field 'Version' must be an integer, got 'string'",
+		},
+		{
+			"invalid minversion",
+			&struct {
+				Version int      `mapstructure:"version,version"`
+				Hosts   []string `mapstructure:"hosts,minversion=two"`
+			}{},
+			"'hosts' has invalid minversion",
+		},
 	}
 
-	result := Slice{}
-	err := Decode(input, &result)
-	if err == nil {
-		t.Errorf("expected failure")
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Decode(map[string]interface{}{"version": "2", "hosts": []string{"a"}}, tc.result)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
 	}
 }
 
-func TestSliceOfStruct(t *testing.T) {
+func TestDecode_MapEntryDefault(t *testing.T) {
 	t.Parallel()
 
+	type Target struct {
+		Weights map[string]int `mapstructure:"weights,entrydefault=1"`
+	}
+
 	input := map[string]interface{}{
-		"value": []map[string]interface{}{
-			{"vstring": "one"},
-			{"vstring": "two"},
+		"weights": map[string]interface{}{
+			"a": nil,
+			"b": 5,
+			"c": "",
+			"d": 0,
 		},
 	}
 
-	var result SliceOfStruct
-	err := Decode(input, &result)
-	if err != nil {
-		t.Fatalf("got unexpected error: %s", err)
+	var result Target
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if len(result.Value) != 2 {
-		t.Fatalf("expected two values, got %d", len(result.Value))
+	expected := map[string]int{"a": 1, "b": 5, "c": 1, "d": 0}
+	if !reflect.DeepEqual(expected, result.Weights) {
+		t.Fatalf("expected %#v, got %#v", expected, result.Weights)
 	}
 
-	if result.Value[0].Vstring != "one" {
-		t.Errorf("first value should be 'one', got: %s", result.Value[0].Vstring)
+	type Invalid struct {
+		Weights map[string]int `mapstructure:"weights,entrydefault=x"`
 	}
 
-	if result.Value[1].Vstring != "two" {
-		t.Errorf("second value should be 'two', got: %s", result.Value[1].Vstring)
+	var invalid Invalid
+	if err := Decode(input, &invalid); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// A nil map is decoded like any nil input.
+	result = Target{Weights: map[string]int{"a": 2}}
+	if err := Decode(map[string]interface{}{"weights": nil}, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if !reflect.DeepEqual(map[string]int{"a": 2}, result.Weights) {
+		t.Fatalf("expected the map to be kept, got %#v", result.Weights)
 	}
 }
 
-func TestSliceCornerCases(t *testing.T) {
+func TestDecode_MapEntryDefaultTypedHook(t *testing.T) {
 	t.Parallel()
 
-	// Input with a map with zero values
-	input := map[string]interface{}{}
-	var resultWeak []Basic
+	type Target struct {
+		Timeouts map[string]time.Duration `mapstructure:"timeouts,entrydefault=5s"`
+	}
 
-	err := WeakDecode(input, &resultWeak)
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		TypedHooks: map[reflect.Type]DecodeHookFunc{
+			reflect.TypeOf(time.Duration(0)): StringToTimeDurationHookFunc(),
+		},
+		Result: &result,
+	})
 	if err != nil {
-		t.Fatalf("got unexpected error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
 
-	if len(resultWeak) != 0 {
-		t.Errorf("length should be 0")
-	}
-	// Input with more values
-	input = map[string]interface{}{
-		"Vstring": "foo",
+	input := map[string]interface{}{
+		"timeouts": map[string]interface{}{"read": "10s", "write": nil},
 	}
-
-	resultWeak = nil
-	err = WeakDecode(input, &resultWeak)
-	if err != nil {
-		t.Fatalf("got unexpected error: %s", err)
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if resultWeak[0].Vstring != "foo" {
-		t.Errorf("value does not match")
+	expected := map[string]time.Duration{"read": 10 * time.Second, "write": 5 * time.Second}
+	if !reflect.DeepEqual(expected, result.Timeouts) {
+		t.Fatalf("expected %#v, got %#v", expected, result.Timeouts)
 	}
 }
 
-func TestSliceToMap(t *testing.T) {
+func TestDecoder_Strict(t *testing.T) {
 	t.Parallel()
 
-	input := []map[string]interface{}{
+	type Target struct {
+		Name  string
+		Small int8
+		Count uint
+		Ratio float32
+	}
+
+	cases := []struct {
+		name  string
+		input map[string]interface{}
+		err   string
+	}{
 		{
-			"foo": "bar",
+			"valid",
+			map[string]interface{}{"name": "foo", "small": 127, "count": 3.0, "ratio": 0.5},
+			"",
 		},
 		{
-			"bar": "baz",
+			"unknown key",
+			map[string]interface{}{"name": "foo", "small": 1, "count": 1, "ratio": 1, "extra": true},
+			"'' has invalid keys: extra",
+		},
+		{
+			"missing key",
+			map[string]interface{}{"name": "foo", "small": 1, "count": 1},
+			"'' has unset fields: Ratio",
+		},
+		{
+			"weak typing",
+			map[string]interface{}{"name": 42, "small": 1, "count": 1, "ratio": 1},
+			"'Name' expected type 'string', got unconvertible type 'int', value: '42'",
+		},
+		{
+			"overflow",
+			map[string]interface{}{"name": "foo", "small": 300, "count": 1, "ratio": 1},
+			"cannot parse 'Small': value 300 overflows int8",
+		},
+		{
+			"truncation",
+			map[string]interface{}{"name": "foo", "small": 1, "count": 1.5, "ratio": 1},
+			"cannot parse 'Count': value 1.5 would be truncated converting to uint",
+		},
+		{
+			"negative",
+			map[string]interface{}{"name": "foo", "small": 1, "count": -1, "ratio": 1},
+			"cannot parse 'Count': negative value -1 cannot be converted to uint",
+		},
+		{
+			"float overflow",
+			map[string]interface{}{"name": "foo", "small": 1, "count": 1, "ratio": 1e300},
+			"cannot parse 'Ratio': value 1e+300 overflows float32",
 		},
 	}
 
-	var result map[string]interface{}
-	err := WeakDecode(input, &result)
-	if err != nil {
-		t.Fatalf("got an error: %s", err)
-	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var result Target
+			config := &DecoderConfig{
+				Result:           &result,
+				Strict:           true,
+				WeaklyTypedInput: true,
+			}
 
-	expected := map[string]interface{}{
-		"foo": "bar",
-		"bar": "baz",
-	}
-	if !reflect.DeepEqual(result, expected) {
-		t.Errorf("bad: %#v", result)
+			decoder, err := NewDecoder(config)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			err = decoder.Decode(tc.input)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("got an err: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
 	}
 }
 
-func TestArray(t *testing.T) {
+func TestDecoder_ErrorOnFloatTruncation(t *testing.T) {
 	t.Parallel()
 
-	inputStringArray := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": [2]string{"foo", "bar"},
-	}
-
-	inputStringArrayPointer := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": &[2]string{"foo", "bar"},
+	type Target struct {
+		Count int
+		Size  uint16
 	}
 
-	outputStringArray := &Array{
-		"foo",
-		[2]string{"foo", "bar"},
+	cases := []struct {
+		name     string
+		weak     bool
+		input    map[string]interface{}
+		expected Target
+		err      string
+	}{
+		{
+			"whole floats",
+			false,
+			map[string]interface{}{"count": 3.0, "size": float32(8)},
+			Target{Count: 3, Size: 8},
+			"",
+		},
+		{
+			"int fraction",
+			false,
+			map[string]interface{}{"count": 3.7},
+			Target{},
+			"cannot parse 'Count', 3.7 would be truncated when decoded into 'int'",
+		},
+		{
+			"uint fraction",
+			false,
+			map[string]interface{}{"size": 0.5},
+			Target{},
+			"cannot parse 'Size', 0.5 would be truncated when decoded into 'uint16'",
+		},
+		{
+			"weakly typed",
+			true,
+			map[string]interface{}{"count": -2.5},
+			Target{},
+			"cannot parse 'Count', -2.5 would be truncated when decoded into 'int'",
+		},
 	}
 
-	testArrayInput(t, inputStringArray, outputStringArray)
-	testArrayInput(t, inputStringArrayPointer, outputStringArray)
-}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-func TestInvalidArray(t *testing.T) {
-	t.Parallel()
+			var result Target
+			decoder, err := NewDecoder(&DecoderConfig{
+				ErrorOnFloatTruncation: true,
+				WeaklyTypedInput:       tc.weak,
+				Result:                 &result,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
 
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": 42,
+			err = decoder.Decode(tc.input)
+			if tc.err == "" && err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(tc.expected, result) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, result)
+			}
+		})
 	}
 
-	result := Array{}
-	err := Decode(input, &result)
-	if err == nil {
-		t.Errorf("expected failure")
+	// Without the option the fractional part is dropped.
+	var result Target
+	if err := Decode(map[string]interface{}{"count": 3.7}, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Count != 3 {
+		t.Fatalf("bad: %#v", result)
 	}
 }
 
-func TestArrayOfStruct(t *testing.T) {
+func TestDecoder_SubConfigs(t *testing.T) {
 	t.Parallel()
 
+	type Legacy struct {
+		Port    int
+		Enabled bool
+	}
+	type Target struct {
+		Name   string
+		Legacy Legacy `mapstructure:"legacy,config=legacy"`
+	}
+
 	input := map[string]interface{}{
-		"value": []map[string]interface{}{
-			{"vstring": "one"},
-			{"vstring": "two"},
+		"name": "foo",
+		"legacy": map[string]interface{}{
+			"port":    "8080",
+			"enabled": "true",
+			"unused":  "ignored",
 		},
 	}
 
-	var result ArrayOfStruct
-	err := Decode(input, &result)
+	var result Target
+	config := &DecoderConfig{
+		Result: &result,
+		Strict: true,
+		SubConfigs: map[string]*DecoderConfig{
+			"legacy": {WeaklyTypedInput: true},
+		},
+	}
+
+	decoder, err := NewDecoder(config)
 	if err != nil {
-		t.Fatalf("got unexpected error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
 
-	if len(result.Value) != 2 {
-		t.Fatalf("expected two values, got %d", len(result.Value))
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
 	}
 
-	if result.Value[0].Vstring != "one" {
-		t.Errorf("first value should be 'one', got: %s", result.Value[0].Vstring)
+	expected := Target{Name: "foo", Legacy: Legacy{Port: 8080, Enabled: true}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, result)
 	}
 
-	if result.Value[1].Vstring != "two" {
-		t.Errorf("second value should be 'two', got: %s", result.Value[1].Vstring)
+	// The parent is still strict
+	input["name"] = 42
+	if err := decoder.Decode(input); err == nil {
+		t.Fatal("expected error")
+	}
+
+	type Unknown struct {
+		Legacy Legacy `mapstructure:"legacy,config=unknown"`
+	}
+
+	var unknown Unknown
+	err = Decode(input, &unknown)
+	if err == nil || !strings.Contains(err.Error(), "'legacy' references unknown config 'unknown'") {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-func TestArrayToMap(t *testing.T) {
+func TestDecoder_SubConfigsNested(t *testing.T) {
 	t.Parallel()
 
-	input := []map[string]interface{}{
-		{
-			"foo": "bar",
+	type Inner struct {
+		Port   int
+		Labels map[string]string
+	}
+	type Outer struct {
+		Inner Inner `mapstructure:"inner,config=weak"`
+	}
+	type Target struct {
+		Outer Outer `mapstructure:"outer,config=strict"`
+	}
+
+	// The configurations refer to each other through the SubConfigs they
+	// inherit from the root.
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		Result: &result,
+		SubConfigs: map[string]*DecoderConfig{
+			"strict": {ErrorUnused: true},
+			"weak":   {WeaklyTypedInput: true},
 		},
-		{
-			"bar": "baz",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Maps are merged by DecodeAll through the decoders of SubConfigs too.
+	err = decoder.DecodeAll(
+		map[string]interface{}{"outer": map[string]interface{}{"inner": map[string]interface{}{
+			"port": "80", "labels": map[string]interface{}{"a": "1"},
+		}}},
+		map[string]interface{}{"outer": map[string]interface{}{"inner": map[string]interface{}{
+			"labels": map[string]interface{}{"b": "2"},
+		}}},
+	)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := Target{Outer: Outer{Inner: Inner{Port: 80, Labels: map[string]string{"a": "1", "b": "2"}}}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"outer": map[string]interface{}{"extra": 1}})
+	if err == nil || !strings.Contains(err.Error(), "invalid keys: extra") {
+		t.Fatalf("expected an unused key error, got %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vother": map[interface{}]interface{}{
+			"foo": "foo",
+			"bar": "bar",
 		},
 	}
 
-	var result map[string]interface{}
-	err := WeakDecode(input, &result)
+	var result Map
+	err := Decode(input, &result)
 	if err != nil {
 		t.Fatalf("got an error: %s", err)
 	}
 
-	expected := map[string]interface{}{
-		"foo": "bar",
-		"bar": "baz",
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
 	}
-	if !reflect.DeepEqual(result, expected) {
-		t.Errorf("bad: %#v", result)
+
+	if result.Vother == nil {
+		t.Fatal("vother should not be nil")
+	}
+
+	if len(result.Vother) != 2 {
+		t.Error("vother should have two items")
+	}
+
+	if result.Vother["foo"] != "foo" {
+		t.Errorf("'foo' key should be foo, got: %#v", result.Vother["foo"])
+	}
+
+	if result.Vother["bar"] != "bar" {
+		t.Errorf("'bar' key should be bar, got: %#v", result.Vother["bar"])
 	}
 }
 
-func TestDecodeTable(t *testing.T) {
+func TestMapMerge(t *testing.T) {
 	t.Parallel()
 
-	// We need to make new types so that we don't get the short-circuit
-	// copy functionality. We want to test the deep copying functionality.
-	type BasicCopy Basic
-	type NestedPointerCopy NestedPointer
-	type MapCopy Map
-
-	tests := []struct {
-		name    string
-		in      interface{}
-		target  interface{}
-		out     interface{}
-		wantErr bool
-	}{
-		{
-			"basic struct input",
-			&Basic{
-				Vstring: "vstring",
-				Vint:    2,
-				Vint8:   2,
-				Vint16:  2,
-				Vint32:  2,
-				Vint64:  2,
-				Vuint:   3,
-				Vbool:   true,
-				Vfloat:  4.56,
-				Vextra:  "vextra",
-				vsilent: true,
-				Vdata:   []byte("data"),
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"Vstring":     "vstring",
-				"Vint":        2,
-				"Vint8":       int8(2),
-				"Vint16":      int16(2),
-				"Vint32":      int32(2),
-				"Vint64":      int64(2),
-				"Vuint":       uint(3),
-				"Vbool":       true,
-				"Vfloat":      4.56,
-				"Vextra":      "vextra",
-				"Vdata":       []byte("data"),
-				"VjsonInt":    0,
-				"VjsonUint":   uint(0),
-				"VjsonUint64": uint64(0),
-				"VjsonFloat":  0.0,
-				"VjsonNumber": json.Number(""),
-				"Vcomplex64":  complex64(0),
-				"Vcomplex128": complex128(0),
-			},
-			false,
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vother": map[interface{}]interface{}{
+			"foo": "foo",
+			"bar": "bar",
 		},
-		{
-			"embedded struct input",
-			&Embedded{
-				Vunique: "vunique",
-				Basic: Basic{
-					Vstring: "vstring",
-					Vint:    2,
-					Vint8:   2,
-					Vint16:  2,
-					Vint32:  2,
-					Vint64:  2,
-					Vuint:   3,
-					Vbool:   true,
-					Vfloat:  4.56,
-					Vextra:  "vextra",
-					vsilent: true,
-					Vdata:   []byte("data"),
-				},
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"Vunique": "vunique",
-				"Basic": map[string]interface{}{
-					"Vstring":     "vstring",
-					"Vint":        2,
-					"Vint8":       int8(2),
-					"Vint16":      int16(2),
-					"Vint32":      int32(2),
-					"Vint64":      int64(2),
-					"Vuint":       uint(3),
-					"Vbool":       true,
-					"Vfloat":      4.56,
-					"Vextra":      "vextra",
-					"Vdata":       []byte("data"),
-					"VjsonInt":    0,
-					"VjsonUint":   uint(0),
-					"VjsonUint64": uint64(0),
-					"VjsonFloat":  0.0,
-					"VjsonNumber": json.Number(""),
-					"Vcomplex64":  complex64(0),
-					"Vcomplex128": complex128(0),
-				},
-			},
-			false,
+	}
+
+	var result Map
+	result.Vother = map[string]string{"hello": "world"}
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an error: %s", err)
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	expected := map[string]string{
+		"foo":   "foo",
+		"bar":   "bar",
+		"hello": "world",
+	}
+	if !reflect.DeepEqual(result.Vother, expected) {
+		t.Errorf("bad: %#v", result.Vother)
+	}
+}
+
+func TestMapOfStruct(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"value": map[string]interface{}{
+			"foo": map[string]string{"vstring": "one"},
+			"bar": map[string]string{"vstring": "two"},
 		},
-		{
-			"struct => struct",
-			&Basic{
-				Vstring: "vstring",
-				Vint:    2,
-				Vuint:   3,
-				Vbool:   true,
-				Vfloat:  4.56,
-				Vextra:  "vextra",
-				Vdata:   []byte("data"),
-				vsilent: true,
-			},
-			&BasicCopy{},
-			&BasicCopy{
-				Vstring: "vstring",
-				Vint:    2,
-				Vuint:   3,
-				Vbool:   true,
-				Vfloat:  4.56,
-				Vextra:  "vextra",
-				Vdata:   []byte("data"),
-			},
-			false,
-		},
-		{
-			"struct => struct with pointers",
-			&NestedPointer{
-				Vfoo: "hello",
-				Vbar: nil,
-			},
-			&NestedPointerCopy{},
-			&NestedPointerCopy{
-				Vfoo: "hello",
-			},
-			false,
-		},
-		{
-			"basic pointer to non-pointer",
-			&BasicPointer{
-				Vstring: stringPtr("vstring"),
-				Vint:    intPtr(2),
-				Vuint:   uintPtr(3),
-				Vbool:   boolPtr(true),
-				Vfloat:  floatPtr(4.56),
-				Vdata:   interfacePtr([]byte("data")),
-			},
-			&Basic{},
-			&Basic{
-				Vstring: "vstring",
-				Vint:    2,
-				Vuint:   3,
-				Vbool:   true,
-				Vfloat:  4.56,
-				Vdata:   []byte("data"),
-			},
-			false,
-		},
-		{
-			"slice non-pointer to pointer",
-			&Slice{},
-			&SlicePointer{},
-			&SlicePointer{},
-			false,
-		},
-		{
-			"slice non-pointer to pointer, zero field",
-			&Slice{},
-			&SlicePointer{
-				Vbar: &[]string{"yo"},
-			},
-			&SlicePointer{},
-			false,
-		},
-		{
-			"slice to slice alias",
-			&Slice{},
-			&SliceOfAlias{},
-			&SliceOfAlias{},
-			false,
-		},
-		{
-			"nil map to map",
-			&Map{},
-			&MapCopy{},
-			&MapCopy{},
-			false,
-		},
-		{
-			"nil map to non-empty map",
-			&Map{},
-			&MapCopy{Vother: map[string]string{"foo": "bar"}},
-			&MapCopy{},
-			false,
+	}
+
+	var result MapOfStruct
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Value == nil {
+		t.Fatal("value should not be nil")
+	}
+
+	if len(result.Value) != 2 {
+		t.Error("value should have two items")
+	}
+
+	if result.Value["foo"].Vstring != "one" {
+		t.Errorf("foo value should be 'one', got: %s", result.Value["foo"].Vstring)
+	}
+
+	if result.Value["bar"].Vstring != "two" {
+		t.Errorf("bar value should be 'two', got: %s", result.Value["bar"].Vstring)
+	}
+}
+
+func TestNestedType(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": map[string]interface{}{
+			"vstring": "foo",
+			"vint":    42,
+			"vbool":   true,
 		},
+	}
 
-		{
-			"slice input - should error",
-			[]string{"foo", "bar"},
-			&map[string]interface{}{},
-			&map[string]interface{}{},
-			true,
+	var result Nested
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	if result.Vbar.Vstring != "foo" {
+		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
+	}
+
+	if result.Vbar.Vint != 42 {
+		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
+	}
+
+	if result.Vbar.Vbool != true {
+		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
+	}
+
+	if result.Vbar.Vextra != "" {
+		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	}
+}
+
+func TestNestedTypePointer(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": &map[string]interface{}{
+			"vstring": "foo",
+			"vint":    42,
+			"vbool":   true,
 		},
-		{
-			"struct with slice property",
-			&Slice{
-				Vfoo: "vfoo",
-				Vbar: []string{"foo", "bar"},
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"Vfoo": "vfoo",
-				"Vbar": []string{"foo", "bar"},
-			},
-			false,
+	}
+
+	var result NestedPointer
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	if result.Vbar.Vstring != "foo" {
+		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
+	}
+
+	if result.Vbar.Vint != 42 {
+		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
+	}
+
+	if result.Vbar.Vbool != true {
+		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
+	}
+
+	if result.Vbar.Vextra != "" {
+		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	}
+}
+
+// Test for issue #46.
+func TestDecode_PointerToCollection(t *testing.T) {
+	t.Parallel()
+
+	type Item struct {
+		Name string
+	}
+	type Target struct {
+		Items *[]*Item
+		Names *[]string
+		Ports *map[string]int
+	}
+
+	input := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
 		},
-		{
-			"struct with empty slice",
-			&map[string]interface{}{
-				"Vbar": []string{},
-			},
-			&Slice{},
-			&Slice{
-				Vbar: []string{},
+		"names": []string{},
+		"ports": map[string]interface{}{"http": 80},
+	}
+
+	var result Target
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if result.Items == nil || len(*result.Items) != 2 {
+		t.Fatalf("items not allocated: %#v", result.Items)
+	}
+	for i, name := range []string{"a", "b"} {
+		item := (*result.Items)[i]
+		if item == nil || item.Name != name {
+			t.Fatalf("item %d not allocated: %#v", i, item)
+		}
+	}
+	if result.Names == nil || *result.Names == nil || len(*result.Names) != 0 {
+		t.Fatalf("names not allocated: %#v", result.Names)
+	}
+	if result.Ports == nil || !reflect.DeepEqual(*result.Ports, map[string]int{"http": 80}) {
+		t.Fatalf("ports not allocated: %#v", result.Ports)
+	}
+
+	nilInput := map[string]interface{}{
+		"items": nil,
+		"names": []string(nil),
+		"ports": (*map[string]int)(nil),
+	}
+
+	// Without DecodeNil, nil input leaves the targets untouched
+	// unless it is a nil collection.
+	untouched := result
+	if err := Decode(nilInput, &untouched); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if untouched.Items == nil || untouched.Ports == nil {
+		t.Fatalf("targets should be untouched: %#v", untouched)
+	}
+
+	config := &DecoderConfig{
+		DecodeNil: true,
+		Result:    &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(nilInput); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	if !reflect.DeepEqual(Target{}, result) {
+		t.Fatalf("expected all nil, got %#v", result)
+	}
+
+	var names []string
+	config = &DecoderConfig{
+		DecodeNil: true,
+		Result:    &names,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	names = []string{"a"}
+	if err := decoder.Decode([]string(nil)); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if names != nil {
+		t.Fatalf("expected nil, got %#v", names)
+	}
+
+	// Typed nil maps count as nil too, whatever their type.
+	result = Target{Ports: &map[string]int{"http": 80}}
+	config = &DecoderConfig{
+		DecodeNil: true,
+		Result:    &result,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(map[string]interface{}{"ports": map[string]interface{}(nil)}); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if result.Ports != nil {
+		t.Fatalf("expected nil, got %#v", result.Ports)
+	}
+
+	ports := map[string]int{"http": 80}
+	config = &DecoderConfig{
+		DecodeNil: true,
+		Result:    &ports,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(map[string]interface{}(nil)); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if ports != nil {
+		t.Fatalf("expected nil, got %#v", ports)
+	}
+}
+
+func TestNestedTypeInterface(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": &map[string]interface{}{
+			"vstring": "foo",
+			"vint":    42,
+			"vbool":   true,
+
+			"vdata": map[string]interface{}{
+				"vstring": "bar",
 			},
-			false,
 		},
-		{
-			"struct with slice of struct property",
-			&SliceOfStruct{
-				Value: []Basic{
-					{
-						Vstring: "vstring",
-						Vint:    2,
-						Vuint:   3,
-						Vbool:   true,
+	}
+
+	var result NestedPointer
+	result.Vbar = new(Basic)
+	result.Vbar.Vdata = new(Basic)
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got an err: %s", err.Error())
+	}
+
+	if result.Vfoo != "foo" {
+		t.Errorf("vfoo value should be 'foo': %#v", result.Vfoo)
+	}
+
+	if result.Vbar.Vstring != "foo" {
+		t.Errorf("vstring value should be 'foo': %#v", result.Vbar.Vstring)
+	}
+
+	if result.Vbar.Vint != 42 {
+		t.Errorf("vint value should be 42: %#v", result.Vbar.Vint)
+	}
+
+	if result.Vbar.Vbool != true {
+		t.Errorf("vbool value should be true: %#v", result.Vbar.Vbool)
+	}
+
+	if result.Vbar.Vextra != "" {
+		t.Errorf("vextra value should be empty: %#v", result.Vbar.Vextra)
+	}
+
+	if result.Vbar.Vdata.(*Basic).Vstring != "bar" {
+		t.Errorf("vstring value should be 'bar': %#v", result.Vbar.Vdata.(*Basic).Vstring)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	t.Parallel()
+
+	inputStringSlice := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": []string{"foo", "bar", "baz"},
+	}
+
+	inputStringSlicePointer := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": &[]string{"foo", "bar", "baz"},
+	}
+
+	outputStringSlice := &Slice{
+		"foo",
+		[]string{"foo", "bar", "baz"},
+	}
+
+	testSliceInput(t, inputStringSlice, outputStringSlice)
+	testSliceInput(t, inputStringSlicePointer, outputStringSlice)
+}
+
+func TestNotEmptyByteSlice(t *testing.T) {
+	t.Parallel()
+
+	inputByteSlice := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": []byte(`{"bar": "bar"}`),
+	}
+
+	result := SliceOfByte{
+		Vfoo: "another foo",
+		Vbar: []byte(`{"bar": "bar bar bar bar bar bar bar bar"}`),
+	}
+
+	err := Decode(inputByteSlice, &result)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	expected := SliceOfByte{
+		Vfoo: "foo",
+		Vbar: []byte(`{"bar": "bar"}`),
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("bad: %#v", result)
+	}
+}
+
+func TestInvalidSlice(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": 42,
+	}
+
+	result := Slice{}
+	err := Decode(input, &result)
+	if err == nil {
+		t.Errorf("expected failure")
+	}
+}
+
+func TestSliceOfStruct(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"value": []map[string]interface{}{
+			{"vstring": "one"},
+			{"vstring": "two"},
+		},
+	}
+
+	var result SliceOfStruct
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(result.Value) != 2 {
+		t.Fatalf("expected two values, got %d", len(result.Value))
+	}
+
+	if result.Value[0].Vstring != "one" {
+		t.Errorf("first value should be 'one', got: %s", result.Value[0].Vstring)
+	}
+
+	if result.Value[1].Vstring != "two" {
+		t.Errorf("second value should be 'two', got: %s", result.Value[1].Vstring)
+	}
+}
+
+func TestSliceCornerCases(t *testing.T) {
+	t.Parallel()
+
+	// Input with a map with zero values
+	input := map[string]interface{}{}
+	var resultWeak []Basic
+
+	err := WeakDecode(input, &resultWeak)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(resultWeak) != 0 {
+		t.Errorf("length should be 0")
+	}
+	// Input with more values
+	input = map[string]interface{}{
+		"Vstring": "foo",
+	}
+
+	resultWeak = nil
+	err = WeakDecode(input, &resultWeak)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if resultWeak[0].Vstring != "foo" {
+		t.Errorf("value does not match")
+	}
+}
+
+func TestSliceToMap(t *testing.T) {
+	t.Parallel()
+
+	input := []map[string]interface{}{
+		{
+			"foo": "bar",
+		},
+		{
+			"bar": "baz",
+		},
+	}
+
+	var result map[string]interface{}
+	err := WeakDecode(input, &result)
+	if err != nil {
+		t.Fatalf("got an error: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"foo": "bar",
+		"bar": "baz",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("bad: %#v", result)
+	}
+}
+
+func TestArray(t *testing.T) {
+	t.Parallel()
+
+	inputStringArray := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": [2]string{"foo", "bar"},
+	}
+
+	inputStringArrayPointer := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": &[2]string{"foo", "bar"},
+	}
+
+	outputStringArray := &Array{
+		"foo",
+		[2]string{"foo", "bar"},
+	}
+
+	testArrayInput(t, inputStringArray, outputStringArray)
+	testArrayInput(t, inputStringArrayPointer, outputStringArray)
+}
+
+func TestInvalidArray(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": 42,
+	}
+
+	result := Array{}
+	err := Decode(input, &result)
+	if err == nil {
+		t.Errorf("expected failure")
+	}
+}
+
+func TestArrayOfStruct(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"value": []map[string]interface{}{
+			{"vstring": "one"},
+			{"vstring": "two"},
+		},
+	}
+
+	var result ArrayOfStruct
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if len(result.Value) != 2 {
+		t.Fatalf("expected two values, got %d", len(result.Value))
+	}
+
+	if result.Value[0].Vstring != "one" {
+		t.Errorf("first value should be 'one', got: %s", result.Value[0].Vstring)
+	}
+
+	if result.Value[1].Vstring != "two" {
+		t.Errorf("second value should be 'two', got: %s", result.Value[1].Vstring)
+	}
+}
+
+func TestArrayToMap(t *testing.T) {
+	t.Parallel()
+
+	input := []map[string]interface{}{
+		{
+			"foo": "bar",
+		},
+		{
+			"bar": "baz",
+		},
+	}
+
+	var result map[string]interface{}
+	err := WeakDecode(input, &result)
+	if err != nil {
+		t.Fatalf("got an error: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"foo": "bar",
+		"bar": "baz",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("bad: %#v", result)
+	}
+}
+
+func TestDecodeTable(t *testing.T) {
+	t.Parallel()
+
+	// We need to make new types so that we don't get the short-circuit
+	// copy functionality. We want to test the deep copying functionality.
+	type BasicCopy Basic
+	type NestedPointerCopy NestedPointer
+	type MapCopy Map
+
+	tests := []struct {
+		name    string
+		in      interface{}
+		target  interface{}
+		out     interface{}
+		wantErr bool
+	}{
+		{
+			"basic struct input",
+			&Basic{
+				Vstring: "vstring",
+				Vint:    2,
+				Vint8:   2,
+				Vint16:  2,
+				Vint32:  2,
+				Vint64:  2,
+				Vuint:   3,
+				Vbool:   true,
+				Vfloat:  4.56,
+				Vextra:  "vextra",
+				vsilent: true,
+				Vdata:   []byte("data"),
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"Vstring":     "vstring",
+				"Vint":        2,
+				"Vint8":       int8(2),
+				"Vint16":      int16(2),
+				"Vint32":      int32(2),
+				"Vint64":      int64(2),
+				"Vuint":       uint(3),
+				"Vbool":       true,
+				"Vfloat":      4.56,
+				"Vextra":      "vextra",
+				"Vdata":       []byte("data"),
+				"VjsonInt":    0,
+				"VjsonUint":   uint(0),
+				"VjsonUint64": uint64(0),
+				"VjsonFloat":  0.0,
+				"VjsonNumber": json.Number(""),
+				"Vcomplex64":  complex64(0),
+				"Vcomplex128": complex128(0),
+			},
+			false,
+		},
+		{
+			"embedded struct input",
+			&Embedded{
+				Vunique: "vunique",
+				Basic: Basic{
+					Vstring: "vstring",
+					Vint:    2,
+					Vint8:   2,
+					Vint16:  2,
+					Vint32:  2,
+					Vint64:  2,
+					Vuint:   3,
+					Vbool:   true,
+					Vfloat:  4.56,
+					Vextra:  "vextra",
+					vsilent: true,
+					Vdata:   []byte("data"),
+				},
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"Vunique": "vunique",
+				"Basic": map[string]interface{}{
+					"Vstring":     "vstring",
+					"Vint":        2,
+					"Vint8":       int8(2),
+					"Vint16":      int16(2),
+					"Vint32":      int32(2),
+					"Vint64":      int64(2),
+					"Vuint":       uint(3),
+					"Vbool":       true,
+					"Vfloat":      4.56,
+					"Vextra":      "vextra",
+					"Vdata":       []byte("data"),
+					"VjsonInt":    0,
+					"VjsonUint":   uint(0),
+					"VjsonUint64": uint64(0),
+					"VjsonFloat":  0.0,
+					"VjsonNumber": json.Number(""),
+					"Vcomplex64":  complex64(0),
+					"Vcomplex128": complex128(0),
+				},
+			},
+			false,
+		},
+		{
+			"struct => struct",
+			&Basic{
+				Vstring: "vstring",
+				Vint:    2,
+				Vuint:   3,
+				Vbool:   true,
+				Vfloat:  4.56,
+				Vextra:  "vextra",
+				Vdata:   []byte("data"),
+				vsilent: true,
+			},
+			&BasicCopy{},
+			&BasicCopy{
+				Vstring: "vstring",
+				Vint:    2,
+				Vuint:   3,
+				Vbool:   true,
+				Vfloat:  4.56,
+				Vextra:  "vextra",
+				Vdata:   []byte("data"),
+			},
+			false,
+		},
+		{
+			"struct => struct with pointers",
+			&NestedPointer{
+				Vfoo: "hello",
+				Vbar: nil,
+			},
+			&NestedPointerCopy{},
+			&NestedPointerCopy{
+				Vfoo: "hello",
+			},
+			false,
+		},
+		{
+			"basic pointer to non-pointer",
+			&BasicPointer{
+				Vstring: stringPtr("vstring"),
+				Vint:    intPtr(2),
+				Vuint:   uintPtr(3),
+				Vbool:   boolPtr(true),
+				Vfloat:  floatPtr(4.56),
+				Vdata:   interfacePtr([]byte("data")),
+			},
+			&Basic{},
+			&Basic{
+				Vstring: "vstring",
+				Vint:    2,
+				Vuint:   3,
+				Vbool:   true,
+				Vfloat:  4.56,
+				Vdata:   []byte("data"),
+			},
+			false,
+		},
+		{
+			"slice non-pointer to pointer",
+			&Slice{},
+			&SlicePointer{},
+			&SlicePointer{},
+			false,
+		},
+		{
+			"slice non-pointer to pointer, zero field",
+			&Slice{},
+			&SlicePointer{
+				Vbar: &[]string{"yo"},
+			},
+			&SlicePointer{},
+			false,
+		},
+		{
+			"slice to slice alias",
+			&Slice{},
+			&SliceOfAlias{},
+			&SliceOfAlias{},
+			false,
+		},
+		{
+			"nil map to map",
+			&Map{},
+			&MapCopy{},
+			&MapCopy{},
+			false,
+		},
+		{
+			"nil map to non-empty map",
+			&Map{},
+			&MapCopy{Vother: map[string]string{"foo": "bar"}},
+			&MapCopy{},
+			false,
+		},
+
+		{
+			"slice input - should error",
+			[]string{"foo", "bar"},
+			&map[string]interface{}{},
+			&map[string]interface{}{},
+			true,
+		},
+		{
+			"struct with slice property",
+			&Slice{
+				Vfoo: "vfoo",
+				Vbar: []string{"foo", "bar"},
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"Vfoo": "vfoo",
+				"Vbar": []string{"foo", "bar"},
+			},
+			false,
+		},
+		{
+			"struct with empty slice",
+			&map[string]interface{}{
+				"Vbar": []string{},
+			},
+			&Slice{},
+			&Slice{
+				Vbar: []string{},
+			},
+			false,
+		},
+		{
+			"struct with slice of struct property",
+			&SliceOfStruct{
+				Value: []Basic{
+					{
+						Vstring: "vstring",
+						Vint:    2,
+						Vuint:   3,
+						Vbool:   true,
+						Vfloat:  4.56,
+						Vextra:  "vextra",
+						vsilent: true,
+						Vdata:   []byte("data"),
+					},
+				},
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"Value": []Basic{
+					{
+						Vstring: "vstring",
+						Vint:    2,
+						Vuint:   3,
+						Vbool:   true,
 						Vfloat:  4.56,
 						Vextra:  "vextra",
 						vsilent: true,
@@ -2138,748 +3793,3903 @@ func TestDecodeTable(t *testing.T) {
 					},
 				},
 			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"Value": []Basic{
-					{
-						Vstring: "vstring",
-						Vint:    2,
-						Vuint:   3,
-						Vbool:   true,
-						Vfloat:  4.56,
-						Vextra:  "vextra",
-						vsilent: true,
-						Vdata:   []byte("data"),
-					},
-				},
+			false,
+		},
+		{
+			"struct with map property",
+			&Map{
+				Vfoo:   "vfoo",
+				Vother: map[string]string{"vother": "vother"},
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"Vfoo": "vfoo",
+				"Vother": map[string]string{
+					"vother": "vother",
+				},
+			},
+			false,
+		},
+		{
+			"tagged struct",
+			&Tagged{
+				Extra: "extra",
+				Value: "value",
+			},
+			&map[string]string{},
+			&map[string]string{
+				"bar": "extra",
+				"foo": "value",
+			},
+			false,
+		},
+		{
+			"omit tag struct",
+			&struct {
+				Value string `mapstructure:"value"`
+				Omit  string `mapstructure:"-"`
+			}{
+				Value: "value",
+				Omit:  "omit",
+			},
+			&map[string]string{},
+			&map[string]string{
+				"value": "value",
+			},
+			false,
+		},
+		{
+			"decode to wrong map type",
+			&struct {
+				Value string
+			}{
+				Value: "string",
+			},
+			&map[string]int{},
+			&map[string]int{},
+			true,
+		},
+		{
+			"remainder",
+			map[string]interface{}{
+				"A": "hello",
+				"B": "goodbye",
+				"C": "yo",
+			},
+			&Remainder{},
+			&Remainder{
+				A: "hello",
+				Extra: map[string]interface{}{
+					"B": "goodbye",
+					"C": "yo",
+				},
+			},
+			false,
+		},
+		{
+			"remainder with no extra",
+			map[string]interface{}{
+				"A": "hello",
+			},
+			&Remainder{},
+			&Remainder{
+				A:     "hello",
+				Extra: nil,
+			},
+			false,
+		},
+		{
+			"struct with omitempty tag return non-empty values",
+			&struct {
+				VisibleField interface{} `mapstructure:"visible"`
+				OmitField    interface{} `mapstructure:"omittable,omitempty"`
+			}{
+				VisibleField: nil,
+				OmitField:    "string",
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{"visible": nil, "omittable": "string"},
+			false,
+		},
+		{
+			"struct with omitempty tag ignore empty values",
+			&struct {
+				VisibleField interface{} `mapstructure:"visible"`
+				OmitField    interface{} `mapstructure:"omittable,omitempty"`
+			}{
+				VisibleField: nil,
+				OmitField:    nil,
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{"visible": nil},
+			false,
+		},
+		{
+			"remainder with decode to map",
+			&Remainder{
+				A: "Alabasta",
+				Extra: map[string]interface{}{
+					"B": "Baratie",
+					"C": "Cocoyasi",
+				},
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"A": "Alabasta",
+				"B": "Baratie",
+				"C": "Cocoyasi",
+			},
+			false,
+		},
+		{
+			"remainder with decode to map with non-map field",
+			&struct {
+				A     string
+				Extra *struct{} `mapstructure:",remain"`
+			}{
+				A:     "Alabasta",
+				Extra: nil,
+			},
+			&map[string]interface{}{},
+			&map[string]interface{}{
+				"A": "Alabasta",
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Decode(tt.in, tt.target); (err != nil) != tt.wantErr {
+				t.Fatalf("%q: TestMapOutputForStructuredInputs() unexpected error: %s", tt.name, err)
+			}
+
+			if !reflect.DeepEqual(tt.out, tt.target) {
+				t.Fatalf("%q: TestMapOutputForStructuredInputs() expected: %#v, got: %#v", tt.name, tt.out, tt.target)
+			}
+		})
+	}
+}
+
+func TestDecode_RemainStruct(t *testing.T) {
+	t.Parallel()
+
+	type Extra struct {
+		B string
+		C int
+	}
+	type Target struct {
+		A     string
+		Extra Extra `mapstructure:",remain"`
+	}
+
+	input := map[string]interface{}{
+		"A": "hello",
+		"B": "goodbye",
+		"C": 42,
+		"D": true,
+	}
+
+	var md Metadata
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		Metadata: &md,
+		Result:   &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{A: "hello", Extra: Extra{B: "goodbye", C: 42}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual([]string{"A", "Extra", "Extra.B", "Extra.C"}, md.Keys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+	if !reflect.DeepEqual([]string{"Extra.D"}, md.Unused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+
+	var invalid struct {
+		A     string
+		Extra string `mapstructure:",remain"`
+	}
+	err = Decode(input, &invalid)
+	if err == nil || !strings.Contains(err.Error(), "'Extra' remain field must be a map or struct, got 'string'") {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestInvalidType(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vstring": 42,
+	}
+
+	var result Basic
+	err := Decode(input, &result)
+	if err == nil {
+		t.Fatal("error should exist")
+	}
+
+	var derr interface {
+		Unwrap() []error
+	}
+
+	if !errors.As(err, &derr) {
+		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	}
+
+	errs := derr.Unwrap()
+
+	if errs[0].Error() != "'Vstring' expected type 'string', got unconvertible type 'int', value: '42'" {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	inputNegIntUint := map[string]interface{}{
+		"vuint": -42,
+	}
+
+	err = Decode(inputNegIntUint, &result)
+	if err == nil {
+		t.Fatal("error should exist")
+	}
+
+	if !errors.As(err, &derr) {
+		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	}
+
+	errs = derr.Unwrap()
+
+	if errs[0].Error() != "cannot parse 'Vuint', -42 overflows uint" {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	inputNegFloatUint := map[string]interface{}{
+		"vuint": -42.0,
+	}
+
+	err = Decode(inputNegFloatUint, &result)
+	if err == nil {
+		t.Fatal("error should exist")
+	}
+
+	if !errors.As(err, &derr) {
+		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	}
+
+	errs = derr.Unwrap()
+
+	if errs[0].Error() != "cannot parse 'Vuint', -42.000000 overflows uint" {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": map[string]interface{}{
+			"vstring": "foo",
+			"Vuint":   42,
+			"vsilent": "false",
+			"foo":     "bar",
+		},
+		"bar": "nil",
+	}
+
+	var md Metadata
+	var result Nested
+
+	err := DecodeMetadata(input, &result, &md)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	expectedKeys := []string{"Vbar", "Vbar.Vstring", "Vbar.Vuint", "Vfoo"}
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	expectedUnused := []string{"Vbar.foo", "Vbar.vsilent", "bar"}
+	sort.Strings(md.Unused)
+	if !reflect.DeepEqual(md.Unused, expectedUnused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	type testResult struct {
+		Vfoo string
+		Vbar BasicPointer
+	}
+
+	input := map[string]interface{}{
+		"vfoo": "foo",
+		"vbar": map[string]interface{}{
+			"vstring": "foo",
+			"Vuint":   42,
+			"vsilent": "false",
+			"foo":     "bar",
+		},
+		"bar": "nil",
+	}
+
+	var md Metadata
+	var result testResult
+	config := &DecoderConfig{
+		Metadata: &md,
+		Result:   &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	expectedKeys := []string{"Vbar", "Vbar.Vstring", "Vbar.Vuint", "Vfoo"}
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	expectedUnused := []string{"Vbar.foo", "Vbar.vsilent", "bar"}
+	sort.Strings(md.Unused)
+	if !reflect.DeepEqual(md.Unused, expectedUnused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+
+	expectedUnset := []string{
+		"Vbar.Vbool", "Vbar.Vdata", "Vbar.Vextra", "Vbar.Vfloat", "Vbar.Vint",
+		"Vbar.VjsonFloat", "Vbar.VjsonInt", "Vbar.VjsonNumber",
+	}
+	sort.Strings(md.Unset)
+	if !reflect.DeepEqual(md.Unset, expectedUnset) {
+		t.Fatalf("bad unset: %#v", md.Unset)
+	}
+}
+
+func TestMetadata_Embedded(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"vstring": "foo",
+		"vunique": "bar",
+	}
+
+	var md Metadata
+	var result EmbeddedSquash
+	config := &DecoderConfig{
+		Metadata: &md,
+		Result:   &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	expectedKeys := []string{"Vstring", "Vunique"}
+
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	expectedUnused := []string{}
+	if !reflect.DeepEqual(md.Unused, expectedUnused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+}
+
+func TestDecoder_DecodeValue(t *testing.T) {
+	t.Parallel()
+
+	type Plugin struct {
+		Name    string
+		Timeout time.Duration
+	}
+	type Host struct {
+		Plugin Plugin
+		Other  int
+	}
+
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var host Host
+	field := reflect.ValueOf(&host).Elem().FieldByName("Plugin")
+	input := map[string]interface{}{"name": "foo", "timeout": "5s"}
+	if err := decoder.DecodeValue(field, input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Host{Plugin: Plugin{Name: "foo", Timeout: 5 * time.Second}}
+	if !reflect.DeepEqual(expected, host) {
+		t.Fatalf("expected %#v, got %#v", expected, host)
+	}
+
+	err = decoder.DecodeValue(reflect.ValueOf(host).Field(0), input)
+	if err == nil || err.Error() != "target of type 'mapstructure.Plugin' must be settable" {
+		t.Fatalf("expected settable error, got %v", err)
+	}
+
+	err = decoder.DecodeValue(reflect.Value{}, input)
+	if err == nil || err.Error() != "target must be a valid value" {
+		t.Fatalf("expected valid error, got %v", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil || err.Error() != "result must be a pointer" {
+		t.Fatalf("expected result error, got %v", err)
+	}
+}
+
+func TestNonPtrValue(t *testing.T) {
+	t.Parallel()
+
+	err := Decode(map[string]interface{}{}, Basic{})
+	if err == nil {
+		t.Fatal("error should exist")
+	}
+
+	if err.Error() != "result must be a pointer" {
+		t.Errorf("got unexpected error: %s", err)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"foo": "bar",
+		"bar": "value",
+	}
+
+	var result Tagged
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result.Value != "bar" {
+		t.Errorf("value should be 'bar', got: %#v", result.Value)
+	}
+
+	if result.Extra != "value" {
+		t.Errorf("extra should be 'value', got: %#v", result.Extra)
+	}
+}
+
+func TestWeakDecode(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"foo": "4",
+		"bar": "value",
+	}
+
+	var result struct {
+		Foo int
+		Bar string
+	}
+
+	if err := WeakDecode(input, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Foo != 4 {
+		t.Fatalf("bad: %#v", result)
+	}
+	if result.Bar != "value" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestWeakDecodeMetadata(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{
+		"foo":        "4",
+		"bar":        "value",
+		"unused":     "value",
+		"unexported": "value",
+	}
+
+	var md Metadata
+	var result struct {
+		Foo        int
+		Bar        string
+		unexported string
+	}
+
+	if err := WeakDecodeMetadata(input, &result, &md); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Foo != 4 {
+		t.Fatalf("bad: %#v", result)
+	}
+	if result.Bar != "value" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	expectedKeys := []string{"Bar", "Foo"}
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual(md.Keys, expectedKeys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	expectedUnused := []string{"unexported", "unused"}
+	sort.Strings(md.Unused)
+	if !reflect.DeepEqual(md.Unused, expectedUnused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+}
+
+func TestDecoder_AllowUnexportedFields(t *testing.T) {
+	t.Parallel()
+
+	type credentials struct {
+		User     string
+		password string `mapstructure:"password"`
+	}
+	type Target struct {
+		Name  string
+		token string       `mapstructure:"token"`
+		port  *int         `mapstructure:"port"`
+		creds credentials  `mapstructure:"creds"`
+		tags  []string     `mapstructure:"tags"`
+		extra *credentials `mapstructure:"extra"`
+	}
+
+	input := map[string]interface{}{
+		"name":  "app",
+		"token": "secret",
+		"port":  8080,
+		"creds": map[string]interface{}{"user": "admin", "password": "hunter2"},
+		"tags":  []string{"a", "b"},
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		AllowUnexportedFields: true,
+		Result:                &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Name != "app" || result.token != "secret" || result.port == nil || *result.port != 8080 {
+		t.Fatalf("bad: %#v", result)
+	}
+	if result.creds != (credentials{User: "admin", password: "hunter2"}) {
+		t.Fatalf("bad creds: %#v", result.creds)
+	}
+	if !reflect.DeepEqual([]string{"a", "b"}, result.tags) || result.extra != nil {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Unexported fields are left untouched by default.
+	var defaultResult Target
+	if err := Decode(input, &defaultResult); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(Target{Name: "app"}, defaultResult) {
+		t.Fatalf("bad: %#v", defaultResult)
+	}
+
+	// Unexported fields without a tag must not be matched.
+	var untagged struct {
+		Name   string
+		secret string
+	}
+	decoder, err = NewDecoder(&DecoderConfig{
+		AllowUnexportedFields: true,
+		Result:                &untagged,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode(map[string]interface{}{"name": "app", "secret": "x"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'secret' matches unexported field 'secret', which must be tagged to be decoded") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if untagged.Name != "app" || untagged.secret != "" {
+		t.Fatalf("bad: %#v", untagged)
+	}
+}
+
+func TestDecoder_DecodeAll(t *testing.T) {
+	t.Parallel()
+
+	type Server struct {
+		Host string
+		Port int
+	}
+	type Config struct {
+		Server  Server
+		Tags    []string
+		Options map[string]interface{}
+		Servers map[string]Server
+	}
+
+	defaults := map[string]interface{}{
+		"server": map[string]interface{}{"host": "localhost", "port": 80},
+		"tags":   []interface{}{"default"},
+		"options": map[string]interface{}{
+			"log": map[string]interface{}{"level": "info", "format": "text"},
+		},
+		"servers": map[string]interface{}{
+			"a": map[string]interface{}{"host": "a.local", "port": 80},
+		},
+	}
+	file := map[string]interface{}{
+		"server": map[string]interface{}{"port": 8080},
+		"tags":   []interface{}{"file"},
+		"options": map[string]interface{}{
+			"log": map[string]interface{}{"level": "debug"},
+		},
+		"servers": map[string]interface{}{
+			"a": map[string]interface{}{"port": 8080},
+			"b": map[string]interface{}{"host": "b.local"},
+		},
+	}
+
+	for _, mode := range []SliceMergeMode{SliceMergeReplace, SliceMergeAppend} {
+		var result Config
+		decoder, err := NewDecoder(&DecoderConfig{
+			SliceMergeMode: mode,
+			Result:         &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := decoder.DecodeAll(defaults, file); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		expected := Config{
+			Server: Server{Host: "localhost", Port: 8080},
+			Tags:   []string{"file"},
+			Options: map[string]interface{}{
+				"log": map[string]interface{}{"level": "debug", "format": "text"},
+			},
+			Servers: map[string]Server{
+				"a": {Host: "a.local", Port: 8080},
+				"b": {Host: "b.local"},
+			},
+		}
+		if mode == SliceMergeAppend {
+			expected.Tags = []string{"default", "file"}
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("mode %d: expected %#v, got %#v", mode, expected, result)
+		}
+	}
+
+	expected := map[string]interface{}{"level": "info", "format": "text"}
+	if actual := defaults["options"].(map[string]interface{})["log"]; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("defaults were modified: %#v", actual)
+	}
+}
+
+func TestDecoder_SliceMergeAppend(t *testing.T) {
+	t.Parallel()
+
+	result := []int{1, 2}
+	original := result
+	decoder, err := NewDecoder(&DecoderConfig{
+		SliceMergeMode: SliceMergeAppend,
+		Result:         &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode([]int{3}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode([]interface{}{4, 5}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual([]int{1, 2, 3, 4, 5}, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+	if !reflect.DeepEqual([]int{1, 2}, original) {
+		t.Fatalf("original slice was modified: %#v", original)
+	}
+}
+
+func TestDecoder_DetectDuplicates(t *testing.T) {
+	t.Parallel()
+
+	type Base struct {
+		Name string
+		Port int
+	}
+	type Target struct {
+		Base    `mapstructure:",squash"`
+		Name    string
+		Nested  Base
+		Address string
+		ADDRESS string
+	}
+
+	input := map[string]interface{}{
+		"name":    "foo",
+		"port":    80,
+		"address": "localhost",
+		"nested": map[string]interface{}{
+			"name": "bar",
+		},
+	}
+
+	var md Metadata
+	var result Target
+	config := &DecoderConfig{
+		DetectDuplicates: true,
+		Metadata:         &md,
+		Result:           &result,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual([]string{"address", "name"}, md.Duplicates) {
+		t.Fatalf("bad duplicates: %#v", md.Duplicates)
+	}
+	if result.Name != "foo" || result.Base.Name != "foo" || result.ADDRESS != "localhost" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	config = &DecoderConfig{
+		ErrorDuplicates: true,
+		Result:          &result,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "'' has keys matching multiple fields: address, name") {
+		t.Fatalf("bad error: %s", err)
+	}
+
+	if err := decoder.Decode(map[string]interface{}{"port": 80}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestDecoder_TrackCoercions(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Name    string
+		Age     int
+		Count   int
+		Enabled bool
+		Tags    []string
+	}
+
+	input := map[string]interface{}{
+		"name":    "foo",
+		"age":     "42",
+		"count":   7.0,
+		"enabled": 1,
+		"tags":    "one",
+	}
+
+	var md Metadata
+	var result Target
+	config := &DecoderConfig{
+		Metadata:         &md,
+		Result:           &result,
+		TrackCoercions:   true,
+		WeaklyTypedInput: true,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sort.Slice(md.Coercions, func(i, j int) bool {
+		return md.Coercions[i].Path < md.Coercions[j].Path
+	})
+
+	expected := []Coercion{
+		{Path: "Age", From: reflect.String, To: reflect.Int},
+		{Path: "Count", From: reflect.Float64, To: reflect.Int},
+		{Path: "Enabled", From: reflect.Int, To: reflect.Bool},
+		{Path: "Tags", From: reflect.String, To: reflect.Slice},
+	}
+	if !reflect.DeepEqual(expected, md.Coercions) {
+		t.Fatalf("bad coercions: %#v", md.Coercions)
+	}
+
+	// Nothing is tracked without the option
+	md = Metadata{}
+	config.TrackCoercions = false
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if md.Coercions != nil {
+		t.Fatalf("bad coercions: %#v", md.Coercions)
+	}
+}
+
+func TestDecode_StructTaggedWithOmitempty_OmitEmptyValues(t *testing.T) {
+	t.Parallel()
+
+	input := &StructWithOmitEmpty{}
+
+	var emptySlice []interface{}
+	var emptyMap map[string]interface{}
+	var emptyNested *Nested
+	expected := &map[string]interface{}{
+		"visible-string": "",
+		"visible-int":    0,
+		"visible-float":  0.0,
+		"visible-slice":  emptySlice,
+		"visible-map":    emptyMap,
+		"visible-nested": emptyNested,
+	}
+
+	actual := &map[string]interface{}{}
+	Decode(input, actual)
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestDecode_StructTaggedWithOmitempty_KeepNonEmptyValues(t *testing.T) {
+	t.Parallel()
+
+	input := &StructWithOmitEmpty{
+		VisibleStringField: "",
+		OmitStringField:    "string",
+		VisibleIntField:    0,
+		OmitIntField:       1,
+		VisibleFloatField:  0.0,
+		OmitFloatField:     1.0,
+		VisibleSliceField:  nil,
+		OmitSliceField:     []interface{}{1},
+		VisibleMapField:    nil,
+		OmitMapField:       map[string]interface{}{"k": "v"},
+		NestedField:        nil,
+		OmitNestedField:    &Nested{},
+	}
+
+	var emptySlice []interface{}
+	var emptyMap map[string]interface{}
+	var emptyNested *Nested
+	expected := &map[string]interface{}{
+		"visible-string":   "",
+		"omittable-string": "string",
+		"visible-int":      0,
+		"omittable-int":    1,
+		"visible-float":    0.0,
+		"omittable-float":  1.0,
+		"visible-slice":    emptySlice,
+		"omittable-slice":  []interface{}{1},
+		"visible-map":      emptyMap,
+		"omittable-map":    map[string]interface{}{"k": "v"},
+		"visible-nested":   emptyNested,
+		"omittable-nested": &Nested{},
+	}
+
+	actual := &map[string]interface{}{}
+	Decode(input, actual)
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestDecode_mapToStruct(t *testing.T) {
+	type Target struct {
+		String    string
+		StringPtr *string
+	}
+
+	expected := Target{
+		String: "hello",
+	}
+
+	var target Target
+	err := Decode(map[string]interface{}{
+		"string":    "hello",
+		"StringPtr": "goodbye",
+	}, &target)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	// Pointers fail reflect test so do those manually
+	if target.StringPtr == nil || *target.StringPtr != "goodbye" {
+		t.Fatalf("bad: %#v", target)
+	}
+	target.StringPtr = nil
+
+	if !reflect.DeepEqual(target, expected) {
+		t.Fatalf("bad: %#v", target)
+	}
+}
+
+func TestDecoder_MatchName(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		FirstMatch  string `mapstructure:"first_match"`
+		SecondMatch string
+		NoMatch     string `mapstructure:"no_match"`
+	}
+
+	input := map[string]interface{}{
+		"first_match": "foo",
+		"SecondMatch": "bar",
+		"NO_MATCH":    "baz",
+	}
+
+	expected := Target{
+		FirstMatch:  "foo",
+		SecondMatch: "bar",
+	}
+
+	var actual Target
+	config := &DecoderConfig{
+		Result: &actual,
+		MatchName: func(mapKey, fieldName string) bool {
+			return mapKey == fieldName
+		},
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestDecoder_MatchNameWithPath(t *testing.T) {
+	t.Parallel()
+
+	type Labels struct {
+		Team string
+		Env  string
+	}
+	type Target struct {
+		Name   string
+		Labels Labels
+		Nested struct {
+			Labels Labels
+		}
+	}
+
+	input := map[string]interface{}{
+		"Name": "foo",
+		"NAME": "ignored",
+		"Labels": map[string]interface{}{
+			"TEAM": "core",
+			"env":  "prod",
+		},
+		"Nested": map[string]interface{}{
+			"Labels": map[string]interface{}{"TEAM": "ignored", "Env": "dev"},
+		},
+	}
+
+	var paths []string
+	var actual Target
+	config := &DecoderConfig{
+		Result: &actual,
+		MatchName: func(mapKey, fieldName string) bool {
+			t.Fatal("MatchName should not be called")
+			return false
+		},
+		// Case-insensitive under the top-level labels, strict elsewhere.
+		MatchNameWithPath: func(path, mapKey, fieldName string) bool {
+			paths = append(paths, path)
+			if path == "Labels" {
+				return strings.EqualFold(mapKey, fieldName)
+			}
+			return mapKey == fieldName
+		},
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{Name: "foo", Labels: Labels{Team: "core", Env: "prod"}}
+	expected.Nested.Labels.Env = "dev"
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+
+	// Keys equal to the field name match without calling the function.
+	seen := make(map[string]struct{})
+	for _, path := range paths {
+		seen[path] = struct{}{}
+	}
+	expectedPaths := map[string]struct{}{"Labels": {}, "Nested.Labels": {}}
+	if !reflect.DeepEqual(expectedPaths, seen) {
+		t.Fatalf("bad paths: %q", paths)
+	}
+}
+
+func TestDecoder_StripFieldPrefix(t *testing.T) {
+	t.Parallel()
+
+	type HTTPConfig struct {
+		HTTPPort    int
+		HTTPTimeout string `mapstructure:"http_timeout"`
+		Host        string
+		HTTP        bool
+	}
+
+	input := map[string]interface{}{
+		"port":         8080,
+		"http_timeout": "5s",
+		"host":         "localhost",
+		"http":         true,
+	}
+
+	var actual HTTPConfig
+	config := &DecoderConfig{
+		Result:           &actual,
+		StripFieldPrefix: "HTTP",
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := HTTPConfig{
+		HTTPPort:    8080,
+		HTTPTimeout: "5s",
+		Host:        "localhost",
+		HTTP:        true,
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestDecoder_StripFieldPrefixCollision(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		HTTPPort int
+		Port     int
+	}
+
+	var actual Config
+	config := &DecoderConfig{
+		Result:           &actual,
+		StripFieldPrefix: "HTTP",
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"port": 8080})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if err.Error() != "'' fields HTTPPort and Port collide after stripping prefix 'HTTP'" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestDecode_StructToStructConcurrent(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		ID   int
+		Tags []string
+	}
+	type Source struct {
+		ID    int
+		Inner Inner
+		Any   Inner
+	}
+	type Target struct {
+		ID    int
+		Inner Inner
+		Any   interface{}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]Target, 50)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			input := Source{ID: i, Inner: Inner{ID: i + 1, Tags: []string{"a"}}, Any: Inner{ID: i + 2}}
+			if err := Decode(input, &results[i]); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every result holds its own values, including the nested map decoded
+	// into the interface field, once later decodes reused the maps.
+	for i, result := range results {
+		expected := Target{
+			ID:    i,
+			Inner: Inner{ID: i + 1, Tags: []string{"a"}},
+			Any:   map[string]interface{}{"ID": i + 2, "Tags": []string(nil)},
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("%d: expected %#v, got %#v", i, expected, result)
+		}
+	}
+}
+
+func TestDecoder_SharedConcurrent(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		Name string
+		Tags []string
+	}
+	type Source struct {
+		Name   string
+		Inner  *Inner
+		Shared *Inner
+		Limits map[string]int
+	}
+	type Target struct {
+		Name   string
+		Inner  *Inner
+		Shared Inner
+		Limits map[string]int `mapstructure:",zero"`
+	}
+
+	// The DecodeHook makes structs go through maps, where pointers are
+	// checked for cycles, and the options make decoding keep track of
+	// depth, interned strings and derived decoders for the fields.
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: func(from, to reflect.Type, data interface{}) (interface{}, error) {
+			return data, nil
+		},
+		MaxDepth:      10,
+		InternStrings: true,
+		TrimStrings:   true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Every goroutine goes through the same pointer, which must not be
+	// taken for a cycle.
+	shared := &Inner{Name: "shared", Tags: []string{"a", "b"}}
+
+	var wg sync.WaitGroup
+	results := make([]Target, 50)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			input := Source{
+				Name:   fmt.Sprintf(" source %d ", i),
+				Inner:  &Inner{Name: "inner", Tags: []string{fmt.Sprint(i)}},
+				Shared: shared,
+				Limits: map[string]int{"max": i},
+			}
+			if err := decoder.DecodeValue(reflect.ValueOf(&results[i]).Elem(), input); err != nil {
+				t.Errorf("%d: err: %s", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		expected := Target{
+			Name:   fmt.Sprintf("source %d", i),
+			Inner:  &Inner{Name: "inner", Tags: []string{fmt.Sprint(i)}},
+			Shared: Inner{Name: "shared", Tags: []string{"a", "b"}},
+			Limits: map[string]int{"max": i},
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("%d: expected %#v, got %#v", i, expected, result)
+		}
+	}
+}
+
+func TestDecoder_WithConfig(t *testing.T) {
+	t.Parallel()
+
+	hooks := map[reflect.Type]DecodeHookFunc{reflect.TypeOf(""): StringToTimeDurationHookFunc()}
+	state := &decodeState{depth: 2}
+	d := &decoder{
+		Decoder: &Decoder{config: &DecoderConfig{TagName: "json"}, merge: true, encode: true, hooks: hooks},
+		state:   state,
+	}
+
+	derived := d.withConfig(func(config *DecoderConfig) { config.ZeroFields = true })
+	if !derived.config.ZeroFields || derived.config.TagName != "json" {
+		t.Fatalf("bad config: %#v", derived.config)
+	}
+	if d.config.ZeroFields {
+		t.Fatal("expected the configuration of the decoder to be left alone")
+	}
+	if !derived.merge || !derived.encode || reflect.ValueOf(derived.hooks).Pointer() != reflect.ValueOf(hooks).Pointer() || derived.state != state {
+		t.Fatalf("expected the derived decoder to keep the rest of the decoder, got %#v", derived)
+	}
+}
+
+func TestDecoder_FlattenHook(t *testing.T) {
+	t.Parallel()
+
+	type Source struct {
+		FirstName string
+		LastName  string
+		Nickname  string `mapstructure:",omitempty"`
+		Password  string
+	}
+	type Target struct {
+		FullName string
+		Nickname string
+		Password string
+	}
+
+	var seen map[string]interface{}
+	config := &DecoderConfig{
+		ErrorUnused: true,
+		FlattenHook: func(m map[string]interface{}) (map[string]interface{}, error) {
+			seen = make(map[string]interface{}, len(m))
+			for k, v := range m {
+				seen[k] = v
+			}
+
+			m["FullName"] = fmt.Sprintf("%s %s", m["FirstName"], m["LastName"])
+			delete(m, "FirstName")
+			delete(m, "LastName")
+			delete(m, "Password")
+			if _, ok := m["Nickname"]; !ok {
+				m["Nickname"] = "none"
+			}
+			return m, nil
+		},
+	}
+
+	var result Target
+	config.Result = &result
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(Source{FirstName: "Jane", LastName: "Doe", Password: "secret"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedSeen := map[string]interface{}{
+		"FirstName": "Jane",
+		"LastName":  "Doe",
+		"Password":  "secret",
+	}
+	if !reflect.DeepEqual(expectedSeen, seen) {
+		t.Fatalf("bad intermediary map: %#v", seen)
+	}
+
+	expected := Target{FullName: "Jane Doe", Nickname: "none"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	config.FlattenHook = func(map[string]interface{}) (map[string]interface{}, error) {
+		return nil, errors.New("boom")
+	}
+	err = decoder.Decode(Source{})
+	if err == nil || err.Error() != "error flattening '': boom" {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestDecoder_StructTimeToString(t *testing.T) {
+	t.Parallel()
+
+	type Source struct {
+		Created time.Time
+		Updated time.Time
+		Deleted time.Time
+	}
+	type Target struct {
+		Created string
+		Updated time.Time
+		Deleted string
+	}
+
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, loc)
+	now := time.Now()
+	if now == now.Round(0) {
+		t.Fatal("expected a monotonic clock reading")
+	}
+
+	cases := []struct {
+		name     string
+		layout   string
+		expected Target
+	}{
+		{
+			"default layout",
+			"",
+			Target{
+				Created: "2024-03-01T12:30:00+02:00",
+				Updated: now.Round(0),
+				Deleted: "0001-01-01T00:00:00Z",
+			},
+		},
+		{
+			"custom layout",
+			"2006-01-02",
+			Target{
+				Created: "2024-03-01",
+				Updated: now.Round(0),
+				Deleted: "0001-01-01",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var actual Target
+			decoder, err := NewDecoder(&DecoderConfig{
+				Result:     &actual,
+				TimeLayout: tc.layout,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if err := decoder.Decode(Source{Created: created, Updated: now}); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("Decode() expected: %#v, got: %#v", tc.expected, actual)
+			}
+		})
+	}
+
+	// Outside of struct flattening, times still aren't decoded into
+	// strings.
+	var s string
+	if err := Decode(created, &s); err == nil {
+		t.Fatalf("expected an error decoding a time into a string, got %q", s)
+	}
+
+	var target Target
+	if err := Decode(map[string]interface{}{"Created": created}, &target); err == nil {
+		t.Fatalf("expected an error decoding a time in a map into a string, got %#v", target)
+	}
+
+	// Nor are they kept as times when decoding a struct into a map.
+	var m map[string]interface{}
+	if err := Decode(Source{Created: created}, &m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(map[string]interface{}{}, m["Created"]) {
+		t.Fatalf("expected an empty map for 'Created', got %#v", m["Created"])
+	}
+
+	// Types defined over time.Time are flattened like time.Time.
+	type Timestamp time.Time
+	type TimestampSource struct {
+		Created Timestamp
+		Updated Timestamp
+	}
+	type TimestampTarget struct {
+		Created string
+		Updated Timestamp
+	}
+
+	// With a hook, the struct is flattened into a map rather than copied.
+	noopHook := func(f, t reflect.Type, data interface{}) (interface{}, error) {
+		return data, nil
+	}
+	for _, hook := range []DecodeHookFunc{nil, noopHook} {
+		var timestamps TimestampTarget
+		decoder, err := NewDecoder(&DecoderConfig{DecodeHook: hook, Result: &timestamps})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := decoder.Decode(TimestampSource{Created: Timestamp(created), Updated: Timestamp(now)}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		expected := TimestampTarget{Created: "2024-03-01T12:30:00+02:00", Updated: Timestamp(now.Round(0))}
+		if !reflect.DeepEqual(expected, timestamps) {
+			t.Fatalf("expected %#v, got %#v", expected, timestamps)
+		}
+	}
+}
+
+func TestDecoder_EncodeFieldName(t *testing.T) {
+	t.Parallel()
+
+	type Source struct {
+		HTTPPort int
+		Name     string `mapstructure:"Label"`
+	}
+	type Target struct {
+		HTTPPort int    `mapstructure:"http_port"`
+		Label    string `mapstructure:"Label"`
+	}
+
+	snakeCase := func(field reflect.StructField) string {
+		var b strings.Builder
+		for i, r := range field.Name {
+			if i > 0 && unicode.IsUpper(r) && i+1 < len(field.Name) && unicode.IsLower(rune(field.Name[i+1])) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		}
+		return b.String()
+	}
+
+	var actual Target
+	config := &DecoderConfig{
+		Result:          &actual,
+		EncodeFieldName: snakeCase,
+		MatchName: func(mapKey, fieldName string) bool {
+			return mapKey == fieldName
+		},
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(Source{HTTPPort: 8080, Name: "foo"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{HTTPPort: 8080, Label: "foo"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestDecoder_IgnoreUntaggedFields(t *testing.T) {
+	type Input struct {
+		UntaggedNumber int
+		TaggedNumber   int `mapstructure:"tagged_number"`
+		UntaggedString string
+		TaggedString   string `mapstructure:"tagged_string"`
+	}
+	input := &Input{
+		UntaggedNumber: 31,
+		TaggedNumber:   42,
+		UntaggedString: "hidden",
+		TaggedString:   "visible",
+	}
+
+	actual := make(map[string]interface{})
+	config := &DecoderConfig{
+		Result:               &actual,
+		IgnoreUntaggedFields: true,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"tagged_number": 42,
+		"tagged_string": "visible",
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, actual)
+	}
+}
+
+func TestDecoder_IgnoreUntaggedFieldsWithStruct(t *testing.T) {
+	type Output struct {
+		UntaggedInt    int
+		TaggedNumber   int `mapstructure:"tagged_number"`
+		UntaggedString string
+		TaggedString   string `mapstructure:"tagged_string"`
+	}
+	input := map[interface{}]interface{}{
+		"untaggedint":     31,
+		"tagged_number":   41,
+		"untagged_string": "hidden",
+		"tagged_string":   "visible",
+	}
+	actual := Output{}
+	config := &DecoderConfig{
+		Result:               &actual,
+		IgnoreUntaggedFields: true,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = decoder.Decode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Output{
+		TaggedNumber: 41,
+		TaggedString: "visible",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, actual)
+	}
+}
+
+func TestDecoder_IgnoreUntaggedFieldsInclude(t *testing.T) {
+	type Output struct {
+		UntaggedInt   int
+		IncludedInt   int    `mapstructure:",include"`
+		TaggedString  string `mapstructure:"tagged_string"`
+		IncludedField string `mapstructure:"included_field,include"`
+	}
+	input := map[string]interface{}{
+		"untaggedint":    31,
+		"includedint":    41,
+		"tagged_string":  "visible",
+		"included_field": "included",
+	}
+
+	cases := []struct {
+		name     string
+		config   DecoderConfig
+		expected Output
+	}{
+		{
+			"IgnoreUntaggedFields",
+			DecoderConfig{IgnoreUntaggedFields: true},
+			Output{IncludedInt: 41, TaggedString: "visible", IncludedField: "included"},
+		},
+		{
+			"OnlyIncludedFields",
+			DecoderConfig{OnlyIncludedFields: true},
+			Output{IncludedInt: 41, IncludedField: "included"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Output{}
+			config := tc.config
+			config.Result = &actual
+
+			decoder, err := NewDecoder(&config)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if err := decoder.Decode(input); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("Decode() expected: %#v\ngot: %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecoder_OnlyIncludedFieldsSquash(t *testing.T) {
+	type Embedded struct {
+		Name  string `mapstructure:"name,include"`
+		Other string `mapstructure:"other"`
+	}
+	type Output struct {
+		Embedded `mapstructure:",squash"`
+		Age      int `mapstructure:"age,include"`
+	}
+
+	input := map[string]interface{}{
+		"name":  "foo",
+		"other": "bar",
+		"age":   42,
+	}
+
+	// Decode to struct
+	var actual Output
+	config := &DecoderConfig{
+		Result:             &actual,
+		OnlyIncludedFields: true,
+	}
+
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Output{Embedded: Embedded{Name: "foo"}, Age: 42}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, actual)
+	}
+
+	// Decode back to map
+	actualMap := map[string]interface{}{}
+	config = &DecoderConfig{
+		Result:             &actualMap,
+		OnlyIncludedFields: true,
+	}
+
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(Output{Embedded: Embedded{Name: "foo", Other: "bar"}, Age: 42}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedMap := map[string]interface{}{"name": "foo", "age": 42}
+	if !reflect.DeepEqual(expectedMap, actualMap) {
+		t.Fatalf("Decode() expected: %#v\ngot: %#v", expectedMap, actualMap)
+	}
+}
+
+func testSliceInput(t *testing.T, input map[string]interface{}, expected *Slice) {
+	var result Slice
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if result.Vfoo != expected.Vfoo {
+		t.Errorf("Vfoo expected '%s', got '%s'", expected.Vfoo, result.Vfoo)
+	}
+
+	if result.Vbar == nil {
+		t.Fatalf("Vbar a slice, got '%#v'", result.Vbar)
+	}
+
+	if len(result.Vbar) != len(expected.Vbar) {
+		t.Errorf("Vbar length should be %d, got %d", len(expected.Vbar), len(result.Vbar))
+	}
+
+	for i, v := range result.Vbar {
+		if v != expected.Vbar[i] {
+			t.Errorf(
+				"Vbar[%d] should be '%#v', got '%#v'",
+				i, expected.Vbar[i], v)
+		}
+	}
+}
+
+func testArrayInput(t *testing.T, input map[string]interface{}, expected *Array) {
+	var result Array
+	err := Decode(input, &result)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if result.Vfoo != expected.Vfoo {
+		t.Errorf("Vfoo expected '%s', got '%s'", expected.Vfoo, result.Vfoo)
+	}
+
+	if result.Vbar == [2]string{} {
+		t.Fatalf("Vbar a slice, got '%#v'", result.Vbar)
+	}
+
+	if len(result.Vbar) != len(expected.Vbar) {
+		t.Errorf("Vbar length should be %d, got %d", len(expected.Vbar), len(result.Vbar))
+	}
+
+	for i, v := range result.Vbar {
+		if v != expected.Vbar[i] {
+			t.Errorf(
+				"Vbar[%d] should be '%#v', got '%#v'",
+				i, expected.Vbar[i], v)
+		}
+	}
+}
+
+type Backend interface {
+	Address() string
+}
+
+type valueBackend struct {
+	Host string
+	Port int
+}
+
+func (b valueBackend) Address() string { return fmt.Sprintf("%s:%d", b.Host, b.Port) }
+
+type pointerBackend struct {
+	Host string
+	Port int
+}
+
+func (b *pointerBackend) Address() string { return fmt.Sprintf("%s:%d", b.Host, b.Port) }
+
+func TestDecode_NonNilInterface(t *testing.T) {
+	t.Parallel()
+
+	type Squashed struct {
+		Backend `mapstructure:",squash"`
+		Name    string
+	}
+	type Nested struct {
+		Primary   Backend
+		Secondary Backend
+	}
+
+	defaultBackend := &pointerBackend{Host: "localhost", Port: 80}
+
+	squashed := Squashed{Backend: valueBackend{Host: "localhost", Port: 80}}
+	input := map[string]interface{}{"port": 8080, "name": "web"}
+	if err := Decode(input, &squashed); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if squashed.Name != "web" || squashed.Address() != "localhost:8080" {
+		t.Fatalf("bad squashed value backend: %#v", squashed)
+	}
+
+	squashed = Squashed{Backend: defaultBackend}
+	if err := Decode(input, &squashed); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if _, ok := squashed.Backend.(*pointerBackend); !ok || squashed.Address() != "localhost:8080" {
+		t.Fatalf("bad squashed pointer backend: %#v", squashed.Backend)
+	}
+
+	nested := Nested{
+		Primary:   valueBackend{Host: "localhost", Port: 80},
+		Secondary: defaultBackend,
+	}
+	input = map[string]interface{}{
+		"primary":   map[string]interface{}{"host": "example.com"},
+		"secondary": map[string]interface{}{"port": 9090},
+	}
+	if err := Decode(input, &nested); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+	if nested.Primary.Address() != "example.com:80" {
+		t.Fatalf("bad primary: %#v", nested.Primary)
+	}
+	if _, ok := nested.Secondary.(*pointerBackend); !ok || nested.Secondary.Address() != "localhost:9090" {
+		t.Fatalf("bad secondary: %#v", nested.Secondary)
+	}
+
+	if defaultBackend.Port != 80 {
+		t.Fatalf("default backend was modified: %#v", defaultBackend)
+	}
+}
+
+type orderedMap struct {
+	Keys   []string
+	Values map[string]interface{}
+}
+
+func (o *orderedMap) SetOrdered(key string, value interface{}) {
+	if o.Values == nil {
+		o.Values = make(map[string]interface{})
+	}
+	o.Keys = append(o.Keys, key)
+	o.Values[key] = value
+}
+
+func TestDecode_OrderedMap(t *testing.T) {
+	t.Parallel()
+
+	type Server struct {
+		Host string
+		Port int
+	}
+	type Target struct {
+		Ordered  orderedMap
+		Pointer  *orderedMap
+		Server   Server
+		Settings map[string]int
+	}
+
+	input := []KV{
+		{Key: "ordered", Value: []KV{
+			{Key: "zeta", Value: 1},
+			{Key: "alpha", Value: []KV{{Key: "nested", Value: true}}},
+			{Key: "mid", Value: "x"},
+		}},
+		{Key: "pointer", Value: map[string]interface{}{"b": 2, "a": 1}},
+		{Key: "server", Value: []KV{
+			{Key: "host", Value: "localhost"},
+			{Key: "port", Value: 80},
+		}},
+		{Key: "settings", Value: []KV{
+			{Key: "x", Value: 1},
+			{Key: "x", Value: 2},
+		}},
+	}
+
+	var md Metadata
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		Metadata: &md,
+		Result:   &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("got an err: %s", err)
+	}
+
+	expected := Target{
+		Ordered: orderedMap{
+			Keys: []string{"zeta", "alpha", "mid"},
+			Values: map[string]interface{}{
+				"zeta":  1,
+				"alpha": []KV{{Key: "nested", Value: true}},
+				"mid":   "x",
 			},
+		},
+		Pointer: &orderedMap{
+			Keys:   []string{"a", "b"},
+			Values: map[string]interface{}{"a": 1, "b": 2},
+		},
+		Server:   Server{Host: "localhost", Port: 80},
+		Settings: map[string]int{"x": 2},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	if len(md.Unused) != 0 {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+
+	err = Decode(map[string]interface{}{"ordered": 42}, &result)
+	if err == nil || !strings.Contains(err.Error(), "'Ordered' expected a map with string keys or []KV, got 'int'") {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+type unmarshalerTags []string
+
+func (t *unmarshalerTags) UnmarshalMapstructure(input interface{}) error {
+	s, ok := input.(string)
+	if !ok {
+		return fmt.Errorf("expected a string, got %T", input)
+	}
+	*t = strings.Split(s, ",")
+	return nil
+}
+
+type mergeUnmarshalerTags []string
+
+func (t *mergeUnmarshalerTags) UnmarshalMapstructure(input interface{}) error {
+	return errors.New("UnmarshalMapstructure should not be called")
+}
+
+func (t *mergeUnmarshalerTags) MergeMapstructure(current, input interface{}) error {
+	var tags []string
+	if err := Decode(input, &tags); err != nil {
+		return err
+	}
+	*t = append(current.(mergeUnmarshalerTags), tags...)
+	return nil
+}
+
+func TestDecode_Unmarshaler(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Tags   unmarshalerTags
+		Layers mergeUnmarshalerTags
+	}
+
+	var md Metadata
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		Metadata: &md,
+		Result:   &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	layers := []map[string]interface{}{
+		{"tags": "a,b", "layers": []string{"base"}},
+		{"tags": "c", "layers": []interface{}{"override"}},
+	}
+	for _, layer := range layers {
+		if err := decoder.Decode(layer); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	expected := Target{
+		Tags:   unmarshalerTags{"c"},
+		Layers: mergeUnmarshalerTags{"base", "override"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual([]string{"Layers", "Layers", "Tags", "Tags"}, md.Keys) {
+		t.Fatalf("bad keys: %#v", md.Keys)
+	}
+
+	err = Decode(map[string]interface{}{"tags": 42}, &result)
+	if err == nil || !strings.Contains(err.Error(), "error decoding 'Tags'") {
+		t.Fatalf("expected an error for 'Tags', got %v", err)
+	}
+}
+
+func TestDecode_RawUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Raw    unmarshalerTags `mapstructure:"raw,rawunmarshal"`
+		Hooked unmarshalerTags `mapstructure:"hooked"`
+		Names  []string        `mapstructure:"names,rawunmarshal"`
+	}
+
+	// The hook competes with the Unmarshaler by turning strings into
+	// slices, which unmarshalerTags doesn't accept.
+	hook := func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if s, ok := data.(string); ok && t.Kind() == reflect.Slice {
+			return strings.Split(s, ","), nil
+		}
+		return data, nil
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{DecodeHook: hook, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{"raw": "a,b", "names": "c,d"}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Raw:   unmarshalerTags{"a", "b"},
+		Names: []string{"c", "d"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	err = decoder.Decode(map[string]interface{}{"hooked": "a,b"})
+	if err == nil || !strings.Contains(err.Error(), "expected a string, got []string") {
+		t.Fatalf("expected the hook to run before the Unmarshaler, got %v", err)
+	}
+}
+
+func TestDecode_ZeroFieldOptions(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Reset  map[string]int       `mapstructure:"reset,zero"`
+		Kept   map[string]int       `mapstructure:"kept,nozero"`
+		Plain  map[string]int       `mapstructure:"plain"`
+		Layers mergeUnmarshalerTags `mapstructure:"layers,zero"`
+	}
+
+	input := map[string]interface{}{
+		"reset":  map[string]int{"b": 2},
+		"kept":   map[string]int{"b": 2},
+		"plain":  map[string]int{"b": 2},
+		"layers": []string{"override"},
+	}
+
+	cases := []struct {
+		zeroFields bool
+		expected   Target
+	}{
+		{
 			false,
+			Target{
+				Reset:  map[string]int{"b": 2},
+				Kept:   map[string]int{"a": 1, "b": 2},
+				Plain:  map[string]int{"a": 1, "b": 2},
+				Layers: mergeUnmarshalerTags{"base", "override"},
+			},
 		},
 		{
-			"struct with map property",
-			&Map{
-				Vfoo:   "vfoo",
-				Vother: map[string]string{"vother": "vother"},
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"Vfoo": "vfoo",
-				"Vother": map[string]string{
-					"vother": "vother",
-				},
+			true,
+			Target{
+				Reset:  map[string]int{"b": 2},
+				Kept:   map[string]int{"a": 1, "b": 2},
+				Plain:  map[string]int{"b": 2},
+				Layers: mergeUnmarshalerTags{"base", "override"},
 			},
-			false,
 		},
+	}
+
+	for _, tc := range cases {
+		result := Target{
+			Reset:  map[string]int{"a": 1},
+			Kept:   map[string]int{"a": 1},
+			Plain:  map[string]int{"a": 1},
+			Layers: mergeUnmarshalerTags{"base"},
+		}
+		decoder, err := NewDecoder(&DecoderConfig{ZeroFields: tc.zeroFields, Result: &result})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := decoder.Decode(input); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(tc.expected, result) {
+			t.Fatalf("ZeroFields %t: expected %#v, got %#v", tc.zeroFields, tc.expected, result)
+		}
+	}
+}
+
+func TestDecode_DisableUnmarshaler(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Tags   unmarshalerTags
+		Layers mergeUnmarshalerTags
+	}
+
+	result := Target{Layers: mergeUnmarshalerTags{"base"}}
+	decoder, err := NewDecoder(&DecoderConfig{
+		DisableUnmarshaler: true,
+		Result:             &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{
+		"tags":   []string{"a,b"},
+		"layers": []string{"override"},
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Tags:   unmarshalerTags{"a,b"},
+		Layers: mergeUnmarshalerTags{"override"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestDecoder_DecodeHooksIntoInterfaces(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Created interface{}
+		Timeout interface{}
+		Name    interface{}
+		Nested  map[string]interface{}
+		Typed   time.Time
+	}
+
+	input := map[string]interface{}{
+		"created": "2024-03-01T12:30:00Z",
+		"timeout": "5s",
+		"name":    "foo",
+		"nested":  map[string]interface{}{"at": "2024-03-01T12:30:00Z"},
+		"typed":   "2024-03-01T12:30:00Z",
+	}
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	hook := ComposeDecodeHookFunc(StringToTimeHookFunc(time.RFC3339), StringToTimeDurationHookFunc())
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook:                hook,
+		DecodeHooksIntoInterfaces: true,
+		InterfaceHookTypes:        []reflect.Type{reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Duration(0))},
+		Result:                    &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Created: created,
+		Timeout: 5 * time.Second,
+		Name:    "foo",
+		Nested:  map[string]interface{}{"at": created},
+		Typed:   created,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	// By default the input is kept as is.
+	result = Target{}
+	decoder, err = NewDecoder(&DecoderConfig{
+		DecodeHook:         hook,
+		InterfaceHookTypes: []reflect.Type{reflect.TypeOf(time.Time{})},
+		Result:             &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Created != "2024-03-01T12:30:00Z" || result.Timeout != "5s" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+type panickingUnmarshaler string
+
+func (u *panickingUnmarshaler) UnmarshalMapstructure(input interface{}) error {
+	if input == "panic" {
+		panic("unmarshaler panicked")
+	}
+	*u = panickingUnmarshaler(fmt.Sprint(input))
+	return nil
+}
+
+func TestDecoder_RecoverFromPanics(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		Name    string
+		Slow    string `mapstructure:",timeout=1s"`
+		Custom  panickingUnmarshaler
+		Renamed string
+	}
+
+	hook := func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		if data == "panic" && to == reflect.TypeOf("") {
+			panic(fmt.Errorf("hook panicked"))
+		}
+		return data, nil
+	}
+
+	cases := []struct {
+		name  string
+		input interface{}
+		path  string
+		err   string
+	}{
 		{
-			"tagged struct",
-			&Tagged{
-				Extra: "extra",
-				Value: "value",
-			},
-			&map[string]string{},
-			&map[string]string{
-				"bar": "extra",
-				"foo": "value",
-			},
-			false,
+			"hook",
+			map[string]interface{}{"name": "panic"},
+			"Name",
+			"error decoding 'Name': panic: hook panicked",
 		},
 		{
-			"omit tag struct",
-			&struct {
-				Value string `mapstructure:"value"`
-				Omit  string `mapstructure:"-"`
-			}{
-				Value: "value",
-				Omit:  "omit",
-			},
-			&map[string]string{},
-			&map[string]string{
-				"value": "value",
-			},
-			false,
+			"hook with timeout",
+			map[string]interface{}{"slow": "panic"},
+			"Slow",
+			"error decoding 'Slow': panic: hook panicked",
 		},
 		{
-			"decode to wrong map type",
-			&struct {
-				Value string
-			}{
-				Value: "string",
-			},
-			&map[string]int{},
-			&map[string]int{},
-			true,
+			"unmarshaler",
+			map[string]interface{}{"custom": "panic"},
+			"Custom",
+			"error decoding 'Custom': panic: unmarshaler panicked",
 		},
 		{
-			"remainder",
-			map[string]interface{}{
-				"A": "hello",
-				"B": "goodbye",
-				"C": "yo",
-			},
-			&Remainder{},
-			&Remainder{
-				A: "hello",
-				Extra: map[string]interface{}{
-					"B": "goodbye",
-					"C": "yo",
+			"flatten hook",
+			struct{ Name string }{"flatten"},
+			"",
+			"error flattening '': panic: flatten hook panicked",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var result Target
+			decoder, err := NewDecoder(&DecoderConfig{
+				DecodeHook: hook,
+				FlattenHook: func(m map[string]interface{}) (map[string]interface{}, error) {
+					if m["Name"] == "flatten" {
+						panic("flatten hook panicked")
+					}
+					return m, nil
 				},
-			},
-			false,
+				RecoverFromPanics: true,
+				Result:            &result,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			err = decoder.Decode(tc.input)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+
+			var panicErr *PanicError
+			if !errors.As(err, &panicErr) {
+				t.Fatalf("expected a PanicError, got %#v", err)
+			}
+			if panicErr.Path != tc.path {
+				t.Fatalf("expected path %q, got %q", tc.path, panicErr.Path)
+			}
+		})
+	}
+
+	// Inputs the hooks don't panic on are decoded as usual.
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook:        hook,
+		RecoverFromPanics: true,
+		Result:            &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := map[string]interface{}{"name": "foo", "slow": "bar", "custom": 1}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{Name: "foo", Slow: "bar", Custom: "1"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestDecode_MaxDepth(t *testing.T) {
+	t.Parallel()
+
+	type Node struct {
+		Value int
+		Child *Node
+	}
+
+	// Five nested structs.
+	input := map[string]interface{}{"value": 5}
+	for i := 4; i > 0; i-- {
+		input = map[string]interface{}{"value": i, "child": input}
+	}
+
+	var result Node
+	config := &DecoderConfig{MaxDepth: 5, Result: &result}
+	decoder, err := NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Child.Child.Child.Child.Value != 5 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	result = Node{}
+	config.MaxDepth = 4
+	decoder, err = NewDecoder(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode(input)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := "'Child.Child.Child.Child' exceeds the maximum depth of 4"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected error containing %q, got %q", expected, err)
+	}
+
+	// Slices count as well.
+	var nested [][][]int
+	err = Decode([]interface{}{[]interface{}{[]interface{}{1}}}, &nested)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	decoder, err = NewDecoder(&DecoderConfig{MaxDepth: 2, Result: &nested})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode([]interface{}{[]interface{}{[]interface{}{1}}})
+	if err == nil || !strings.Contains(err.Error(), "'[0][0]' exceeds the maximum depth of 2") {
+		t.Fatalf("expected a maximum depth error, got %v", err)
+	}
+}
+
+func TestDecode_NonStringMapKeys(t *testing.T) {
+	t.Parallel()
+
+	type Target struct {
+		First  string `mapstructure:"1"`
+		Second int    `mapstructure:"2"`
+		Name   string
+		Codes  map[int]string
+	}
+
+	input := map[interface{}]interface{}{
+		1:       "one",
+		uint(2): 2,
+		"name":  "foo",
+		"codes": map[int]string{404: "not found"},
+	}
+
+	var result Target
+	if err := Decode(input, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		First:  "one",
+		Second: 2,
+		Name:   "foo",
+		Codes:  map[int]string{404: "not found"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	// Unused keys are reported in their string form.
+	var md Metadata
+	result = Target{}
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[int]interface{}{1: "one", 3: "three"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.First != "one" {
+		t.Fatalf("bad: %#v", result)
+	}
+	if !reflect.DeepEqual(md.Unused, []string{"3"}) {
+		t.Fatalf("bad unused: %#v", md.Unused)
+	}
+
+	// Keys with the same string form are ambiguous.
+	err = Decode(map[interface{}]interface{}{1: "one", "1": "uno"}, &result)
+	if err == nil || !strings.Contains(err.Error(), "more than one key with the string form '1'") {
+		t.Fatalf("expected an ambiguous key error, got %v", err)
+	}
+}
+
+func TestDecode_MetadataMapKeys(t *testing.T) {
+	t.Parallel()
+
+	type Service struct {
+		Port  int
+		Hosts []string
+	}
+
+	type Target struct {
+		Services map[string]Service
+		Limits   map[string]map[string]int
+	}
+
+	input := map[string]interface{}{
+		"services": map[string]interface{}{
+			"web": map[string]interface{}{"port": 80, "hosts": []string{"a"}},
+			"db":  map[string]interface{}{"port": 5432},
 		},
-		{
-			"remainder with no extra",
-			map[string]interface{}{
-				"A": "hello",
-			},
-			&Remainder{},
-			&Remainder{
-				A:     "hello",
-				Extra: nil,
-			},
-			false,
+		"limits": map[string]interface{}{
+			"cpu": map[string]interface{}{"max": 2},
+		},
+	}
+
+	var md Metadata
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{Metadata: &md, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"Limits",
+		"Limits.cpu",
+		"Limits.cpu.max",
+		"Services",
+		"Services.db",
+		"Services.db.Port",
+		"Services.web",
+		"Services.web.Hosts",
+		"Services.web.Hosts[0]",
+		"Services.web.Port",
+	}
+	sort.Strings(md.Keys)
+	if !reflect.DeepEqual(expected, md.Keys) {
+		t.Fatalf("expected %#v, got %#v", expected, md.Keys)
+	}
+
+	err = Decode(map[string]interface{}{
+		"services": map[string]interface{}{"web": map[string]interface{}{"port": "x"}},
+	}, &result)
+	if err == nil || !strings.Contains(err.Error(), "'Services.web.Port'") {
+		t.Fatalf("expected an error for 'Services.web.Port', got %v", err)
+	}
+}
+
+type genericBox[T any] struct {
+	Value T      `mapstructure:"value"`
+	Label string `mapstructure:"label"`
+}
+
+type genericPair[K comparable, V any] struct {
+	Entries map[K]V
+	Default *V `mapstructure:"default"`
+}
+
+type genericEnvelope[T any] struct {
+	genericBox[T] `mapstructure:",squash"`
+	Kind          string
+}
+
+func TestDecode_GenericStructs(t *testing.T) {
+	t.Parallel()
+
+	var ints genericBox[int]
+	if err := Decode(map[string]interface{}{"value": 42, "label": "answer"}, &ints); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(genericBox[int]{Value: 42, Label: "answer"}, ints) {
+		t.Fatalf("bad: %#v", ints)
+	}
+
+	var structs genericBox[Basic]
+	if err := Decode(map[string]interface{}{"value": map[string]interface{}{"vstring": "foo"}}, &structs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if structs.Value.Vstring != "foo" {
+		t.Fatalf("bad: %#v", structs)
+	}
+
+	var slices genericBox[[]string]
+	if err := Decode(map[string]interface{}{"value": []interface{}{"a", "b"}}, &slices); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual([]string{"a", "b"}, slices.Value) {
+		t.Fatalf("bad: %#v", slices)
+	}
+
+	var pair genericPair[string, float64]
+	if err := Decode(map[string]interface{}{
+		"entries": map[string]interface{}{"pi": 3.14},
+		"default": 1,
+	}, &pair); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if pair.Entries["pi"] != 3.14 || pair.Default == nil || *pair.Default != 1 {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	var envelope genericEnvelope[bool]
+	if err := Decode(map[string]interface{}{"value": true, "label": "flag", "kind": "toggle"}, &envelope); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := genericEnvelope[bool]{genericBox: genericBox[bool]{Value: true, Label: "flag"}, Kind: "toggle"}
+	if !reflect.DeepEqual(expected, envelope) {
+		t.Fatalf("expected %#v, got %#v", expected, envelope)
+	}
+
+	// Hooks see the instantiated type of the field.
+	var durations genericBox[time.Duration]
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: StringToTimeDurationHookFunc(),
+		Result:     &durations,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"value": "5s"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if durations.Value != 5*time.Second {
+		t.Fatalf("bad: %#v", durations)
+	}
+
+	// Generic structs decode from each other like any other structs.
+	var texts genericBox[string]
+	if err := WeakDecode(ints, &texts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(genericBox[string]{Value: "42", Label: "answer"}, texts) {
+		t.Fatalf("bad: %#v", texts)
+	}
+
+	err = Decode(map[string]interface{}{"value": "x"}, &ints)
+	if err == nil || !strings.Contains(err.Error(), "'value' expected type 'int'") {
+		t.Fatalf("expected an error for 'value', got %v", err)
+	}
+}
+
+func TestDecode_ErrorNamesResultType(t *testing.T) {
+	t.Parallel()
+
+	type TLS struct {
+		Cert string
+	}
+	type ServerConfig struct {
+		Port int
+		TLS  TLS
+	}
+
+	var result ServerConfig
+	err := Decode(map[string]interface{}{"tls": map[string]interface{}{"cert": 1}}, &result)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := "decoding into 'mapstructure.ServerConfig' failed due to the following error(s):\n\n" +
+		"'TLS.Cert' expected type 'string'"
+	if !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected error starting with %q, got %q", expected, err)
+	}
+
+	// The wrapped errors are still available.
+	var typeErr *UnconvertibleTypeError
+	if !errors.As(err, &typeErr) || typeErr.Path != "TLS.Cert" {
+		t.Fatalf("expected an UnconvertibleTypeError for 'TLS.Cert', got %#v", err)
+	}
+
+	var port int
+	err = Decode("x", &port)
+	if err == nil || !strings.HasPrefix(err.Error(), "'' expected type 'int'") {
+		t.Fatalf("expected a single error to be returned as is, got %v", err)
+	}
+}
+
+func TestDecode_MapValueTemplate(t *testing.T) {
+	t.Parallel()
+
+	type Inner struct {
+		Host string
+		Port int
+	}
+
+	type Target struct {
+		Pointers map[string]*Inner
+		Values   map[string]Inner
+	}
+
+	templates := map[reflect.Type]func() interface{}{
+		reflect.TypeOf(&Inner{}): func() interface{} { return &Inner{Host: "localhost", Port: 80} },
+		reflect.TypeOf(Inner{}):  func() interface{} { return Inner{Host: "localhost", Port: 80} },
+	}
+
+	input := map[string]interface{}{
+		"pointers": map[string]interface{}{
+			"a": map[string]interface{}{"port": 8080},
+			"b": map[string]interface{}{"host": "example.com"},
 		},
-		{
-			"struct with omitempty tag return non-empty values",
-			&struct {
-				VisibleField interface{} `mapstructure:"visible"`
-				OmitField    interface{} `mapstructure:"omittable,omitempty"`
-			}{
-				VisibleField: nil,
-				OmitField:    "string",
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{"visible": nil, "omittable": "string"},
-			false,
+		"values": map[string]interface{}{
+			"c": map[string]interface{}{"port": 9090},
 		},
-		{
-			"struct with omitempty tag ignore empty values",
-			&struct {
-				VisibleField interface{} `mapstructure:"visible"`
-				OmitField    interface{} `mapstructure:"omittable,omitempty"`
-			}{
-				VisibleField: nil,
-				OmitField:    nil,
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{"visible": nil},
-			false,
+	}
+
+	var result Target
+	decoder, err := NewDecoder(&DecoderConfig{MapValueTemplate: templates, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Target{
+		Pointers: map[string]*Inner{
+			"a": {Host: "localhost", Port: 8080},
+			"b": {Host: "example.com", Port: 80},
 		},
-		{
-			"remainder with decode to map",
-			&Remainder{
-				A: "Alabasta",
-				Extra: map[string]interface{}{
-					"B": "Baratie",
-					"C": "Cocoyasi",
-				},
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"A": "Alabasta",
-				"B": "Baratie",
-				"C": "Cocoyasi",
-			},
-			false,
+		Values: map[string]Inner{
+			"c": {Host: "localhost", Port: 9090},
 		},
-		{
-			"remainder with decode to map with non-map field",
-			&struct {
-				A     string
-				Extra *struct{} `mapstructure:",remain"`
-			}{
-				A:     "Alabasta",
-				Extra: nil,
-			},
-			&map[string]interface{}{},
-			&map[string]interface{}{
-				"A": "Alabasta",
-			},
-			true,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+	if result.Pointers["a"] == result.Pointers["b"] {
+		t.Fatal("expected each entry to have its own value")
+	}
+
+	// ZeroFields takes precedence over the templates.
+	result = Target{}
+	decoder, err = NewDecoder(&DecoderConfig{MapValueTemplate: templates, ZeroFields: true, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if *result.Pointers["a"] != (Inner{Port: 8080}) {
+		t.Fatalf("bad: %#v", result.Pointers["a"])
+	}
+
+	decoder, err = NewDecoder(&DecoderConfig{
+		MapValueTemplate: map[reflect.Type]func() interface{}{
+			reflect.TypeOf(&Inner{}): func() interface{} { return Inner{} },
 		},
+		Result: &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
+	err = decoder.Decode(input)
+	if err == nil || !strings.Contains(err.Error(), "MapValueTemplate for '*mapstructure.Inner' returned a value of type 'mapstructure.Inner'") {
+		t.Fatalf("expected a template type error, got %v", err)
+	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := Decode(tt.in, tt.target); (err != nil) != tt.wantErr {
-				t.Fatalf("%q: TestMapOutputForStructuredInputs() unexpected error: %s", tt.name, err)
-			}
+func TestDecode_InternStrings(t *testing.T) {
+	t.Parallel()
 
-			if !reflect.DeepEqual(tt.out, tt.target) {
-				t.Fatalf("%q: TestMapOutputForStructuredInputs() expected: %#v, got: %#v", tt.name, tt.out, tt.target)
-			}
-		})
+	type Record struct {
+		ID     int
+		Status string
+	}
+
+	// Distinct copies of the same status, as a parser would produce.
+	input := make([]interface{}, 3)
+	for i := range input {
+		input[i] = map[string]interface{}{"id": i, "status": string([]byte("active"))}
+	}
+
+	var result []Record
+	decoder, err := NewDecoder(&DecoderConfig{InternStrings: true, Result: &result})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []Record{{0, "active"}, {1, "active"}, {2, "active"}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+
+	data := func(s string) uintptr {
+		return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	}
+	for _, r := range result[1:] {
+		if data(r.Status) != data(result[0].Status) {
+			t.Fatalf("expected %q to share the storage of the first record", r.Status)
+		}
 	}
 }
 
-func TestInvalidType(t *testing.T) {
+func TestDecode_OnUnused(t *testing.T) {
 	t.Parallel()
 
+	type Server struct {
+		Host string
+	}
+	type Config struct {
+		Name   string
+		Server Server
+	}
+
 	input := map[string]interface{}{
-		"vstring": 42,
+		"name":  "foo",
+		"extra": 1,
+		"server": map[string]interface{}{
+			"host": "localhost",
+			"port": 80,
+			"bind": "0.0.0.0",
+		},
 	}
 
-	var result Basic
-	err := Decode(input, &result)
-	if err == nil {
-		t.Fatal("error should exist")
+	var md Metadata
+	var result Config
+	unused := map[string]interface{}{}
+	var paths []string
+	decoder, err := NewDecoder(&DecoderConfig{
+		OnUnused: func(path string, value interface{}) error {
+			paths = append(paths, path)
+			unused[path] = value
+			return nil
+		},
+		Metadata: &md,
+		Result:   &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
 	}
 
-	var derr interface {
-		Unwrap() []error
+	expected := map[string]interface{}{"extra": 1, "Server.bind": "0.0.0.0", "Server.port": 80}
+	if !reflect.DeepEqual(expected, unused) {
+		t.Fatalf("expected %#v, got %#v", expected, unused)
+	}
+	if !reflect.DeepEqual([]string{"Server.bind", "Server.port", "extra"}, paths) {
+		t.Fatalf("bad order: %#v", paths)
+	}
+	sort.Strings(md.Unused)
+	if !reflect.DeepEqual([]string{"Server.bind", "Server.port", "extra"}, md.Unused) {
+		t.Fatalf("bad unused: %#v", md.Unused)
 	}
 
-	if !errors.As(err, &derr) {
-		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	errDeprecated := errors.New("deprecated")
+	decoder, err = NewDecoder(&DecoderConfig{
+		OnUnused: func(path string, value interface{}) error {
+			if path == "Server.port" {
+				return errDeprecated
+			}
+			return nil
+		},
+		Result: &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode(input)
+	if !errors.Is(err, errDeprecated) || !strings.Contains(err.Error(), "unused key 'Server.port': deprecated") {
+		t.Fatalf("expected the error of OnUnused, got %v", err)
 	}
 
-	errs := derr.Unwrap()
+	// The error is joined with the other errors, and OnUnused isn't called
+	// for the remaining keys of the struct.
+	paths = nil
+	decoder, err = NewDecoder(&DecoderConfig{
+		OnUnused: func(path string, value interface{}) error {
+			paths = append(paths, path)
+			return errDeprecated
+		},
+		ErrorUnused: true,
+		Result:      &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = decoder.Decode(map[string]interface{}{
+		"name":  []int{1},
+		"extra": 1,
+		"other": 2,
+	})
+	if !errors.Is(err, errDeprecated) || !strings.Contains(err.Error(), "'Name' expected type 'string'") {
+		t.Fatalf("expected the error of OnUnused and of Name, got %v", err)
+	}
+	var unusedErr *UnusedKeysError
+	if !errors.As(err, &unusedErr) {
+		t.Fatalf("expected an UnusedKeysError, got %v", err)
+	}
+	if !reflect.DeepEqual([]string{"extra"}, paths) {
+		t.Fatalf("bad paths: %#v", paths)
+	}
+}
 
-	if errs[0].Error() != "'Vstring' expected type 'string', got unconvertible type 'int', value: '42'" {
-		t.Errorf("got unexpected error: %s", err)
+func TestDecode_OnUnset(t *testing.T) {
+	t.Parallel()
+
+	type TLS struct {
+		Enabled bool
+		Cert    string
+		Key     string
+	}
+	type Config struct {
+		Name string
+		Port int
+		TLS  TLS
+	}
+
+	errRequired := errors.New("required")
+	decode := func(input map[string]interface{}) ([]string, error) {
+		var paths []string
+		var result Config
+		decoder, err := NewDecoder(&DecoderConfig{
+			// Require the certificate and key only if TLS is enabled.
+			OnUnset: func(path string) error {
+				paths = append(paths, path)
+				tls, _ := input["tls"].(map[string]interface{})
+				if enabled, _ := tls["enabled"].(bool); enabled && (path == "TLS.Cert" || path == "TLS.Key") {
+					return errRequired
+				}
+				return nil
+			},
+			Result: &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return paths, decoder.Decode(input)
 	}
 
-	inputNegIntUint := map[string]interface{}{
-		"vuint": -42,
+	paths, err := decode(map[string]interface{}{
+		"name": "api",
+		"tls":  map[string]interface{}{"enabled": false},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual([]string{"TLS.Cert", "TLS.Key", "Port"}, paths) {
+		t.Fatalf("bad paths: %#v", paths)
 	}
 
-	err = Decode(inputNegIntUint, &result)
-	if err == nil {
-		t.Fatal("error should exist")
+	_, err = decode(map[string]interface{}{
+		"name": "api",
+		"tls":  map[string]interface{}{"enabled": true, "cert": "cert.pem"},
+	})
+	if !errors.Is(err, errRequired) || !strings.Contains(err.Error(), "unset field 'TLS.Key': required") {
+		t.Fatalf("expected the error of OnUnset, got %v", err)
 	}
 
-	if !errors.As(err, &derr) {
-		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	// The error is joined with the other errors, and OnUnset isn't called
+	// for the remaining fields of the struct.
+	paths, err = decode(map[string]interface{}{
+		"name": []int{1},
+		"tls":  map[string]interface{}{"enabled": true},
+	})
+	if !errors.Is(err, errRequired) || !strings.Contains(err.Error(), "'Name' expected type 'string'") {
+		t.Fatalf("expected the error of OnUnset and of Name, got %v", err)
+	}
+	if !reflect.DeepEqual([]string{"TLS.Cert", "Port"}, paths) {
+		t.Fatalf("bad paths: %#v", paths)
+	}
+
+	paths, err = decode(map[string]interface{}{
+		"name": "api",
+		"port": 443,
+		"tls":  map[string]interface{}{"enabled": true, "cert": "cert.pem", "key": "key.pem"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(paths) != 0 {
+		t.Fatalf("bad paths: %#v", paths)
+	}
+
+	// The paths are those recorded in the metadata.
+	var md Metadata
+	var result Config
+	var paths2 []string
+	decoder, err := NewDecoder(&DecoderConfig{
+		OnUnset: func(path string) error {
+			paths2 = append(paths2, path)
+			return nil
+		},
+		Metadata: &md,
+		Result:   &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"tls": map[string]interface{}{}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	sort.Strings(paths2)
+	sort.Strings(md.Unset)
+	if !reflect.DeepEqual(md.Unset, paths2) {
+		t.Fatalf("expected %#v, got %#v", md.Unset, paths2)
 	}
+}
 
-	errs = derr.Unwrap()
+func TestDecode_MaxSliceLen(t *testing.T) {
+	t.Parallel()
 
-	if errs[0].Error() != "cannot parse 'Vuint', -42 overflows uint" {
-		t.Errorf("got unexpected error: %s", err)
+	type Target struct {
+		Hosts  []string
+		Ports  []int `mapstructure:"ports,maxlen=2"`
+		Labels map[string]string
 	}
 
-	inputNegFloatUint := map[string]interface{}{
-		"vuint": -42.0,
+	decode := func(input map[string]interface{}) error {
+		var result Target
+		decoder, err := NewDecoder(&DecoderConfig{MaxSliceLen: 3, Result: &result})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return decoder.Decode(input)
 	}
 
-	err = Decode(inputNegFloatUint, &result)
-	if err == nil {
-		t.Fatal("error should exist")
+	err := decode(map[string]interface{}{
+		"hosts":  []string{"a", "b", "c"},
+		"ports":  []int{1, 2},
+		"labels": map[string]string{"a": "1", "b": "2", "c": "3"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
 
-	if !errors.As(err, &derr) {
-		t.Fatalf("error should be a type implementing Unwrap() []error, instead: %#v", err)
+	cases := []struct {
+		input    map[string]interface{}
+		expected string
+	}{
+		{
+			map[string]interface{}{"hosts": []string{"a", "b", "c", "d"}},
+			"'Hosts' has 4 elements, more than the maximum of 3",
+		},
+		{
+			map[string]interface{}{"ports": []interface{}{1, 2, 3}},
+			"'ports' has 3 elements, more than the maximum of 2",
+		},
+		{
+			map[string]interface{}{"labels": map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}},
+			"'Labels' has 4 elements, more than the maximum of 3",
+		},
 	}
 
-	errs = derr.Unwrap()
+	for _, tc := range cases {
+		err := decode(tc.input)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected error containing %q, got %v", tc.expected, err)
+		}
+	}
 
-	if errs[0].Error() != "cannot parse 'Vuint', -42.000000 overflows uint" {
-		t.Errorf("got unexpected error: %s", err)
+	var invalid struct {
+		Hosts []string `mapstructure:"hosts,maxlen=many"`
+	}
+	err = Decode(map[string]interface{}{"hosts": []string{"a"}}, &invalid)
+	if err == nil || !strings.Contains(err.Error(), "'hosts' has invalid maxlen 'many'") {
+		t.Fatalf("expected an invalid maxlen error, got %v", err)
 	}
 }
 
-func TestDecodeMetadata(t *testing.T) {
+func TestDecode_TypedHooks(t *testing.T) {
 	t.Parallel()
 
+	type Level int
+
+	type Target struct {
+		Timeout time.Duration
+		Level   Level
+		Name    string
+		Tags    []string
+	}
+
+	levelHook := func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		switch data {
+		case "debug":
+			return 0, nil
+		case "info":
+			return 1, nil
+		}
+		return data, nil
+	}
+	// The DecodeHook runs after the typed hooks and for all other types.
+	upper := func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if s, ok := data.(string); ok && t.Kind() == reflect.String {
+			return strings.ToUpper(s), nil
+		}
+		return data, nil
+	}
+
 	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": map[string]interface{}{
-			"vstring": "foo",
-			"Vuint":   42,
-			"vsilent": "false",
-			"foo":     "bar",
+		"timeout": "5s",
+		"level":   "info",
+		"name":    "api",
+		"tags":    []string{"a"},
+	}
+	expected := Target{Timeout: 5 * time.Second, Level: 1, Name: "API", Tags: []string{"A"}}
+
+	var typed Target
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: upper,
+		TypedHooks: map[reflect.Type]DecodeHookFunc{
+			reflect.TypeOf(time.Duration(0)): StringToTimeDurationHookFunc(),
+			reflect.TypeOf(Level(0)):         levelHook,
 		},
-		"bar": "nil",
+		Result: &typed,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(expected, typed) {
+		t.Fatalf("expected %#v, got %#v", expected, typed)
 	}
 
-	var md Metadata
-	var result Nested
-
-	err := DecodeMetadata(input, &result, &md)
+	var composed Target
+	decoder, err = NewDecoder(&DecoderConfig{
+		DecodeHook: ComposeDecodeHookFunc(
+			TypedHook(reflect.TypeOf(time.Duration(0)), StringToTimeDurationHookFunc()),
+			TypedHook(reflect.TypeOf(Level(0)), levelHook),
+			upper,
+		),
+		Result: &composed,
+	})
 	if err != nil {
-		t.Fatalf("err: %s", err.Error())
+		t.Fatalf("err: %s", err)
 	}
-
-	expectedKeys := []string{"Vbar", "Vbar.Vstring", "Vbar.Vuint", "Vfoo"}
-	sort.Strings(md.Keys)
-	if !reflect.DeepEqual(md.Keys, expectedKeys) {
-		t.Fatalf("bad keys: %#v", md.Keys)
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(typed, composed) {
+		t.Fatalf("expected %#v, got %#v", typed, composed)
 	}
 
-	expectedUnused := []string{"Vbar.foo", "Vbar.vsilent", "bar"}
-	sort.Strings(md.Unused)
-	if !reflect.DeepEqual(md.Unused, expectedUnused) {
-		t.Fatalf("bad unused: %#v", md.Unused)
+	err = decoder.Decode(map[string]interface{}{"timeout": "soon"})
+	if err == nil || !strings.Contains(err.Error(), "error decoding 'Timeout'") {
+		t.Fatalf("expected an error for 'Timeout', got %v", err)
 	}
 }
 
-func TestMetadata(t *testing.T) {
+func TestDecoder_NamedHooks(t *testing.T) {
 	t.Parallel()
 
-	type testResult struct {
-		Vfoo string
-		Vbar BasicPointer
+	unixHook := func(unit time.Duration) DecodeHookFuncType {
+		return func(f, t reflect.Type, data interface{}) (interface{}, error) {
+			if f.Kind() != reflect.Int || t != reflect.TypeOf(time.Time{}) {
+				return data, nil
+			}
+			return time.Unix(0, int64(data.(int))*int64(unit)).UTC(), nil
+		}
 	}
 
-	input := map[string]interface{}{
-		"vfoo": "foo",
-		"vbar": map[string]interface{}{
-			"vstring": "foo",
-			"Vuint":   42,
-			"vsilent": "false",
-			"foo":     "bar",
-		},
-		"bar": "nil",
+	type Event struct {
+		CreatedAt time.Time `mapstructure:"created_at,hook=unixms"`
+		UpdatedAt time.Time `mapstructure:"updated_at,hook=unix"`
+		Retries   int       `mapstructure:"retries"`
 	}
 
-	var md Metadata
-	var result testResult
-	config := &DecoderConfig{
-		Metadata: &md,
-		Result:   &result,
+	hooks := map[string]DecodeHookFunc{
+		"unixms": unixHook(time.Millisecond),
+		"unix":   unixHook(time.Second),
 	}
 
-	decoder, err := NewDecoder(config)
+	var result Event
+	decoder, err := NewDecoder(&DecoderConfig{
+		NamedHooks: hooks,
+		Result:     &result,
+	})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	err = decoder.Decode(input)
+	err = decoder.Decode(map[string]interface{}{
+		"created_at": 1700000000123,
+		"updated_at": 1700000000,
+		"retries":    3,
+	})
 	if err != nil {
-		t.Fatalf("err: %s", err.Error())
+		t.Fatalf("err: %s", err)
 	}
 
-	expectedKeys := []string{"Vbar", "Vbar.Vstring", "Vbar.Vuint", "Vfoo"}
-	sort.Strings(md.Keys)
-	if !reflect.DeepEqual(md.Keys, expectedKeys) {
-		t.Fatalf("bad keys: %#v", md.Keys)
+	expected := Event{
+		CreatedAt: time.Date(2023, 11, 14, 22, 13, 20, 123000000, time.UTC),
+		UpdatedAt: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+		Retries:   3,
 	}
-
-	expectedUnused := []string{"Vbar.foo", "Vbar.vsilent", "bar"}
-	sort.Strings(md.Unused)
-	if !reflect.DeepEqual(md.Unused, expectedUnused) {
-		t.Fatalf("bad unused: %#v", md.Unused)
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 
-	expectedUnset := []string{
-		"Vbar.Vbool", "Vbar.Vdata", "Vbar.Vextra", "Vbar.Vfloat", "Vbar.Vint",
-		"Vbar.VjsonFloat", "Vbar.VjsonInt", "Vbar.VjsonNumber",
-	}
-	sort.Strings(md.Unset)
-	if !reflect.DeepEqual(md.Unset, expectedUnset) {
-		t.Fatalf("bad unset: %#v", md.Unset)
+	type Outer struct {
+		Events []Event
 	}
-}
 
-func TestMetadata_Embedded(t *testing.T) {
-	t.Parallel()
-
-	input := map[string]interface{}{
-		"vstring": "foo",
-		"vunique": "bar",
+	_, err = NewDecoder(&DecoderConfig{
+		NamedHooks: map[string]DecodeHookFunc{"unixms": hooks["unixms"]},
+		Result:     &Outer{},
+	})
+	if err == nil || !strings.Contains(err.Error(), "field 'UpdatedAt' of struct 'mapstructure.Event' has unknown hook 'unix'") {
+		t.Fatalf("expected an unknown hook error, got %v", err)
 	}
 
-	var md Metadata
-	var result EmbeddedSquash
-	config := &DecoderConfig{
-		Metadata: &md,
-		Result:   &result,
+	type Named struct {
+		A string `mapstructure:"a,hook=nope"`
 	}
 
-	decoder, err := NewDecoder(config)
+	decoder, err = NewDecoder(&DecoderConfig{NamedHooks: hooks})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	err = decoder.Decode(input)
-	if err != nil {
-		t.Fatalf("err: %s", err.Error())
-	}
-
-	expectedKeys := []string{"Vstring", "Vunique"}
-
-	sort.Strings(md.Keys)
-	if !reflect.DeepEqual(md.Keys, expectedKeys) {
-		t.Fatalf("bad keys: %#v", md.Keys)
+	var named Named
+	err = decoder.DecodeValue(reflect.ValueOf(&named).Elem(), map[string]interface{}{"a": "x"})
+	if err == nil || err.Error() != "field 'A' of struct 'mapstructure.Named' has unknown hook 'nope'" {
+		t.Fatalf("expected an unknown hook error, got %v", err)
 	}
-
-	expectedUnused := []string{}
-	if !reflect.DeepEqual(md.Unused, expectedUnused) {
-		t.Fatalf("bad unused: %#v", md.Unused)
+	if named.A != "" {
+		t.Fatalf("expected the target to be untouched, got %#v", named)
 	}
 }
 
-func TestNonPtrValue(t *testing.T) {
+func TestDecoder_PointerFieldsPresence(t *testing.T) {
 	t.Parallel()
 
-	err := Decode(map[string]interface{}{}, Basic{})
-	if err == nil {
-		t.Fatal("error should exist")
+	type Options struct {
+		Enabled *bool
+		Count   *int
+		Name    *string
 	}
 
-	if err.Error() != "result must be a pointer" {
-		t.Errorf("got unexpected error: %s", err)
+	cases := []struct {
+		name     string
+		input    map[string]interface{}
+		expected Options
+	}{
+		{
+			"present and set",
+			map[string]interface{}{"enabled": true, "count": 3, "name": "api"},
+			Options{Enabled: boolPtr(true), Count: intPtr(3), Name: stringPtr("api")},
+		},
+		{
+			"present and zero",
+			map[string]interface{}{"enabled": false, "count": 0, "name": ""},
+			Options{Enabled: boolPtr(false), Count: intPtr(0), Name: stringPtr("")},
+		},
+		{
+			"absent",
+			map[string]interface{}{},
+			Options{},
+		},
 	}
-}
 
-func TestTagged(t *testing.T) {
-	t.Parallel()
-
-	input := map[string]interface{}{
-		"foo": "bar",
-		"bar": "value",
+	for _, tc := range cases {
+		tc := tc
+		for _, weak := range []bool{false, true} {
+			var result Options
+			decoder, err := NewDecoder(&DecoderConfig{
+				WeaklyTypedInput: weak,
+				ZeroFields:       weak,
+				Result:           &result,
+			})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := decoder.Decode(tc.input); err != nil {
+				t.Fatalf("%s: err: %s", tc.name, err)
+			}
+			if !reflect.DeepEqual(tc.expected, result) {
+				t.Fatalf("%s (weak %t): expected %#v, got %#v", tc.name, weak, tc.expected, result)
+			}
+		}
 	}
 
-	var result Tagged
-	err := Decode(input, &result)
+	// Weakly typed zero values still count as present.
+	var result Options
+	decoder, err := NewDecoder(&DecoderConfig{WeaklyTypedInput: true, Result: &result})
 	if err != nil {
-		t.Fatalf("unexpected error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
-
-	if result.Value != "bar" {
-		t.Errorf("value should be 'bar', got: %#v", result.Value)
+	if err := decoder.Decode(map[string]interface{}{"enabled": "false", "count": "0"}); err != nil {
+		t.Fatalf("err: %s", err)
 	}
-
-	if result.Extra != "value" {
-		t.Errorf("extra should be 'value', got: %#v", result.Extra)
+	expected := Options{Enabled: boolPtr(false), Count: intPtr(0)}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 }
 
-func TestWeakDecode(t *testing.T) {
+func TestDecoder_MapKeyTypes(t *testing.T) {
 	t.Parallel()
 
-	input := map[string]interface{}{
-		"foo": "4",
-		"bar": "value",
+	type Color string
+	type Level int
+	type Priority uint8
+
+	type Config struct {
+		Colors     map[Color]int
+		Levels     map[Level]string
+		Priorities map[Priority]bool
+		Weights    map[float64]string
 	}
 
-	var result struct {
-		Foo int
-		Bar string
+	input := map[string]interface{}{
+		"colors":     map[string]interface{}{"red": 1, "blue": 2},
+		"levels":     map[string]interface{}{"-1": "debug", "2": "warn"},
+		"priorities": map[string]interface{}{"7": true},
+		"weights":    map[string]interface{}{"0.5": "half"},
 	}
 
-	if err := WeakDecode(input, &result); err != nil {
+	var result Config
+	if err := Decode(input, &result); err != nil {
 		t.Fatalf("err: %s", err)
 	}
-	if result.Foo != 4 {
-		t.Fatalf("bad: %#v", result)
+
+	expected := Config{
+		Colors:     map[Color]int{"red": 1, "blue": 2},
+		Levels:     map[Level]string{-1: "debug", 2: "warn"},
+		Priorities: map[Priority]bool{7: true},
+		Weights:    map[float64]string{0.5: "half"},
 	}
-	if result.Bar != "value" {
-		t.Fatalf("bad: %#v", result)
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
-}
 
-func TestWeakDecodeMetadata(t *testing.T) {
-	t.Parallel()
-
-	input := map[string]interface{}{
-		"foo":        "4",
-		"bar":        "value",
-		"unused":     "value",
-		"unexported": "value",
+	// Enum names are converted by a hook, which runs for keys too.
+	levelNames := map[string]Level{"debug": -1, "warn": 2}
+	var levels map[Level]int
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: func(f, t reflect.Type, data interface{}) (interface{}, error) {
+			if level, ok := levelNames[fmt.Sprint(data)]; ok && t == reflect.TypeOf(Level(0)) {
+				return level, nil
+			}
+			return data, nil
+		},
+		Result: &levels,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(map[string]interface{}{"debug": 10, "warn": 20, "3": 30}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := map[Level]int{-1: 10, 2: 20, 3: 30}; !reflect.DeepEqual(expected, levels) {
+		t.Fatalf("expected %#v, got %#v", expected, levels)
 	}
 
-	var md Metadata
-	var result struct {
-		Foo        int
-		Bar        string
-		unexported string
+	var bad Config
+	err = Decode(map[string]interface{}{
+		"priorities": map[string]interface{}{"300": true},
+		"levels":     map[string]interface{}{"error": "e"},
+	}, &bad)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, msg := range []string{"'Priorities' has invalid key '300'", "'Levels' has invalid key 'error'"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected error to contain %q, got %s", msg, err)
+		}
 	}
 
-	if err := WeakDecodeMetadata(input, &result, &md); err != nil {
-		t.Fatalf("err: %s", err)
+	// The key names of struct fields are converted the same way.
+	type Ranks struct {
+		First  string `mapstructure:"1"`
+		Second string `mapstructure:"2"`
 	}
-	if result.Foo != 4 {
-		t.Fatalf("bad: %#v", result)
+	var ranks map[Level]string
+	if err := Decode(Ranks{First: "gold", Second: "silver"}, &ranks); err != nil {
+		t.Fatalf("err: %s", err)
 	}
-	if result.Bar != "value" {
-		t.Fatalf("bad: %#v", result)
+	if expected := map[Level]string{1: "gold", 2: "silver"}; !reflect.DeepEqual(expected, ranks) {
+		t.Fatalf("expected %#v, got %#v", expected, ranks)
 	}
 
-	expectedKeys := []string{"Bar", "Foo"}
-	sort.Strings(md.Keys)
-	if !reflect.DeepEqual(md.Keys, expectedKeys) {
-		t.Fatalf("bad keys: %#v", md.Keys)
+	var colors map[Color]int
+	if err := Decode(struct{ Red int }{1}, &colors); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := map[Color]int{"Red": 1}; !reflect.DeepEqual(expected, colors) {
+		t.Fatalf("expected %#v, got %#v", expected, colors)
 	}
 
-	expectedUnused := []string{"unexported", "unused"}
-	sort.Strings(md.Unused)
-	if !reflect.DeepEqual(md.Unused, expectedUnused) {
-		t.Fatalf("bad unused: %#v", md.Unused)
+	var ints map[int]int
+	err = Decode(struct{ A int }{1}, &ints)
+	if err == nil || !strings.Contains(err.Error(), "'' has invalid key 'A'") {
+		t.Fatalf("expected an invalid key error, got %v", err)
 	}
 }
 
-func TestDecode_StructTaggedWithOmitempty_OmitEmptyValues(t *testing.T) {
+func TestDecoder_TrackChanges(t *testing.T) {
 	t.Parallel()
 
-	input := &StructWithOmitEmpty{}
+	type Server struct {
+		Host string
+		Port int
+	}
 
-	var emptySlice []interface{}
-	var emptyMap map[string]interface{}
-	var emptyNested *Nested
-	expected := &map[string]interface{}{
-		"visible-string": "",
-		"visible-int":    0,
-		"visible-float":  0.0,
-		"visible-slice":  emptySlice,
-		"visible-map":    emptyMap,
-		"visible-nested": emptyNested,
+	type Config struct {
+		Name    string
+		Server  Server
+		Backup  *Server
+		Hosts   []string
+		Servers []Server
+		Labels  map[string]string
 	}
 
-	actual := &map[string]interface{}{}
-	Decode(input, actual)
+	current := func() Config {
+		return Config{
+			Name:    "api",
+			Server:  Server{Host: "localhost", Port: 80},
+			Hosts:   []string{"a", "b"},
+			Servers: []Server{{Host: "x", Port: 1}},
+			Labels:  map[string]string{"env": "dev"},
+		}
+	}
+	unchanged := map[string]interface{}{
+		"name":    "api",
+		"server":  map[string]interface{}{"host": "localhost", "port": 80},
+		"hosts":   []string{"a", "b"},
+		"servers": []map[string]interface{}{{"host": "x", "port": 1}},
+		"labels":  map[string]string{"env": "dev"},
+	}
+
+	cases := []struct {
+		name     string
+		input    map[string]interface{}
+		expected []string
+	}{
+		{"no change", unchanged, []string{}},
+		{
+			"changes",
+			map[string]interface{}{
+				"name":    "api",
+				"server":  map[string]interface{}{"host": "localhost", "port": 8080},
+				"backup":  map[string]interface{}{"host": "backup"},
+				"hosts":   []string{"a", "c"},
+				"servers": []map[string]interface{}{{"host": "x", "port": 2}},
+				"labels":  map[string]string{"env": "prod"},
+			},
+			[]string{"Server.Port", "Backup.Host", "Hosts", "Servers[0].Port", "Labels"},
+		},
+	}
+
+	for _, tc := range cases {
+		result := current()
+		var md Metadata
+		decoder, err := NewDecoder(&DecoderConfig{
+			TrackChanges: true,
+			Metadata:     &md,
+			Result:       &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := decoder.Decode(tc.input); err != nil {
+			t.Fatalf("%s: err: %s", tc.name, err)
+		}
 
-	if !reflect.DeepEqual(actual, expected) {
-		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+		sort.Strings(md.Changed)
+		sort.Strings(tc.expected)
+		if !reflect.DeepEqual(tc.expected, md.Changed) {
+			t.Fatalf("%s: expected %#v, got %#v", tc.name, tc.expected, md.Changed)
+		}
 	}
 }
 
-func TestDecode_StructTaggedWithOmitempty_KeepNonEmptyValues(t *testing.T) {
+func TestDecoder_TrimStrings(t *testing.T) {
 	t.Parallel()
 
-	input := &StructWithOmitEmpty{
-		VisibleStringField: "",
-		OmitStringField:    "string",
-		VisibleIntField:    0,
-		OmitIntField:       1,
-		VisibleFloatField:  0.0,
-		OmitFloatField:     1.0,
-		VisibleSliceField:  nil,
-		OmitSliceField:     []interface{}{1},
-		VisibleMapField:    nil,
-		OmitMapField:       map[string]interface{}{"k": "v"},
-		NestedField:        nil,
-		OmitNestedField:    &Nested{},
+	type Server struct {
+		Host   string
+		Banner string `mapstructure:"banner,notrim"`
 	}
 
-	var emptySlice []interface{}
-	var emptyMap map[string]interface{}
-	var emptyNested *Nested
-	expected := &map[string]interface{}{
-		"visible-string":   "",
-		"omittable-string": "string",
-		"visible-int":      0,
-		"omittable-int":    1,
-		"visible-float":    0.0,
-		"omittable-float":  1.0,
-		"visible-slice":    emptySlice,
-		"omittable-slice":  []interface{}{1},
-		"visible-map":      emptyMap,
-		"omittable-map":    map[string]interface{}{"k": "v"},
-		"visible-nested":   emptyNested,
-		"omittable-nested": &Nested{},
+	type Config struct {
+		Name    string
+		Alias   *string
+		Servers []Server
+		Tags    []string
+		Labels  map[string]string
+		Raw     interface{}
 	}
 
-	actual := &map[string]interface{}{}
-	Decode(input, actual)
+	input := map[string]interface{}{
+		"name":  "  api\n",
+		"alias": "\tgateway ",
+		"servers": []map[string]interface{}{
+			{"host": " a.example.com ", "banner": "  welcome  "},
+		},
+		"tags":   []string{" a", "b "},
+		"labels": map[string]string{" env ": " prod "},
+		"raw":    " raw ",
+	}
 
-	if !reflect.DeepEqual(actual, expected) {
-		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	var result Config
+	decoder, err := NewDecoder(&DecoderConfig{
+		TrimStrings: true,
+		Result:      &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Config{
+		Name:    "api",
+		Alias:   stringPtr("gateway"),
+		Servers: []Server{{Host: "a.example.com", Banner: "  welcome  "}},
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]string{" env ": "prod"},
+		Raw:     " raw ",
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 }
 
-func TestDecode_mapToStruct(t *testing.T) {
-	type Target struct {
-		String    string
-		StringPtr *string
+type setterServer struct {
+	host  string
+	port  int
+	Debug bool
+}
+
+func (s *setterServer) SetHost(host string) {
+	s.host = strings.ToLower(host)
+}
+
+func (s *setterServer) SetPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("port %d out of range", port)
 	}
+	s.port = port
+	return nil
+}
 
-	expected := Target{
-		String: "hello",
+type setterLimits struct {
+	Max   int
+	Names []string
+}
+
+func (l *setterLimits) SetField(name string, value interface{}) error {
+	switch name {
+	case "Max":
+		if value.(int) < 0 {
+			return errors.New("must not be negative")
+		}
+		l.Max = value.(int)
+	case "Names":
+		l.Names = append(l.Names, value.([]string)...)
 	}
+	return nil
+}
 
-	var target Target
-	err := Decode(map[string]interface{}{
-		"string":    "hello",
-		"StringPtr": "goodbye",
-	}, &target)
+func TestDecoder_UseSetters(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		Server setterServer
+		Limits setterLimits
+	}
+
+	decode := func(input map[string]interface{}) (Config, error) {
+		result := Config{Limits: setterLimits{Names: []string{"default"}}}
+		decoder, err := NewDecoder(&DecoderConfig{
+			UseSetters: true,
+			Result:     &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return result, decoder.Decode(input)
+	}
+
+	result, err := decode(map[string]interface{}{
+		"server": map[string]interface{}{"host": "EXAMPLE.com", "port": 8080, "debug": true},
+		"limits": map[string]interface{}{"max": 10, "names": []string{"extra"}},
+	})
 	if err != nil {
-		t.Fatalf("got error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
 
-	// Pointers fail reflect test so do those manually
-	if target.StringPtr == nil || *target.StringPtr != "goodbye" {
-		t.Fatalf("bad: %#v", target)
+	expected := Config{
+		Server: setterServer{host: "example.com", port: 8080, Debug: true},
+		Limits: setterLimits{Max: 10, Names: []string{"default", "extra"}},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
-	target.StringPtr = nil
 
-	if !reflect.DeepEqual(target, expected) {
-		t.Fatalf("bad: %#v", target)
+	_, err = decode(map[string]interface{}{
+		"server": map[string]interface{}{"port": 70000},
+		"limits": map[string]interface{}{"max": -1},
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, msg := range []string{
+		"error setting 'Server.port': port 70000 out of range",
+		"error setting 'Limits.Max': must not be negative",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected error to contain %q, got %s", msg, err)
+		}
 	}
 }
 
-func TestDecoder_MatchName(t *testing.T) {
+func TestDecoder_Trace(t *testing.T) {
 	t.Parallel()
 
-	type Target struct {
-		FirstMatch  string `mapstructure:"first_match"`
-		SecondMatch string
-		NoMatch     string `mapstructure:"no_match"`
-	}
-
-	input := map[string]interface{}{
-		"first_match": "foo",
-		"SecondMatch": "bar",
-		"NO_MATCH":    "baz",
+	type Server struct {
+		Host    string
+		Timeout time.Duration
 	}
 
-	expected := Target{
-		FirstMatch:  "foo",
-		SecondMatch: "bar",
+	type Config struct {
+		Name   string
+		Server Server
 	}
 
-	var actual Target
-	config := &DecoderConfig{
-		Result: &actual,
-		MatchName: func(mapKey, fieldName string) bool {
-			return mapKey == fieldName
+	input := map[string]interface{}{
+		"name": "api",
+		"server": map[string]interface{}{
+			"host":    "localhost",
+			"timeout": "5s",
+			"legacy":  true,
 		},
 	}
 
-	decoder, err := NewDecoder(config)
+	var events []string
+	var result Config
+	decoder, err := NewDecoder(&DecoderConfig{
+		DecodeHook: StringToTimeDurationHookFunc(),
+		Trace: func(event TraceEvent) {
+			if event.Path == "" {
+				return
+			}
+			events = append(events, fmt.Sprintf("%s %s %v", event.Kind, event.Path, event.Value))
+		},
+		Result: &result,
+	})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
-
-	err = decoder.Decode(input)
-	if err != nil {
+	if err := decoder.Decode(input); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
-	if !reflect.DeepEqual(expected, actual) {
-		t.Fatalf("Decode() expected: %#v, got: %#v", expected, actual)
+	expected := []string{
+		"enter field Name api",
+		"hook applied Name api",
+		"assigned value Name api",
+		"enter field Server map[host:localhost legacy:true timeout:5s]",
+		"hook applied Server map[host:localhost legacy:true timeout:5s]",
+		"enter field Server.Host localhost",
+		"hook applied Server.Host localhost",
+		"assigned value Server.Host localhost",
+		"enter field Server.Timeout 5s",
+		"hook applied Server.Timeout 5s",
+		"assigned value Server.Timeout 5s",
+		"unused key Server.legacy true",
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Fatalf("expected %#v, got %#v", expected, events)
 	}
 }
 
-func TestDecoder_IgnoreUntaggedFields(t *testing.T) {
-	type Input struct {
-		UntaggedNumber int
-		TaggedNumber   int `mapstructure:"tagged_number"`
-		UntaggedString string
-		TaggedString   string `mapstructure:"tagged_string"`
+func TestDecoder_AppendOption(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		Plugins []string `mapstructure:"plugins,append"`
+		Hosts   []string `mapstructure:"hosts"`
 	}
-	input := &Input{
-		UntaggedNumber: 31,
-		TaggedNumber:   42,
-		UntaggedString: "hidden",
-		TaggedString:   "visible",
+
+	var result Config
+	for _, input := range []map[string]interface{}{
+		{"plugins": []string{"a", "b"}, "hosts": []string{"x"}},
+		{"plugins": []string{"c"}, "hosts": []string{"y"}},
+	} {
+		if err := Decode(input, &result); err != nil {
+			t.Fatalf("err: %s", err)
+		}
 	}
 
-	actual := make(map[string]interface{})
-	config := &DecoderConfig{
-		Result:               &actual,
-		IgnoreUntaggedFields: true,
+	expected := Config{Plugins: []string{"a", "b", "c"}, Hosts: []string{"y"}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 
-	decoder, err := NewDecoder(config)
+	// ZeroFields clears the slice first.
+	decoder, err := NewDecoder(&DecoderConfig{ZeroFields: true, Result: &result})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
-
-	err = decoder.Decode(input)
-	if err != nil {
+	if err := decoder.Decode(map[string]interface{}{"plugins": []string{"d"}}); err != nil {
 		t.Fatalf("err: %s", err)
 	}
+	if !reflect.DeepEqual([]string{"d"}, result.Plugins) {
+		t.Fatalf("bad: %#v", result.Plugins)
+	}
+}
 
-	expected := map[string]interface{}{
-		"tagged_number": 42,
-		"tagged_string": "visible",
+func TestDecoder_FallbackTagNames(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		Name     string `json:"name"`
+		Port     int    `json:"port" yaml:"listen_port"`
+		Host     string `yaml:"hostname"`
+		Timeout  int    `mapstructure:"timeout_ms" json:"timeout"`
+		Password string `json:"-"`
+		Debug    bool
 	}
 
-	if !reflect.DeepEqual(expected, actual) {
-		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, actual)
+	input := map[string]interface{}{
+		"name":       "api",
+		"port":       80,
+		"hostname":   "localhost",
+		"timeout_ms": 500,
+		"timeout":    1,
+		"password":   "secret",
+		"debug":      true,
 	}
-}
 
-func TestDecoder_IgnoreUntaggedFieldsWithStruct(t *testing.T) {
-	type Output struct {
-		UntaggedInt    int
-		TaggedNumber   int `mapstructure:"tagged_number"`
-		UntaggedString string
-		TaggedString   string `mapstructure:"tagged_string"`
+	var result Config
+	var md Metadata
+	decoder, err := NewDecoder(&DecoderConfig{
+		FallbackTagNames: []string{"json", "yaml"},
+		Metadata:         &md,
+		Result:           &result,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
-	input := map[interface{}]interface{}{
-		"untaggedint":     31,
-		"tagged_number":   41,
-		"untagged_string": "hidden",
-		"tagged_string":   "visible",
+	if err := decoder.Decode(input); err != nil {
+		t.Fatalf("err: %s", err)
 	}
-	actual := Output{}
-	config := &DecoderConfig{
-		Result:               &actual,
-		IgnoreUntaggedFields: true,
+
+	expected := Config{Name: "api", Port: 80, Host: "localhost", Timeout: 500, Debug: true}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 
-	decoder, err := NewDecoder(config)
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	sort.Strings(md.Unused)
+	if expected := []string{"password", "timeout"}; !reflect.DeepEqual(expected, md.Unused) {
+		t.Fatalf("expected unused %#v, got %#v", expected, md.Unused)
 	}
 
-	err = decoder.Decode(input)
+	// The options of fallback tags apply when encoding too.
+	type Output struct {
+		Name string `json:"name,omitempty"`
+		Port int    `json:"port"`
+	}
+	out, err := Encode(Output{Port: 80}, func(c *DecoderConfig) {
+		c.FallbackTagNames = []string{"json"}
+	})
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
+	if expected := map[string]interface{}{"port": 80}; !reflect.DeepEqual(expected, out) {
+		t.Fatalf("expected %#v, got %#v", expected, out)
+	}
+}
 
-	expected := Output{
-		TaggedNumber: 41,
-		TaggedString: "visible",
+func TestDecoder_ErrorOnHeterogeneousSlice(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		Ports []int
+		Hosts []string
 	}
-	if !reflect.DeepEqual(expected, actual) {
-		t.Fatalf("Decode() expected: %#v\ngot: %#v", expected, actual)
+
+	decode := func(input map[string]interface{}) (Config, error) {
+		var result Config
+		decoder, err := NewDecoder(&DecoderConfig{
+			WeaklyTypedInput:          true,
+			ErrorOnHeterogeneousSlice: true,
+			Result:                    &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return result, decoder.Decode(input)
 	}
-}
 
-func testSliceInput(t *testing.T, input map[string]interface{}, expected *Slice) {
-	var result Slice
-	err := Decode(input, &result)
+	// Slices of a single kind are converted as usual.
+	result, err := decode(map[string]interface{}{
+		"ports": []interface{}{"80", "443"},
+		"hosts": []interface{}{nil, "a", "b"},
+	})
 	if err != nil {
-		t.Fatalf("got error: %s", err)
+		t.Fatalf("err: %s", err)
 	}
-
-	if result.Vfoo != expected.Vfoo {
-		t.Errorf("Vfoo expected '%s', got '%s'", expected.Vfoo, result.Vfoo)
+	if expected := (Config{Ports: []int{80, 443}, Hosts: []string{"", "a", "b"}}); !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 
-	if result.Vbar == nil {
-		t.Fatalf("Vbar a slice, got '%#v'", result.Vbar)
+	_, err = decode(map[string]interface{}{
+		"ports": []interface{}{1, "2", 3, true, int64(5)},
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, msg := range []string{
+		"'Ports[1]' has type 'string' in a slice of mixed types, expected 'int'",
+		"'Ports[3]' has type 'bool' in a slice of mixed types, expected 'int'",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected error to contain %q, got %s", msg, err)
+		}
 	}
+	for _, index := range []string{"Ports[0]", "Ports[2]", "Ports[4]"} {
+		if strings.Contains(err.Error(), index) {
+			t.Fatalf("expected no error for %s, got %s", index, err)
+		}
+	}
+}
 
-	if len(result.Vbar) != len(expected.Vbar) {
-		t.Errorf("Vbar length should be %d, got %d", len(expected.Vbar), len(result.Vbar))
+func TestDecoder_KeyCaseTransform(t *testing.T) {
+	t.Parallel()
+
+	type Config struct {
+		MaxConnections int `mapstructure:"max_connections"`
+		IdleTimeout    string
+		HTTPPort       int
+		Name           string `mapstructure:"serverName"`
 	}
 
-	for i, v := range result.Vbar {
-		if v != expected.Vbar[i] {
-			t.Errorf(
-				"Vbar[%d] should be '%#v', got '%#v'",
-				i, expected.Vbar[i], v)
+	decode := func(transform KeyCaseTransform, input map[string]interface{}) (Config, error) {
+		var result Config
+		decoder, err := NewDecoder(&DecoderConfig{
+			KeyCaseTransform: transform,
+			ErrorUnused:      true,
+			Result:           &result,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
 		}
+		return result, decoder.Decode(input)
 	}
-}
 
-func testArrayInput(t *testing.T, input map[string]interface{}, expected *Array) {
-	var result Array
-	err := Decode(input, &result)
+	result, err := decode(SnakeCase, map[string]interface{}{
+		"maxConnections": 10,
+		"idle-timeout":   "5s",
+		"http_port":      8080,
+		"serverName":     "api",
+	})
 	if err != nil {
-		t.Fatalf("got error: %s", err)
+		t.Fatalf("err: %s", err)
+	}
+	expected := Config{MaxConnections: 10, IdleTimeout: "5s", HTTPPort: 8080, Name: "api"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
 	}
 
-	if result.Vfoo != expected.Vfoo {
-		t.Errorf("Vfoo expected '%s', got '%s'", expected.Vfoo, result.Vfoo)
+	// Tags win: the tag isn't converted, so a key only matches it once
+	// converted itself.
+	_, err = decode(SnakeCase, map[string]interface{}{"server_name": "api"})
+	if err == nil || !strings.Contains(err.Error(), "invalid keys: server_name") {
+		t.Fatalf("expected an unused key error, got %v", err)
 	}
 
-	if result.Vbar == [2]string{} {
-		t.Fatalf("Vbar a slice, got '%#v'", result.Vbar)
+	// A key matching as is wins over one matching once converted.
+	result, err = decode(KebabCase, map[string]interface{}{
+		"max_connections": 10,
+		"max-connections": 20,
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid keys: max-connections") {
+		t.Fatalf("expected an unused key error, got %v", err)
+	}
+	if result.MaxConnections != 10 {
+		t.Fatalf("expected 10, got %d", result.MaxConnections)
 	}
 
-	if len(result.Vbar) != len(expected.Vbar) {
-		t.Errorf("Vbar length should be %d, got %d", len(expected.Vbar), len(result.Vbar))
+	// Without a transform, converted keys don't match.
+	_, err = decode(KeyCaseNone, map[string]interface{}{"maxConnections": 10})
+	if err == nil || !strings.Contains(err.Error(), "invalid keys: maxConnections") {
+		t.Fatalf("expected an unused key error, got %v", err)
 	}
+}
 
-	for i, v := range result.Vbar {
-		if v != expected.Vbar[i] {
-			t.Errorf(
-				"Vbar[%d] should be '%#v', got '%#v'",
-				i, expected.Vbar[i], v)
+func TestDecoder_ByteSliceRange(t *testing.T) {
+	t.Parallel()
+
+	for _, weak := range []bool{false, true} {
+		var result struct {
+			Data []byte
+		}
+		decoder, err := NewDecoder(&DecoderConfig{WeaklyTypedInput: weak, Result: &result})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		for _, input := range []interface{}{
+			[]interface{}{104, 105},
+			[]interface{}{float64(104), uint16(105)},
+			[]int{104, 105},
+			[]byte("hi"),
+		} {
+			if err := decoder.Decode(map[string]interface{}{"data": input}); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(result.Data) != "hi" {
+				t.Fatalf("expected %q, got %q", "hi", result.Data)
+			}
+		}
+
+		err = decoder.Decode(map[string]interface{}{
+			"data": []interface{}{0, 255, 256, -1, 104, float64(300), uint64(1 << 40)},
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		for _, msg := range []string{
+			"'Data[2]' value 256 is out of range for a byte (0-255)",
+			"'Data[3]' value -1 is out of range for a byte (0-255)",
+			"'Data[5]' value 300 is out of range for a byte (0-255)",
+			"'Data[6]' value 1099511627776 is out of range for a byte (0-255)",
+		} {
+			if !strings.Contains(err.Error(), msg) {
+				t.Fatalf("expected error to contain %q, got %s", msg, err)
+			}
+		}
+		for _, index := range []string{"Data[0]", "Data[1]", "Data[4]"} {
+			if strings.Contains(err.Error(), index) {
+				t.Fatalf("expected no error for %s, got %s", index, err)
+			}
 		}
 	}
 }
@@ -0,0 +1,112 @@
+package mapstructure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version, as described by https://semver.org.
+type semver struct {
+	Major uint64
+	Minor uint64
+	Patch uint64
+
+	// Prerelease and Build are the dot-separated identifiers following
+	// the "-" and the "+" of the version, if any.
+	Prerelease string
+	Build      string
+}
+
+// parseSemver parses a semantic version such as "1.2.3", "1.2.3-rc.1" or
+// "1.2.3+build.5". A leading "v" is accepted, and so are the shorthands
+// "1" and "1.2", which stand for "1.0.0" and "1.2.0".
+func parseSemver(s string) (semver, error) {
+	var v semver
+
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		if !validSemverIdentifiers(v.Build, false) {
+			return semver{}, fmt.Errorf("invalid semantic version %q: invalid build metadata", s)
+		}
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		if !validSemverIdentifiers(v.Prerelease, true) {
+			return semver{}, fmt.Errorf("invalid semantic version %q: invalid prerelease", s)
+		}
+		rest = rest[:i]
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return semver{}, fmt.Errorf("invalid semantic version %q: too many components", s)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if !isSemverNumber(part) {
+			return semver{}, fmt.Errorf("invalid semantic version %q: invalid number %q", s, part)
+		}
+
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return semver{}, fmt.Errorf("invalid semantic version %q: %w", s, err)
+		}
+		*numbers[i] = n
+	}
+
+	return v, nil
+}
+
+// String returns the canonical form of the version, without a leading "v".
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// isSemverNumber reports whether s is a number without leading zeros.
+func isSemverNumber(s string) bool {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validSemverIdentifiers reports whether s is made of non-empty
+// dot-separated identifiers of ASCII letters, digits and hyphens. Numeric
+// prerelease identifiers must not have leading zeros.
+func validSemverIdentifiers(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+
+		numeric := true
+		for _, r := range id {
+			switch {
+			case r >= '0' && r <= '9':
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '-':
+				numeric = false
+			default:
+				return false
+			}
+		}
+		if prerelease && numeric && !isSemverNumber(id) {
+			return false
+		}
+	}
+	return true
+}
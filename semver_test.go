@@ -0,0 +1,61 @@
+package mapstructure
+
+import (
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		input     string
+		expected  semver
+		canonical string
+		err       bool
+	}{
+		{"1.2.3", semver{Major: 1, Minor: 2, Patch: 3}, "1.2.3", false},
+		{"v1.2.3", semver{Major: 1, Minor: 2, Patch: 3}, "1.2.3", false},
+		{"1.2", semver{Major: 1, Minor: 2}, "1.2.0", false},
+		{"v2", semver{Major: 2}, "2.0.0", false},
+		{"0.0.0", semver{}, "0.0.0", false},
+		{
+			"1.0.0-rc.1",
+			semver{Major: 1, Prerelease: "rc.1"},
+			"1.0.0-rc.1",
+			false,
+		},
+		{
+			"1.0.0-alpha-1.x+build.5-a",
+			semver{Major: 1, Prerelease: "alpha-1.x", Build: "build.5-a"},
+			"1.0.0-alpha-1.x+build.5-a",
+			false,
+		},
+		{"1.0.0+007", semver{Major: 1, Build: "007"}, "1.0.0+007", false},
+		{"", semver{}, "", true},
+		{"v", semver{}, "", true},
+		{"1.2.3.4", semver{}, "", true},
+		{"01.2.3", semver{}, "", true},
+		{"1.x.3", semver{}, "", true},
+		{"1.2.-3", semver{}, "", true},
+		{"1.2.3-", semver{}, "", true},
+		{"1.2.3-rc..1", semver{}, "", true},
+		{"1.2.3-01", semver{}, "", true},
+		{"1.2.3-rc_1", semver{}, "", true},
+		{"1.2.3+", semver{}, "", true},
+		{"1.2.3+a+b", semver{}, "", true},
+		{"99999999999999999999.0.0", semver{}, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := parseSemver(tc.input)
+		if tc.err != (err != nil) {
+			t.Fatalf("%q: expected err %#v, got %v", tc.input, tc.err, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("%q: expected %#v, got %#v", tc.input, tc.expected, actual)
+		}
+		if !tc.err && actual.String() != tc.canonical {
+			t.Fatalf("%q: expected %q, got %q", tc.input, tc.canonical, actual.String())
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package mapstructure
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2/internal/errors"
+)
+
+// structEntry is an entry of the map a struct is flattened into when it is
+// decoded into another struct, as built by decodeMapFromStruct.
+type structEntry struct {
+	key string
+
+	// ptr is the value of the field and val the value it is flattened
+	// from, which is the struct ptr points to if ptr is a pointer to a
+	// struct with tagged fields.
+	ptr, val reflect.Value
+
+	// value is the value of the entry in the map, if it was computed.
+	value interface{}
+
+	// flatten is set if val is a struct that has to be flattened into a
+	// map, because it isn't copied into a struct field by copyStruct.
+	flatten bool
+}
+
+// canCopyStruct reports whether a struct of type from can be decoded into
+// a struct of type to by copyStruct, with the same result as flattening it
+// into a map first. This is the case when neither the configuration nor the
+// tags of the fields use a feature that depends on the map, such as hooks,
+// metadata, squashing or the ",remain" option.
+func (d *decoder) canCopyStruct(from, to reflect.Type) bool {
+	c := d.config
+	if d.encode || c.DecodeHook != nil || len(d.hooks) > 0 || c.FlattenHook != nil ||
+		c.Metadata != nil || c.ErrorUnused || c.ErrorUnset || c.OnUnused != nil || c.OnUnset != nil || c.Trace != nil ||
+		c.DetectDuplicates || c.ErrorDuplicates || c.StripFieldPrefix != "" ||
+		c.EncodeFieldName != nil || c.AllowUnexportedFields || c.UseSetters || c.MaxDepth > 0 {
+		return false
+	}
+
+	return d.hasCopyableFields(from) && d.hasCopyableFields(to)
+}
+
+// hasCopyableFields reports whether no field of the struct type typ is
+// embedded or has tag options other than "omitempty" and "omitzero", which
+// only matter to the struct being flattened.
+func (d *decoder) hasCopyableFields(typ reflect.Type) bool {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous {
+			return false
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		for _, option := range strings.Split(fieldTag(f, d.config), ",")[1:] {
+			if option != "omitempty" && option != "omitzero" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// canCopyStructField reports whether the struct val can be decoded into the
+// struct field of type typ by copyStruct, rather than through decodeField.
+func (d *decoder) canCopyStructField(val reflect.Value, typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || isTimeType(typ) {
+		return false
+	}
+
+	// These receive the input as is, which would be the struct instead of
+	// the map it is flattened into.
+	ptr := reflect.PtrTo(typ)
+	if ptr.Implements(reflect.TypeOf((*Unmarshaler)(nil)).Elem()) ||
+		ptr.Implements(reflect.TypeOf((*MergeUnmarshaler)(nil)).Elem()) ||
+		ptr.Implements(reflect.TypeOf((*OrderedMapSetter)(nil)).Elem()) {
+		return false
+	}
+
+	return d.canCopyStruct(val.Type(), typ)
+}
+
+// copyStruct decodes the struct dataVal into the struct val field by field,
+// without flattening dataVal into a map first. It must only be used if
+// canCopyStruct reports it gives the same result.
+func (d *decoder) copyStruct(name string, dataVal, val reflect.Value) error {
+	entries := d.structEntries(dataVal)
+
+	// Match the fields of the target with the entries as
+	// decodeStructFromMap does with the keys of a map.
+	typ := val.Type()
+	matches := make([]int, typ.NumField())
+	fieldVals := make([]reflect.Value, typ.NumField())
+	for i := range matches {
+		matches[i] = -1
+
+		// Like decodeStructFromMap, decode into the struct a field
+		// points to, if any.
+		fieldVals[i] = val.Field(i)
+		if fieldVals[i].Kind() == reflect.Ptr && fieldVals[i].Elem().Kind() == reflect.Struct {
+			fieldVals[i] = fieldVals[i].Elem()
+		}
+
+		f := typ.Field(i)
+		tagValue := fieldTag(f, d.config)
+		if !d.isFieldIncluded(tagValue) {
+			continue
+		}
+		fieldName := strings.Split(tagValue, ",")[0]
+		if fieldName == "-" {
+			continue
+		}
+		tagged := fieldName != ""
+		if !tagged {
+			fieldName = f.Name
+		}
+
+		matches[i] = d.matchStructEntry(name, entries, fieldName, tagged)
+		if matches[i] < 0 || !fieldVals[i].CanSet() {
+			matches[i] = -1
+			continue
+		}
+
+		entry := &entries[matches[i]]
+		if entry.val.Kind() == reflect.Struct && !isTimeType(entry.val.Type()) && !d.canCopyStructField(entry.val, fieldVals[i].Type()) {
+			entry.flatten = true
+		}
+	}
+
+	// Flatten the structs that aren't copied, including those that aren't
+	// decoded, which may fail as they would in decodeMapFromStruct.
+	for i := range entries {
+		entry := &entries[i]
+		if entry.val.Kind() != reflect.Struct {
+			entry.value = entry.val.Interface()
+			continue
+		}
+
+		if isTimeType(entry.val.Type()) {
+			// As in decodeMapFromStruct, times are kept as is.
+			entry.value = stripMonotonic(entry.val).Interface()
+			continue
+		}
+
+		matched := false
+		for _, match := range matches {
+			matched = matched || match == i
+		}
+		if matched && !entry.flatten {
+			continue
+		}
+
+		value, err := d.flattenStructEntry(entry)
+		if err != nil {
+			return err
+		}
+		entry.value = value
+	}
+
+	var errs []error
+	for i, match := range matches {
+		if match < 0 {
+			continue
+		}
+
+		f := typ.Field(i)
+		fieldName := strings.Split(fieldTag(f, d.config), ",")[0]
+		if fieldName == "" {
+			fieldName = f.Name
+		}
+		if name != "" {
+			fieldName = name + "." + fieldName
+		}
+
+		entry := &entries[match]
+		var err error
+		if entry.val.Kind() == reflect.Struct && !isTimeType(entry.val.Type()) && !entry.flatten {
+			err = d.copyStructEntry(fieldName, entry, fieldVals[i])
+		} else {
+			err = d.decodeField(fieldName, entry.value, fieldVals[i], f, nil, 0)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// structEntries returns the entries of the map the struct dataVal would be
+// flattened into, in the order of its fields. The values of the entries
+// aren't computed.
+func (d *decoder) structEntries(dataVal reflect.Value) []structEntry {
+	typ := dataVal.Type()
+	entries := make([]structEntry, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tagValue := fieldTag(f, d.config)
+		if !d.isFieldIncluded(tagValue) {
+			continue
+		}
+
+		ptr := dataVal.Field(i)
+		v := dereferencePtrToStructIfNeeded(ptr, d.config)
+
+		keyName := f.Name
+		tagParts := strings.Split(tagValue, ",")
+		if tagParts[0] == "-" {
+			continue
+		}
+		if tagParts[0] != "" {
+			keyName = tagParts[0]
+		}
+
+		skip := false
+		for _, option := range tagParts[1:] {
+			skip = skip || option == "omitempty" && isEmptyValue(v) || option == "omitzero" && isZeroValue(ptr)
+		}
+		if skip {
+			continue
+		}
+
+		// Later fields replace the earlier ones with the same key, as
+		// they would in a map.
+		entry := structEntry{key: keyName, ptr: ptr, val: v}
+		replaced := false
+		for j := range entries {
+			if entries[j].key == keyName {
+				entries[j], replaced = entry, true
+				break
+			}
+		}
+		if !replaced {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// matchStructEntry returns the index of the entry matching fieldName, or -1
+// if there is none, like lookupMapKey does for the keys of a map.
+func (d *decoder) matchStructEntry(path string, entries []structEntry, fieldName string, tagged bool) int {
+	for i, entry := range entries {
+		if entry.key == fieldName {
+			return i
+		}
+	}
+
+	for i, entry := range entries {
+		if d.matchName(path, entry.key, fieldName) {
+			return i
+		}
+	}
+
+	for i, entry := range entries {
+		if d.matchKeyCase(path, entry.key, fieldName, tagged) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// flattenStructEntry returns the map the struct of entry is flattened into,
+// or nil if it is part of a cycle and BreakCycles is set.
+func (d *decoder) flattenStructEntry(entry *structEntry) (interface{}, error) {
+	visited, err := d.visitStructEntry(entry)
+	if err != nil || visited == nil {
+		return nil, err
+	}
+	if *visited != (visitedPtr{}) {
+		defer delete(d.state.visiting, *visited)
+	}
+
+	x := reflect.New(entry.val.Type())
+	x.Elem().Set(entry.val)
+
+	m := make(map[string]interface{})
+	addrVal := reflect.New(reflect.TypeOf(m))
+	addrVal.Elem().Set(reflect.ValueOf(m))
+	if err := d.decode(entry.key, x.Interface(), addrVal.Elem()); err != nil {
+		return nil, err
+	}
+	return addrVal.Elem().Interface(), nil
+}
+
+// copyStructEntry decodes the struct of entry into the struct field val
+// with copyStruct.
+func (d *decoder) copyStructEntry(name string, entry *structEntry, val reflect.Value) error {
+	visited, err := d.visitStructEntry(entry)
+	if err != nil {
+		return err
+	}
+	if visited == nil {
+		// A broken cycle decodes like a nil value.
+		return d.decodeField(name, nil, val, reflect.StructField{}, nil, 0)
+	}
+	if *visited != (visitedPtr{}) {
+		defer delete(d.state.visiting, *visited)
+	}
+
+	return d.copyStruct(name, entry.val, val)
+}
+
+// visitStructEntry marks the pointer the struct of entry was reached
+// through as visited, and returns it, or the zero visitedPtr if the struct
+// isn't behind a pointer. It returns nil if the pointer is already visited
+// and BreakCycles is set, and an error if it isn't set.
+func (d *decoder) visitStructEntry(entry *structEntry) (*visitedPtr, error) {
+	if entry.ptr.Kind() != reflect.Ptr {
+		return &visitedPtr{}, nil
+	}
+
+	key := visitedPtr{entry.ptr.Pointer(), entry.ptr.Type()}
+	if _, ok := d.state.visiting[key]; ok {
+		if !d.config.BreakCycles {
+			return nil, fmt.Errorf("'%s' contains a cycle through a pointer of type '%s'", entry.key, entry.ptr.Type())
+		}
+		return nil, nil
+	}
+
+	if d.state.visiting == nil {
+		d.state.visiting = make(map[visitedPtr]struct{})
+	}
+	d.state.visiting[key] = struct{}{}
+	return &key, nil
+}
@@ -0,0 +1,222 @@
+package mapstructure
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type copySourceAddress struct {
+	Street string
+	City   string `mapstructure:"town"`
+}
+
+type copyTargetAddress struct {
+	Street string
+	Town   string
+	Zip    string
+}
+
+type copySource struct {
+	Name     string
+	Age      int `mapstructure:"years"`
+	Emails   []string
+	Tags     map[string]string
+	Address  copySourceAddress
+	Billing  *copySourceAddress
+	Shipping *copySourceAddress
+	Raw      copySourceAddress
+	Same     copyTargetAddress
+	Created  time.Time
+	Updated  *time.Time
+	Nickname string `mapstructure:",omitempty"`
+	Secret   string `mapstructure:"-"`
+	Score    float64
+	internal string
+}
+
+type copyTarget struct {
+	NAME     string
+	Years    int64
+	Emails   []string
+	Tags     map[string]string
+	Address  copyTargetAddress
+	Billing  *copyTargetAddress
+	Shipping *copyTargetAddress
+	Raw      interface{}
+	Same     copyTargetAddress
+	Created  time.Time
+	Updated  *time.Time
+	Nickname string
+	Secret   string
+	Score    string
+	internal string
+}
+
+type copyNode struct {
+	Name string `mapstructure:"name"`
+	Next *copyNode
+}
+
+type copyOtherNode struct {
+	Name string `mapstructure:"name"`
+	Next *copyOtherNode
+}
+
+// decodeStructBothWays decodes input into the results of newResult with
+// the given configuration, once copying structs directly and once through
+// a map by setting a DecodeHook that does nothing, and fails if the results
+// or errors differ. It returns the result and error of the direct copy.
+func decodeStructBothWays(t *testing.T, config DecoderConfig, input interface{}, newResult func() interface{}) (interface{}, error) {
+	t.Helper()
+
+	decode := func(config DecoderConfig) (interface{}, error) {
+		result := newResult()
+		config.Result = result
+		decoder, err := NewDecoder(&config)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return result, decoder.Decode(input)
+	}
+
+	copied, copyErr := decode(config)
+	config.DecodeHook = func(from, to reflect.Type, data interface{}) (interface{}, error) {
+		return data, nil
+	}
+	mapped, mapErr := decode(config)
+
+	if (copyErr != nil) != (mapErr != nil) {
+		t.Fatalf("expected the same error, got %v when copying and %v through a map", copyErr, mapErr)
+	}
+	if !reflect.DeepEqual(copied, mapped) {
+		t.Fatalf("expected the same result, got %#v when copying and %#v through a map", copied, mapped)
+	}
+	return copied, copyErr
+}
+
+func TestCopyStruct(t *testing.T) {
+	t.Parallel()
+
+	updated := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	input := copySource{
+		Name:     "api",
+		Age:      3,
+		Emails:   []string{"a@example.com"},
+		Tags:     map[string]string{"env": "prod"},
+		Address:  copySourceAddress{Street: "Main", City: "Springfield"},
+		Billing:  &copySourceAddress{Street: "Elm"},
+		Raw:      copySourceAddress{Street: "Oak", City: "Shelbyville"},
+		Same:     copyTargetAddress{Zip: "12345"},
+		Created:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Updated:  &updated,
+		Secret:   "hunter2",
+		Score:    1.5,
+		internal: "hidden",
+	}
+
+	newResult := func() interface{} {
+		return &copyTarget{
+			Nickname: "keep",
+			Secret:   "keep",
+			Shipping: &copyTargetAddress{Zip: "99999"},
+			Same:     copyTargetAddress{Street: "keep"},
+			internal: "keep",
+		}
+	}
+
+	for _, config := range []DecoderConfig{
+		{},
+		{WeaklyTypedInput: true},
+		{ZeroFields: true, WeaklyTypedInput: true},
+		{DecodeNil: true, WeaklyTypedInput: true},
+	} {
+		if !(&decoder{Decoder: &Decoder{config: &config}}).canCopyStruct(reflect.TypeOf(copySource{}), reflect.TypeOf(copyTarget{})) {
+			t.Fatalf("expected %#v to copy structs directly", config)
+		}
+		_, _ = decodeStructBothWays(t, config, input, newResult)
+	}
+
+	result, err := decodeStructBothWays(t, DecoderConfig{WeaklyTypedInput: true}, &input, newResult)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &copyTarget{
+		NAME:     "api",
+		Years:    3,
+		Emails:   []string{"a@example.com"},
+		Tags:     map[string]string{"env": "prod"},
+		Address:  copyTargetAddress{Street: "Main", Town: "Springfield"},
+		Billing:  &copyTargetAddress{Street: "Elm"},
+		Shipping: &copyTargetAddress{Zip: "99999"},
+		Raw:      map[string]interface{}{"Street": "Oak", "town": "Shelbyville"},
+		Same:     copyTargetAddress{Street: "", Zip: "12345"},
+		Created:  input.Created,
+		Updated:  &updated,
+		Nickname: "keep",
+		Secret:   "keep",
+		Score:    "1.5",
+		internal: "keep",
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestCopyStruct_Errors(t *testing.T) {
+	t.Parallel()
+
+	newResult := func() interface{} { return &copyTarget{} }
+	_, err := decodeStructBothWays(t, DecoderConfig{}, copySource{Score: 1}, newResult)
+	if err == nil || !strings.Contains(err.Error(), "'Score' expected type 'string', got unconvertible type 'float64'") {
+		t.Fatalf("expected a conversion error, got %v", err)
+	}
+
+	a := &copyNode{Name: "a"}
+	a.Next = &copyNode{Name: "b", Next: a}
+	newNode := func() interface{} { return &copyOtherNode{} }
+
+	_, err = decodeStructBothWays(t, DecoderConfig{}, a, newNode)
+	if err == nil || !strings.Contains(err.Error(), "contains a cycle through a pointer of type '*mapstructure.copyNode'") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+
+	result, err := decodeStructBothWays(t, DecoderConfig{BreakCycles: true}, a, newNode)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if node := result.(*copyOtherNode); node.Name != "a" || node.Next == nil || node.Next.Name != "b" {
+		t.Fatalf("bad: %#v", node)
+	}
+}
+
+func TestCopyStruct_Fallback(t *testing.T) {
+	t.Parallel()
+
+	type Squashed struct {
+		copySourceAddress `mapstructure:",squash"`
+	}
+
+	from, to := reflect.TypeOf(copySource{}), reflect.TypeOf(copyTarget{})
+	for _, config := range []DecoderConfig{
+		{DecodeHook: StringToTimeHookFunc(time.RFC3339)},
+		{Metadata: &Metadata{}},
+		{ErrorUnused: true},
+		{Trace: func(TraceEvent) {}},
+		{OnUnset: func(string) error { return nil }},
+	} {
+		config := config
+		setConfigDefaults(&config)
+		if (&decoder{Decoder: &Decoder{config: &config}}).canCopyStruct(from, to) {
+			t.Fatalf("expected %#v not to copy structs directly", config)
+		}
+	}
+
+	config := DecoderConfig{}
+	setConfigDefaults(&config)
+	if (&decoder{Decoder: &Decoder{config: &config}}).canCopyStruct(reflect.TypeOf(Squashed{}), to) {
+		t.Fatal("expected squashed structs not to be copied directly")
+	}
+}